@@ -0,0 +1,88 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/brightzheng100/helm-packager/pkg/diff"
+	"github.com/spf13/cobra"
+)
+
+var diffCmdLongDesc = `  Diff command compares two bundles, or a bundle and a bundle spec, by their charts and images.
+
+  Usage:
+
+  helm-packager diff <BUNDLE_DIR | BUNDLE_MANIFEST | BUNDLE_SPEC_FILE> <BUNDLE_DIR | BUNDLE_MANIFEST | BUNDLE_SPEC_FILE>
+   [-o text|json]
+
+  It tells which charts are added or removed, which versions of the charts are changed,
+  and which images of the charts are added, removed, retagged, or moved to another digest.
+  A bundle spec is resolved against the remote repositories and registries, without pulling the images.
+
+  Examples:
+
+  # Compare the bundle to be pulled with the bundle pulled last time
+
+  helm-packager diff ./charts bundle.yaml
+
+  # Compare two bundles, in JSON
+
+  helm-packager diff ./charts-2023.11 ./charts-2023.12 -o json
+`
+
+var d = &diffOptions{}
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Diff command compares two bundles, or a bundle and a bundle spec, by their charts and images",
+	Long:  diffCmdLongDesc,
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runDiff(d, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVarP(&d.output, "output", "o", "text", "Optional, the output format, text or json")
+}
+
+type diffOptions struct {
+	output string
+}
+
+func runDiff(d *diffOptions, args []string) {
+	ctx := context.Background()
+
+	if d.output != "text" && d.output != "json" {
+		fmt.Fprintf(os.Stderr, "invalid --output %s, expected text or json\n", d.output)
+		os.Exit(1)
+	}
+
+	from, err := diff.Load(ctx, args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	to, err := diff.Load(ctx, args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	result := diff.Compare(from, to)
+	if d.output == "json" {
+		if err := result.PrintJSON(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	result.Print(os.Stdout)
+}
@@ -5,14 +5,26 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/archive"
+	"github.com/brightzheng100/helm-packager/pkg/audit"
 	"github.com/brightzheng100/helm-packager/pkg/chartloader"
 	"github.com/brightzheng100/helm-packager/pkg/chartwriter"
 	"github.com/brightzheng100/helm-packager/pkg/imageswriter"
 	"github.com/brightzheng100/helm-packager/pkg/pipeline"
+	"github.com/brightzheng100/helm-packager/pkg/sbom"
+	"github.com/brightzheng100/helm-packager/pkg/spec"
+	"github.com/brightzheng100/helm-packager/pkg/transformer"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+	"github.com/docker/go-units"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"helm.sh/helm/v3/pkg/provenance"
 )
 
 var pullCmdLongDesc = `  Pull command pulls the remote Helm charts and their images to local.
@@ -21,9 +33,38 @@ var pullCmdLongDesc = `  Pull command pulls the remote Helm charts and their ima
 
   helm-packager pull
     --from-chart-repo <REMOTE_REPOSITORY_URL>
-    --from-charts <CHART_NAME>[:<CHART_VERSION>][,<CHART_NAME>[:<CHART_VERSION>]]
-   [--to-dir <CHARTS_DIR>]
+    --from-charts <CHART_NAME>[:<CHART_VERSION>][:last-<N>-minors][,<CHART_NAME>[:<CHART_VERSION>][:last-<N>-minors]]
+    | -f <BUNDLE_SPEC_FILE>
+   [--to-dir <CHARTS_DIR> | --archive <ARCHIVE_FILE> [--archive-volume-size <SIZE>]]
    [--char-files-included true/false]
+   [--sbom spdx|cyclonedx]
+   [--audit table|json]
+   [--layout name/version|name]
+   [--chart-repo-index true/false]
+   [--chart-repo-url <BASE_URL>]
+   [--rewrite-registry <SOURCE_REGISTRY>=<TARGET_REGISTRY>[,<SOURCE_REGISTRY>=<TARGET_REGISTRY>]]
+   [--rewrite-version-suffix <VERSION_SUFFIX>]
+   [--pin-digests [--rewrite-digests]]
+   [--strip-tests true/false]
+   [--annotations <KEY>=<VALUE>[,<KEY>=<VALUE>]]
+   [--include-images <PATTERN>[,<PATTERN>]]
+   [--exclude-images <PATTERN>[,<PATTERN>]]
+   [--images-file <IMAGES_FILE>]
+   [--image-policy <IMAGE_POLICY_FILE>]
+   [--include-hooks true/false]
+   [--drop-test-hooks true/false]
+   [--extra-images <CHART_NAME>=<IMAGE>[,<IMAGE>]]...
+   [--platform <OS>/<ARCH>[/<VARIANT>][,<OS>/<ARCH>[/<VARIANT>]] | all]
+   [--copy-artifacts]
+   [--since <PREVIOUS_BUNDLE_MANIFEST>]
+   [--verify [--keyring <KEYRING>]]
+   [--sign --sign-key <KEY_NAME> [--sign-keyring <SECRET_KEYRING>] [--sign-passphrase-file <PASSPHRASE_FILE>]]
+   [--continue-on-error]
+   [--retry-attempts <ATTEMPTS>]
+   [--retry-backoff <DURATION>]
+   [--retry-max-backoff <DURATION>]
+   [--retry-jitter <FRACTION>]
+   [--retry-honor-retry-after true/false]
 
   Examples:
 
@@ -39,6 +80,121 @@ var pullCmdLongDesc = `  Pull command pulls the remote Helm charts and their ima
   helm-packager pull \
     --from-chart-repo oci://registry-1.docker.io/bitnamicharts \
     --from-charts apache:10.2.3,nginx
+
+  # Pull all the versions of Helm chart "nginx" matching ^15.0.0, and the latest patches of the last 3 minor versions of "apache"
+
+  helm-packager pull \
+    --from-chart-repo oci://registry-1.docker.io/bitnamicharts \
+    --from-charts nginx:^15.0.0,apache:last-3-minors \
+    --to-dir ./charts
+
+  # Pull Helm charts "apache" and "nginx" into a folder which can be served by a static HTTP server as Helm chart repository
+
+  helm-packager pull \
+    --from-chart-repo oci://registry-1.docker.io/bitnamicharts \
+    --from-charts apache,nginx \
+    --to-dir ./charts \
+    --chart-repo-index \
+    --chart-repo-url https://charts.example.com
+
+  # Pull Helm chart "nginx" with its image references in values.yaml rewritten to point at the private registry
+
+  helm-packager pull \
+    --from-chart-repo oci://registry-1.docker.io/bitnamicharts \
+    --from-charts nginx \
+    --to-dir ./charts \
+    --rewrite-registry docker.io=my.registry.com/mirror
+
+  # Pull Helm chart "nginx" with its images pinned to the digests their tags resolve to at pull time,
+  # where the image references in values.yaml are rewritten to <REPOSITORY>:<TAG>@sha256:<DIGEST> too
+
+  helm-packager pull \
+    --from-chart-repo oci://registry-1.docker.io/bitnamicharts \
+    --from-charts nginx \
+    --to-dir ./charts \
+    --pin-digests \
+    --rewrite-digests
+
+  # Pull the Helm charts and their images listed in the bundle spec file
+
+  helm-packager pull -f bundle.yaml
+
+  # Pull Helm chart "nginx" with the images of its hooks, except its test hooks, and without the metrics exporter
+
+  helm-packager pull \
+    --from-chart-repo oci://registry-1.docker.io/bitnamicharts \
+    --from-charts nginx \
+    --to-dir ./charts \
+    --include-hooks \
+    --drop-test-hooks \
+    --exclude-images '*/nginx-exporter:*'
+
+  # Pull Helm chart "nginx", failing if any of its images violates the image policy, e.g. not from the approved registries
+
+  helm-packager pull \
+    --from-chart-repo oci://registry-1.docker.io/bitnamicharts \
+    --from-charts nginx \
+    --to-dir ./charts \
+    --image-policy ./image-policy.yaml
+
+  # Pull Helm chart "nginx" and its images, with their cosign signatures, attestations and SBOMs, and OCI referrers
+
+  helm-packager pull \
+    --from-chart-repo https://charts.bitnami.com/bitnami \
+    --from-charts nginx \
+    --to-dir ./charts \
+    --copy-artifacts
+
+  # Pull a delta bundle, which leaves out the charts and image blobs in the previous bundle
+
+  helm-packager pull -f bundle.yaml \
+    --to-dir ./charts-delta \
+    --since ./charts/bundle-manifest.yaml
+
+  # Pull Helm chart "nginx" with its provenance verified, where the .prov file is kept next to the chart archive
+
+  helm-packager pull \
+    --from-chart-repo https://charts.example.com \
+    --from-charts nginx \
+    --to-dir ./charts \
+    --verify \
+    --keyring ~/.gnupg/pubring.gpg
+
+  # Pull Helm chart "nginx" with its images rewritten, where the re-packaged chart is signed by the local key,
+  # with its passphrase read from the file, or from $HELM_KEY_PASSPHRASE if no file is given
+
+  helm-packager pull \
+    --from-chart-repo https://charts.example.com \
+    --from-charts nginx \
+    --to-dir ./charts \
+    --rewrite-registry docker.io=harbor.example.com \
+    --sign \
+    --sign-key "Packager <packager@example.com>" \
+    --sign-keyring ~/.gnupg/secring.gpg \
+    --sign-passphrase-file ./passphrase.txt
+
+  # Pull Helm chart "nginx" and its images, with the SBOM of the bundle, bundle.spdx.json, written next to the bundle manifest
+
+  helm-packager pull \
+    --from-chart-repo oci://registry-1.docker.io/bitnamicharts \
+    --from-charts nginx \
+    --to-dir ./charts \
+    --sbom spdx
+
+  # Audit the license, maintainers, source URLs and deprecation of Helm chart "nginx" and its subcharts, as JSON,
+  # flagging the deprecated or unlicensed charts, without pulling the images
+
+  helm-packager pull \
+    --from-chart-repo oci://registry-1.docker.io/bitnamicharts \
+    --from-charts nginx \
+    --audit json
+
+  # Pull into one compressed archive, split into the volumes of 4GiB for FAT32 media,
+  # i.e. bundle.tar.zst.000, bundle.tar.zst.001, and so on
+
+  helm-packager pull -f bundle.yaml \
+    --archive bundle.tar.zst \
+    --archive-volume-size 4GiB
 `
 
 var p = &pull{}
@@ -49,6 +205,7 @@ var pullCmd = &cobra.Command{
 	Short: "Pull command pulls the remote Helm charts and their images to local",
 	Long:  pullCmdLongDesc,
 	Run: func(cmd *cobra.Command, args []string) {
+		p.flags = cmd.Flags()
 		runPull(p, args)
 	},
 }
@@ -57,55 +214,466 @@ func init() {
 	rootCmd.AddCommand(pullCmd)
 
 	pullCmd.Flags().StringVar(&p.fromChartRepo, "from-chart-repo", "", "Helm repository URL, e.g. https://charts.bitnami.com/bitnami")
-	pullCmd.Flags().StringSliceVar(&p.fromCharts, "from-charts", []string{}, "Helm chart(s) with optional version tag, separated by commar, e.g. apache:10.2.3,nginx")
+	pullCmd.Flags().StringSliceVar(&p.fromCharts, "from-charts", []string{}, "Helm chart(s) with optional version or version constraint, and optional last-<N>-minors, separated by commar, e.g. apache:10.2.3,nginx:^15.0.0,redis:last-3-minors")
 	pullCmd.Flags().StringVar(&p.toDir, "to-dir", "", "Optional, the directory for pulled Helm charts and their images' tarball files. When not specified, the command will only print out the structure")
+	pullCmd.Flags().StringVar(&p.archive, "archive", "", "Optional, the archive to stream the pulled Helm charts and their images into, with the bundle manifest and the checksums embedded, instead of --to-dir, compressed by its extension, .tar.zst, .tar.gz, .tgz or .tar")
+	pullCmd.Flags().StringVar(&p.archiveVolumeSize, "archive-volume-size", "", "Optional, the size of the volumes to split --archive into, e.g. 4GiB for FAT32 media, which are suffixed with .000, .001, and so on")
+	pullCmd.MarkFlagsMutuallyExclusive("to-dir", "archive")
 	pullCmd.Flags().BoolVar(&p.chartFilesIncluded, "char-files-included", false, "Optional, the flag to indicate whether the chart files should be included and pulled")
+	pullCmd.Flags().StringVar(&p.layout, "layout", "", "Optional, the layout of the charts and their images in --to-dir, name/version for ${chartName}/${chartVersion}/, which is the default, or name for ${chartName}/, which keeps only one version of a chart")
+	pullCmd.Flags().StringVar(&p.sbom, "sbom", "", "Optional, generate the SBOM of the bundle, with the charts and images as the components and the dependencies of the charts on their images, in the format, spdx for bundle.spdx.json, or cyclonedx for bundle.cdx.json, next to the bundle manifest")
+	pullCmd.Flags().StringVar(&p.audit, "audit", "", "Optional, print the audit of the license, maintainers, source URLs and deprecation of the charts and their subcharts, as a table or json, instead of the structure, flagging the deprecated or unlicensed charts, with the report printed to stderr")
+	pullCmd.Flags().BoolVar(&p.chartRepoIndex, "chart-repo-index", false, "Optional, the flag to indicate whether the charts should be laid out flat in --to-dir with a generated index.yaml, as a static Helm chart repository")
+	pullCmd.Flags().StringVar(&p.chartRepoURL, "chart-repo-url", "", "Optional, the base URL which the urls in the generated index.yaml are relative to, e.g. https://charts.example.com")
+
+	pullCmd.Flags().StringToStringVar(&p.rewriteRegistry, "rewrite-registry", map[string]string{}, "Optional, the registry mapping to rewrite the image references in the charts' values.yaml with, e.g. docker.io=my.registry.com/mirror, or *=my.registry.com for any registry")
+	pullCmd.Flags().StringVar(&p.rewriteVersionSuffix, "rewrite-version-suffix", "+mirror", "Optional, the suffix appended to the version of the charts changed by --rewrite-registry, --strip-tests or --annotations, starting with + or -; the patch version will be bumped instead if it's empty")
+
+	pullCmd.Flags().BoolVar(&p.pinDigests, "pin-digests", false, "Optional, resolve the tags of the images to their digests at pull time, pull the images by the digests, and record the references pinned to them in the bundle manifest; the images are saved as OCI image layouts")
+	pullCmd.Flags().BoolVar(&p.rewriteDigests, "rewrite-digests", false, "Optional, rewrite the image references in the charts' values.yaml to the ones pinned by --pin-digests, e.g. nginx:1.25.3@sha256:..., with the version suffixed by --rewrite-version-suffix")
+
+	pullCmd.Flags().BoolVar(&p.stripTests, "strip-tests", false, "Optional, the flag to indicate whether the test templates should be stripped from the charts")
+	pullCmd.Flags().StringToStringVar(&p.annotations, "annotations", map[string]string{}, "Optional, the annotations to stamp into the charts' Chart.yaml, e.g. example.com/packaged-by=helm-packager")
+
+	pullCmd.Flags().StringSliceVar(&p.includeImages, "include-images", []string{}, "Optional, the glob patterns, or the regex patterns prefixed with regex:, of the only images to pull, e.g. docker.io/bitnami/*")
+	pullCmd.Flags().StringSliceVar(&p.excludeImages, "exclude-images", []string{}, "Optional, the glob patterns, or the regex patterns prefixed with regex:, of the images to skip, e.g. '*/nginx-exporter:*'")
+	pullCmd.Flags().StringVar(&p.imagesFile, "images-file", "", "Optional, the YAML file with the include and exclude patterns of the images, globally and per chart, which are merged with --include-images and --exclude-images")
+	pullCmd.Flags().StringVar(&p.imagePolicy, "image-policy", "", "Optional, the YAML file of the image policy, with the allowed registries, denied repositories, required digest and disallowed tags, which the images extracted from the charts are checked against, instead of images.policy of the bundle spec")
+	pullCmd.Flags().StringArrayVar(&p.extraImages, "extra-images", []string{}, "Optional, the extra images of a chart to pull, which can't be extracted from it, e.g. nginx=docker.io/bitnami/os-shell:11,docker.io/bitnami/git:2; can be repeated for multiple charts")
+	pullCmd.Flags().BoolVar(&p.includeHooks, "include-hooks", false, "Optional, the flag to indicate whether the images of the Helm hooks, annotated with helm.sh/hook, should be pulled too")
+	pullCmd.Flags().BoolVar(&p.dropTestHooks, "drop-test-hooks", false, "Optional, the flag to indicate whether the images of the Helm test hooks, annotated with helm.sh/hook: test, should be skipped when --include-hooks is set")
+
+	pullCmd.Flags().StringSliceVar(&p.platforms, "platform", []string{}, "Optional, the platform(s) of the images to pull, e.g. linux/amd64,linux/arm64, or all; with multiple platforms, the images are saved as OCI image layouts")
+	pullCmd.Flags().BoolVar(&p.copyArtifacts, "copy-artifacts", false, "Optional, copy the cosign signatures, attestations and SBOMs, and the OCI referrers, of the images, which are saved next to the images and pushed together with them; the images are saved as OCI image layouts")
+	pullCmd.Flags().StringVar(&p.since, "since", "", "Optional, the bundle manifest of the previous bundle, e.g. ./charts/bundle-manifest.yaml, to pull a delta bundle which leaves out the charts and image blobs in it; the images are saved as OCI image layouts")
+	pullCmd.Flags().BoolVar(&p.verify, "verify", false, "Optional, verify the provenance of the charts, failing on any chart without a valid signature, and keep the .prov files next to the chart archives; the charts transformed, e.g. by --rewrite-registry, drop their .prov files")
+	pullCmd.Flags().StringVar(&p.keyring, "keyring", utils.DefaultKeyring(), "Optional, the keyring of the public keys to verify the provenance of the charts with")
+	pullCmd.Flags().BoolVar(&p.sign, "sign", false, "Optional, sign the charts re-packaged, e.g. transformed by --rewrite-registry, with the local PGP key, writing their .prov files next to the chart archives, as helm package --sign does")
+	pullCmd.Flags().StringVar(&p.signKey, "sign-key", "", "Optional, the name of the key to sign the charts with, required by --sign")
+	pullCmd.Flags().StringVar(&p.signKeyring, "sign-keyring", utils.DefaultKeyring(), "Optional, the keyring of the secret key to sign the charts with")
+	pullCmd.Flags().StringVar(&p.signPassphraseFile, "sign-passphrase-file", "", "Optional, the file of the passphrase of the key to sign the charts with, which is read from $HELM_KEY_PASSPHRASE if it's not given")
+	pullCmd.MarkFlagsRequiredTogether("sign", "sign-key")
+	pullCmd.Flags().BoolVar(&p.continueOnError, "continue-on-error", false, "Optional, carry on with the rest of charts and images when a chart or an image fails, and fail with all the failures listed at the end")
+
+	retry := utils.DefaultRetryPolicy()
+	pullCmd.Flags().IntVar(&p.retry.Attempts, "retry-attempts", retry.Attempts, "Optional, the max attempts of the calls to chart repositories and image registries on transient failures, like 5xx, 429 and connection resets; 1 means no retry")
+	pullCmd.Flags().DurationVar(&p.retry.Backoff, "retry-backoff", retry.Backoff, "Optional, the backoff before the first retry, which doubles for every retry")
+	pullCmd.Flags().DurationVar(&p.retry.MaxBackoff, "retry-max-backoff", retry.MaxBackoff, "Optional, the max backoff between retries")
+	pullCmd.Flags().Float64Var(&p.retry.Jitter, "retry-jitter", retry.Jitter, "Optional, the fraction, from 0 to 1, of the backoff to randomize")
+	pullCmd.Flags().BoolVar(&p.retry.HonorRetryAfter, "retry-honor-retry-after", retry.HonorRetryAfter, "Optional, the flag to indicate whether to wait as long as the Retry-After header of the 429 and 503 responses tells, up to --retry-max-backoff")
 
-	pullCmd.MarkFlagRequired("from-chart-repo")
-	pullCmd.MarkFlagRequired("from-charts")
+	pullCmd.Flags().StringVarP(&p.file, "file", "f", "", "The bundle spec file, which lists the repositories, charts, images and output of the bundle, instead of --from-chart-repo and --from-charts")
+	pullCmd.MarkFlagsRequiredTogether("from-chart-repo", "from-charts")
+	pullCmd.MarkFlagsOneRequired("file", "from-chart-repo")
+	pullCmd.MarkFlagsMutuallyExclusive("file", "from-chart-repo")
+	pullCmd.MarkFlagsMutuallyExclusive("file", "from-charts")
 }
 
 type pull struct {
+	file string
+
 	fromChartRepo      string
 	fromCharts         []string
 	toDir              string
+	archive            string
+	archiveVolumeSize  string
 	chartFilesIncluded bool
+	sbom               string
+	audit              string
+	layout             string
+	chartRepoIndex     bool
+	chartRepoURL       string
+
+	rewriteRegistry      map[string]string
+	rewriteVersionSuffix string
+	pinDigests           bool
+	rewriteDigests       bool
+	stripTests           bool
+	annotations          map[string]string
+
+	includeImages []string
+	excludeImages []string
+	imagesFile    string
+	imagePolicy   string
+	extraImages   []string
+	includeHooks  bool
+	dropTestHooks bool
+
+	platforms     []string
+	copyArtifacts bool
+
+	since string
+
+	verify  bool
+	keyring string
+
+	sign               bool
+	signKey            string
+	signKeyring        string
+	signPassphraseFile string
+
+	continueOnError bool
+
+	retry api.RetryPolicy
+
+	// flags are the flags parsed, to tell which ones are set explicitly
+	flags *pflag.FlagSet
 }
 
 func runPull(pull *pull, args []string) {
 	ctx := context.Background()
 
-	var cl api.ChartLoader
+	var cls []api.ChartLoader
 	var cw api.ChartWriter
 	var iw api.ImagesWriter
 
-	if pull.toDir == "" {
-		cl = chartloader.NewRemoteChartLoader(pull.fromChartRepo, pull.fromCharts, ".")
+	// the bundle spec is the base, on top of which the flags apply
+	imageFilter := api.ImageFilter{}
+	extraImages := map[string][]string{}
+	valuesFiles := map[string][]string{}
+	var imagePolicy *api.ImagePolicy
+	var bundle *spec.Bundle
+	if pull.file != "" {
+		var err error
+		bundle, err = spec.Load(pull.file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		pull.applyBundle(pull.flags, bundle)
+		imageFilter = bundle.ImageFilter()
+		extraImages = bundle.ExtraImages()
+		valuesFiles = bundle.ValuesFiles()
+		imagePolicy = bundle.Images.Policy
+	}
+
+	if pull.imagesFile != "" {
+		imagesFile, err := utils.LoadImagesFile(pull.imagesFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		imageFilter = mergeImageFilters(imageFilter, imagesFile.ImageFilter)
+		for chartName, images := range imagesFile.ExtraImages {
+			extraImages[chartName] = append(extraImages[chartName], images...)
+		}
+	}
+	if pull.imagePolicy != "" {
+		var err error
+		imagePolicy, err = utils.LoadImagePolicy(pull.imagePolicy)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	imageFilter.Include = append(imageFilter.Include, pull.includeImages...)
+	imageFilter.Exclude = append(imageFilter.Exclude, pull.excludeImages...)
+	if err := imageFilter.Compile(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid image filter: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, extra := range pull.extraImages {
+		chartName, images, ok := strings.Cut(extra, "=")
+		chartName = strings.TrimSpace(chartName)
+		if !ok || chartName == "" || strings.TrimSpace(images) == "" {
+			fmt.Fprintf(os.Stderr, "invalid --extra-images %s, expected <CHART_NAME>=<IMAGE>[,<IMAGE>]\n", extra)
+			os.Exit(1)
+		}
+		for _, image := range strings.Split(images, ",") {
+			image = strings.TrimSpace(image)
+			if image == "" {
+				fmt.Fprintf(os.Stderr, "invalid --extra-images %s, expected <CHART_NAME>=<IMAGE>[,<IMAGE>]\n", extra)
+				os.Exit(1)
+			}
+			extraImages[chartName] = append(extraImages[chartName], image)
+		}
+	}
+
+	layout, err := utils.ParseLayout(pull.layout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var since *api.Manifest
+	var sinceDigest string
+	if pull.since != "" {
+		since, err = utils.LoadManifest(pull.since)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		digest, err := provenance.DigestFile(pull.since)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sinceDigest = "sha256:" + digest
+	}
+
+	if pull.rewriteDigests && !pull.pinDigests {
+		fmt.Fprintln(os.Stderr, "--rewrite-digests requires --pin-digests")
+		os.Exit(1)
+	}
+	// the image indexes re-made for the platforms have other digests than the ones pinned in the charts
+	if pull.rewriteDigests && len(pull.platforms) > 0 && !slices.Contains(pull.platforms, utils.AllPlatforms) {
+		fmt.Fprintln(os.Stderr, "--rewrite-digests can't be used with --platform other than all, as the image indexes are re-made for the platforms with other digests")
+		os.Exit(1)
+	}
+
+	// the flat chart repository is written into --to-dir, or the archive, with the base URL of its index.yaml
+	if pull.chartRepoURL != "" && !pull.chartRepoIndex {
+		fmt.Fprintln(os.Stderr, "--chart-repo-url requires --chart-repo-index")
+		os.Exit(1)
+	}
+	if pull.chartRepoIndex && pull.toDir == "" && pull.archive == "" {
+		fmt.Fprintln(os.Stderr, "--chart-repo-index requires --to-dir or --archive")
+		os.Exit(1)
+	}
+
+	var sbomFormat sbom.Format
+	if pull.sbom != "" {
+		if pull.toDir == "" && pull.archive == "" {
+			fmt.Fprintln(os.Stderr, "--sbom requires --to-dir or --archive")
+			os.Exit(1)
+		}
+		sbomFormat, err = sbom.ParseFormat(pull.sbom)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var auditFormat audit.Format
+	if pull.audit != "" {
+		auditFormat, err = audit.ParseFormat(pull.audit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var signer *provenance.Signatory
+	if pull.sign {
+		signer, err = newSigner(pull.signKeyring, pull.signKey, pull.signPassphraseFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// the archive is streamed from the staging directory, where every chart and its images are removed once archived
+	var aw *archive.Writer
+	var loadDir string
+
+	// fail exits on the error, with the staging directories and the partial archive removed
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+		if aw != nil {
+			aw.Abort()
+			os.RemoveAll(pull.toDir)
+		}
+		if loadDir != "" {
+			os.RemoveAll(loadDir)
+		}
+		os.Exit(1)
+	}
+
+	if pull.archive != "" {
+		var volumeSize int64
+		if pull.archiveVolumeSize != "" {
+			volumeSize, err = units.RAMInBytes(pull.archiveVolumeSize)
+			if err != nil || volumeSize <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid --archive-volume-size %s, expected a size like 4GiB\n", pull.archiveVolumeSize)
+				os.Exit(1)
+			}
+		}
+		aw, err = archive.Create(pull.archive, volumeSize)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		pull.toDir, err = os.MkdirTemp("", "helm-packager-")
+		if err != nil {
+			fail(err)
+		}
+	}
+
+	toDir := pull.toDir
+	if toDir == "" {
+		toDir = "."
 		cw = chartwriter.NewStdoutChartWriter()
 		iw = imageswriter.NewStdoutImagesWriter()
 	} else {
 		// create folder if needed
 		if _, err := os.Stat(pull.toDir); os.IsNotExist(err) {
-			err := os.Mkdir(pull.toDir, 0766)
+			err := os.MkdirAll(pull.toDir, 0766)
 			if err != nil {
 				panic(err)
 			}
 		}
 
-		cl = chartloader.NewRemoteChartLoader(pull.fromChartRepo, pull.fromCharts, pull.toDir)
-		cw = chartwriter.NewFileChartWriter(pull.toDir)
+		if pull.chartRepoIndex {
+			cw = chartwriter.NewRepoChartWriter(pull.toDir, pull.chartRepoURL)
+		} else {
+			cw = chartwriter.NewFileChartWriter(pull.toDir)
+		}
 		iw = imageswriter.NewFileImagesWriter(pull.toDir)
 	}
 
-	cp := pipeline.NewBuilder(ctx).
-		WithChartLoader(cl).
+	// the charts are downloaded into a staging directory, so that nothing but what the writers write is in --to-dir,
+	// e.g. neither the upstream chart of a version transformed, nor the chart files unless they're included
+	loadDir, err = os.MkdirTemp("", "helm-packager-charts-")
+	if err != nil {
+		fail(err)
+	}
+
+	var registryAuth map[string]api.RegistryAuth
+	if bundle != nil {
+		cls, err = bundle.Loaders(loadDir)
+		if err == nil {
+			registryAuth, err = bundle.RegistryAuth()
+		}
+		if err != nil {
+			fail(err)
+		}
+	} else {
+		cls = append(cls, chartloader.NewRemoteChartLoader(pull.fromChartRepo, pull.fromCharts, loadDir))
+	}
+
+	pb := pipeline.NewBuilder(ctx).
 		WithChartWriter(cw).
 		WithImagesWriter(iw).
 		ConfigureChartFilesIncluded(pull.chartFilesIncluded).
-		Complete()
+		ConfigureLayout(layout).
+		ConfigureValuesFiles(valuesFiles).
+		ConfigureImagePolicy(imagePolicy).
+		ConfigureImageFilter(imageFilter).
+		ConfigureExtraImages(extraImages).
+		ConfigureHooks(pull.includeHooks, pull.dropTestHooks).
+		ConfigurePlatforms(pull.platforms).
+		ConfigureCopyArtifacts(pull.copyArtifacts).
+		ConfigurePinDigests(pull.pinDigests).
+		ConfigureVerify(pull.verify, pull.keyring).
+		ConfigureSigner(signer).
+		ConfigureContinueOnError(pull.continueOnError).
+		ConfigureRegistryAuth(registryAuth).
+		ConfigureRetry(pull.retry)
+	for _, cl := range cls {
+		pb.WithChartLoader(cl)
+	}
+	if aw != nil {
+		pb.WithArchive(pull.toDir, aw)
+	} else if pull.toDir != "" {
+		pb.WithBundleManifest(pull.toDir)
+	}
+	if since != nil {
+		pb.ConfigureSince(since, sinceDigest)
+	}
+	if sbomFormat != "" {
+		pb.WithSBOM(sbomFormat)
+	}
+	if auditFormat != "" {
+		pb.WithAudit(auditFormat)
+	}
 
-	err := cp.Process()
+	// the images are rewritten at last, as the images are extracted from the charts before rewriting
+	if pull.stripTests {
+		pb.WithTransformers(transformer.NewStripTestsTransformer(pull.rewriteVersionSuffix))
+	}
+	if len(pull.annotations) > 0 {
+		pb.WithTransformers(transformer.NewAnnotationTransformer(pull.annotations, pull.rewriteVersionSuffix))
+	}
+	if len(pull.rewriteRegistry) > 0 || pull.rewriteDigests {
+		pb.WithTransformers(transformer.NewImageTransformer(pull.rewriteRegistry, pull.rewriteVersionSuffix).WithPinDigests(pull.rewriteDigests))
+	}
+
+	cp := pb.Complete()
+
+	err = cp.Process()
+	os.RemoveAll(loadDir)
+	if aw != nil {
+		os.RemoveAll(pull.toDir)
+		for _, volume := range aw.Volumes() {
+			fmt.Fprintf(os.Stderr, "archived into %s\n", volume)
+		}
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// applyBundle applies the output and images settings of the bundle spec, unless they're set by the flags
+func (pull *pull) applyBundle(flags *pflag.FlagSet, bundle *spec.Bundle) {
+	if !flags.Changed("to-dir") && !flags.Changed("archive") {
+		pull.toDir = bundle.Output.Dir
+	}
+	if !flags.Changed("char-files-included") {
+		pull.chartFilesIncluded = bundle.Output.ChartFilesIncluded
+	}
+	if !flags.Changed("chart-repo-index") {
+		pull.chartRepoIndex = bundle.Output.ChartRepoIndex
+	}
+	if !flags.Changed("chart-repo-url") {
+		pull.chartRepoURL = bundle.Output.ChartRepoURL
+	}
+	if !flags.Changed("layout") {
+		pull.layout = bundle.Output.Layout
+	}
+	if !flags.Changed("sbom") {
+		pull.sbom = bundle.Output.SBOM
+	}
+	if !flags.Changed("audit") {
+		pull.audit = bundle.Output.Audit
+	}
+	if !flags.Changed("platform") {
+		pull.platforms = bundle.Images.Platforms
+	}
+	if !flags.Changed("include-hooks") {
+		pull.includeHooks = bundle.Images.IncludeHooks
+	}
+	if !flags.Changed("drop-test-hooks") {
+		pull.dropTestHooks = bundle.Images.DropTestHooks
+	}
+	if !flags.Changed("copy-artifacts") {
+		pull.copyArtifacts = bundle.Images.CopyArtifacts
+	}
+	if !flags.Changed("pin-digests") {
+		pull.pinDigests = bundle.Images.PinDigests
+	}
+}
+
+// mergeImageFilters merges the patterns of the image filters, globally and per chart
+func mergeImageFilters(a, b api.ImageFilter) api.ImageFilter {
+	merged := api.ImageFilter{
+		Include: append(append([]string{}, a.Include...), b.Include...),
+		Exclude: append(append([]string{}, a.Exclude...), b.Exclude...),
+		Charts:  map[string]api.ImageFilter{},
+	}
+	for _, charts := range []map[string]api.ImageFilter{a.Charts, b.Charts} {
+		for chartName, filter := range charts {
+			merged.Charts[chartName] = mergeImageFilters(merged.Charts[chartName], filter)
+		}
+	}
+	return merged
+}
+
+// newSigner creates the signer of the charts, with the passphrase of the key read from the file,
+// or from $HELM_KEY_PASSPHRASE as Helm does, if any
+func newSigner(keyring, key, passphraseFile string) (*provenance.Signatory, error) {
+	var passphrase []byte
+	if passphraseFile != "" {
+		data, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read passphrase file %s: %w", passphraseFile, err)
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		passphrase = []byte(strings.TrimSuffix(line, "\r"))
+	} else if env, ok := os.LookupEnv("HELM_KEY_PASSPHRASE"); ok {
+		passphrase = []byte(env)
 	}
+	return utils.NewSigner(keyring, key, passphrase)
 }
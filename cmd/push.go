@@ -4,8 +4,15 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
 
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/archive"
+	"github.com/brightzheng100/helm-packager/pkg/pusher"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+	"github.com/brightzheng100/helm-packager/pkg/verifier"
 	"github.com/spf13/cobra"
 )
 
@@ -14,10 +21,15 @@ var pushCmdLongDesc = `  Push command pushs the exported local Helm charts and t
   Usage:
 
   helm-packager push \
-    --from-dir <EXPORTED DIR WITH CHARTS AND IMAGES> \
-   [--from-charts <CHART_NAME>[,<CHART_NAME>] \
+    --from-dir <EXPORTED DIR WITH CHARTS AND IMAGES> | --from-archive <ARCHIVE FILE OR ITS FIRST VOLUME> \
+   [--from-charts <CHART_NAME>[:<CHART_VERSION>][,<CHART_NAME>[:<CHART_VERSION>]]] \
     --to-chart-repo <TARGETED HELM REPOSITORY TO PUSH CHARTS TO> \
-    --to-image-registry <TARGETED IMAGE REGISTRY TO PUSH IMAGES TO>
+   [--to-image-registry <TARGETED IMAGE REGISTRY TO PUSH IMAGES TO>] \
+   [--username <USERNAME> --password <PASSWORD>] \
+   [--plain-http true/false] \
+   [--verify [--keyring <KEYRING>]] \
+   [--verify-image-signatures --key <PUBLIC_KEY> [--key <PUBLIC_KEY>]...] \
+   [--continue-on-error]
 
   Examples:
 
@@ -35,6 +47,46 @@ var pushCmdLongDesc = `  Push command pushs the exported local Helm charts and t
     --from-charts apache,nginx \
     --to-chart-repo https://my.chart.repo \
     --to-image-resitry https://my.docker.registry
+
+  # Push the bundle pulled with --rewrite-registry, whose images are pushed by the same registry mapping,
+  # i.e. to where its charts point at, while --to-image-registry is only for the registries not in the mapping
+
+  helm-packager push \
+    --from-dir ./_charts \
+    --to-chart-repo oci://my.docker.registry/charts
+
+  # Push the delta bundle, pulled with --since, after the previous bundle, where the charts and image blobs
+  # left out of it are expected in the targets already
+
+  helm-packager push \
+    --from-dir ./_charts-delta \
+    --to-chart-repo oci://my.docker.registry/charts \
+    --to-image-registry my.docker.registry/mirror
+
+  # Push the charts pulled with --verify, whose provenance is verified again, and pushed together
+
+  helm-packager push \
+    --from-dir ./_charts \
+    --to-chart-repo oci://my.docker.registry/charts \
+    --to-image-registry my.docker.registry/mirror \
+    --verify
+
+  # Push the bundle pulled with --copy-artifacts, where every image must be signed by the approved key,
+  # which is verified offline before anything is pushed
+
+  helm-packager push \
+    --from-dir ./_charts \
+    --to-chart-repo oci://my.docker.registry/charts \
+    --to-image-registry my.docker.registry/mirror \
+    --verify-image-signatures \
+    --key cosign.pub
+
+  # Push the bundle pulled with --archive, from the archive or its volumes, which is verified by its checksums first
+
+  helm-packager push \
+    --from-archive /media/usb/bundle.tar.zst.000 \
+    --to-chart-repo oci://my.docker.registry/charts \
+    --to-image-registry my.docker.registry/mirror
 `
 
 var s = &push{}
@@ -53,22 +105,99 @@ func init() {
 	rootCmd.AddCommand(pushCmd)
 
 	pushCmd.Flags().StringVar(&s.fromDir, "from-dir", "", "Local directory that has exported Helm charts and images, e.g. ./charts")
+	pushCmd.Flags().StringVar(&s.fromArchive, "from-archive", "", "The archive pulled with --archive, or its first volume, e.g. bundle.tar.zst or bundle.tar.zst.000, instead of --from-dir")
 	pushCmd.Flags().StringSliceVar(&s.fromCharts, "from-charts", []string{}, "Helm chart(s) with optional version tag, separated by commar, e.g. apache:10.2.3,nginx")
-	pushCmd.Flags().StringVar(&s.toChartRepo, "to-chart-repo", "", "The target Helm chart repository URL")
-	pushCmd.Flags().StringVar(&s.toImageRegistry, "to-image-registry", "", "The target image registry URL")
-
-	pushCmd.MarkFlagRequired("from-dir")
+	pushCmd.Flags().StringVar(&s.toChartRepo, "to-chart-repo", "", "The target Helm chart repository URL, an OCI registry, e.g. oci://my.registry.com/charts, or a ChartMuseum, e.g. https://my.chart.repo")
+	pushCmd.Flags().StringVar(&s.toImageRegistry, "to-image-registry", "", "The target image registry with optional namespace, e.g. my.registry.com/mirror, which is optional for the bundle pulled with --rewrite-registry, whose images are pushed by the same registry mapping")
+	pushCmd.Flags().StringVar(&s.username, "username", "", "Optional, the username of the ChartMuseum, while the OCI registries use the credentials of helm registry login and docker login")
+	pushCmd.Flags().StringVar(&s.password, "password", "", "Optional, the password of the ChartMuseum")
+	pushCmd.Flags().BoolVar(&s.plainHTTP, "plain-http", false, "Optional, the flag to indicate whether to push to the OCI registries over plain HTTP")
+	pushCmd.Flags().BoolVar(&s.verify, "verify", false, "Optional, verify the provenance of the charts again before pushing them, failing on any chart without a valid signature")
+	pushCmd.Flags().StringVar(&s.keyring, "keyring", utils.DefaultKeyring(), "Optional, the keyring of the public keys to verify the provenance of the charts with")
+	pushCmd.Flags().BoolVar(&s.verifyImageSignatures, "verify-image-signatures", false, "Optional, verify the cosign signatures of the images, copied with pull --copy-artifacts, by the public keys of --key, before anything is pushed")
+	pushCmd.Flags().StringSliceVar(&s.keys, "key", []string{}, "Optional, the cosign public key, e.g. cosign.pub, which any image can be signed by; can be repeated for multiple keys")
+	pushCmd.MarkFlagsRequiredTogether("verify-image-signatures", "key")
+	pushCmd.Flags().BoolVar(&s.continueOnError, "continue-on-error", false, "Optional, carry on with the rest of charts and images when a chart or an image fails, and fail with all the failures listed at the end")
+	pushCmd.MarkFlagsRequiredTogether("username", "password")
+
+	retry := utils.DefaultRetryPolicy()
+	pushCmd.Flags().IntVar(&s.retry.Attempts, "retry-attempts", retry.Attempts, "Optional, the max attempts of the calls to chart repositories and image registries on transient failures, like 5xx, 429 and connection resets; 1 means no retry")
+	pushCmd.Flags().DurationVar(&s.retry.Backoff, "retry-backoff", retry.Backoff, "Optional, the backoff before the first retry, which doubles for every retry")
+	pushCmd.Flags().DurationVar(&s.retry.MaxBackoff, "retry-max-backoff", retry.MaxBackoff, "Optional, the max backoff between retries")
+	pushCmd.Flags().Float64Var(&s.retry.Jitter, "retry-jitter", retry.Jitter, "Optional, the fraction, from 0 to 1, of the backoff to randomize")
+	pushCmd.Flags().BoolVar(&s.retry.HonorRetryAfter, "retry-honor-retry-after", retry.HonorRetryAfter, "Optional, the flag to indicate whether to wait as long as the Retry-After header of the 429 and 503 responses tells, up to --retry-max-backoff")
+
+	pushCmd.MarkFlagsOneRequired("from-dir", "from-archive")
+	pushCmd.MarkFlagsMutuallyExclusive("from-dir", "from-archive")
 	pushCmd.MarkFlagRequired("to-chart-repo")
-	pushCmd.MarkFlagRequired("to-image-registry")
 }
 
 type push struct {
 	fromDir         string
+	fromArchive     string
 	fromCharts      []string
 	toChartRepo     string
 	toImageRegistry string
+
+	username  string
+	password  string
+	plainHTTP bool
+
+	verify  bool
+	keyring string
+
+	verifyImageSignatures bool
+	keys                  []string
+
+	continueOnError bool
+
+	retry api.RetryPolicy
 }
 
 func runPush(push *push, args []string) {
-	fmt.Println("...to be implemented...")
+	ctx := context.Background()
+
+	var keys []*verifier.PublicKey
+	if push.verifyImageSignatures {
+		var err error
+		keys, err = verifier.LoadPublicKeys(push.keys)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// the archive is extracted and verified into a temporary directory, to push from
+	fromDir := push.fromDir
+	if push.fromArchive != "" {
+		var err error
+		fromDir, err = os.MkdirTemp("", "helm-packager-")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		err = archive.Extract(push.fromArchive, fromDir)
+		if err != nil {
+			os.RemoveAll(fromDir)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	err := pusher.NewPusher(fromDir, push.toChartRepo, push.toImageRegistry).
+		WithCharts(push.fromCharts).
+		WithBasicAuth(push.username, push.password).
+		WithPlainHTTP(push.plainHTTP).
+		WithVerify(push.verify, push.keyring).
+		WithImageSignatureKeys(keys).
+		ConfigureContinueOnError(push.continueOnError).
+		ConfigureRetry(push.retry).
+		Push(ctx)
+	if push.fromArchive != "" {
+		os.RemoveAll(fromDir)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,127 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/brightzheng100/helm-packager/pkg/archive"
+	"github.com/brightzheng100/helm-packager/pkg/scanner"
+	"github.com/spf13/cobra"
+)
+
+var scanCmdLongDesc = `  Scan command scans the images of the exported local Helm charts offline, against a local vulnerability database,
+  e.g. the OSV records of the Linux distributions, downloaded on the connected side, and tells the vulnerabilities per chart.
+
+  Usage:
+
+  helm-packager scan \
+    --from-dir <EXPORTED DIR WITH CHARTS AND IMAGES> | --from-archive <ARCHIVE FILE OR ITS FIRST VOLUME> \
+    --db <OSV DIR OR ZIP FILE> \
+    [-o text|json]
+
+  The OS packages installed in the images, by dpkg, apk or rpm, are matched against the records of their distributions,
+  e.g. Debian:12 for debian 12, read from the images as they're saved, without any registry or network access.
+
+  Examples:
+
+  # Scan the images in "./_charts" against the OSV records of Debian and Alpine
+
+  mkdir osv
+  curl -o osv/debian.zip https://osv-vulnerabilities.storage.googleapis.com/Debian/all.zip
+  curl -o osv/alpine.zip https://osv-vulnerabilities.storage.googleapis.com/Alpine/all.zip
+
+  helm-packager scan \
+    --from-dir ./_charts \
+    --db ./osv
+`
+
+var sc = &scan{}
+
+// scanCmd represents the scan command
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Scan command scans the images of the exported local Helm charts offline, against a local vulnerability database",
+	Long:  scanCmdLongDesc,
+	Run: func(cmd *cobra.Command, args []string) {
+		runScan(sc, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+
+	scanCmd.Flags().StringVar(&sc.fromDir, "from-dir", "", "Local directory that has exported Helm charts and images, e.g. ./charts")
+	scanCmd.Flags().StringVar(&sc.fromArchive, "from-archive", "", "The archive pulled with --archive, or its first volume, e.g. bundle.tar.zst or bundle.tar.zst.000, instead of --from-dir")
+	scanCmd.Flags().StringVar(&sc.db, "db", "", "The local vulnerability database, which is a directory of the OSV JSON records, or the all.zip files exported by OSV, e.g. ./osv")
+	scanCmd.Flags().StringVarP(&sc.output, "output", "o", "text", "Optional, the output format, text or json")
+
+	scanCmd.MarkFlagsOneRequired("from-dir", "from-archive")
+	scanCmd.MarkFlagsMutuallyExclusive("from-dir", "from-archive")
+	scanCmd.MarkFlagRequired("db")
+}
+
+type scan struct {
+	fromDir     string
+	fromArchive string
+
+	db     string
+	output string
+}
+
+func runScan(scan *scan, args []string) {
+	if scan.output != "text" && scan.output != "json" {
+		fmt.Fprintf(os.Stderr, "invalid --output %s, expected text or json\n", scan.output)
+		os.Exit(1)
+	}
+
+	db, err := scanner.LoadDB(scan.db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// the archive is extracted and verified into a temporary directory, to scan from
+	fromDir := scan.fromDir
+	if scan.fromArchive != "" {
+		fromDir, err = os.MkdirTemp("", "helm-packager-")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := archive.Extract(scan.fromArchive, fromDir); err != nil {
+			os.RemoveAll(fromDir)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	result, err := scanner.NewScanner(fromDir).
+		WithDB(db).
+		Scan()
+	if scan.fromArchive != "" {
+		os.RemoveAll(fromDir)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if scan.output == "json" {
+		if err := result.PrintJSON(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		result.Print(os.Stdout)
+	}
+
+	// the images failed to scan, unlike the vulnerabilities found, fail the command
+	if errs := result.Errors(); len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d image(s) failed to scan: %v\n", len(errs), errors.Join(errs...))
+		os.Exit(1)
+	}
+}
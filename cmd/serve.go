@@ -0,0 +1,84 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/brightzheng100/helm-packager/pkg/server"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var serveCmdLongDesc = `  Serve command serves the exported local Helm charts and their images, read-only over HTTP,
+  as a Helm chart repository and an OCI registry, without any extra infrastructure.
+
+  Usage:
+
+  helm-packager serve \
+    --from-dir <EXPORTED DIR WITH CHARTS AND IMAGES> \
+   [--addr <ADDRESS>]
+
+  The charts are served by a generated index.yaml, and the images by their repository paths and tags,
+  e.g. docker.io/bitnami/nginx:1.25.3 is served as <HOST>/bitnami/nginx:1.25.3.
+
+  Examples:
+
+  # Serve the bundle in "./_charts" at port 5000
+
+  helm-packager serve --from-dir ./_charts --addr :5000
+
+  # Then, on the clients
+
+  helm repo add bundle http://bundle-host:5000
+  docker pull bundle-host:5000/bitnami/nginx:1.25.3
+`
+
+var v = &serve{}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve command serves the exported local Helm charts and their images as a Helm chart repository and an OCI registry",
+	Long:  serveCmdLongDesc,
+	Run: func(cmd *cobra.Command, args []string) {
+		runServe(v, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&v.fromDir, "from-dir", "", "Local directory that has exported Helm charts and images, e.g. ./charts")
+	serveCmd.Flags().StringVar(&v.addr, "addr", ":5000", "Optional, the address to serve at")
+
+	serveCmd.MarkFlagRequired("from-dir")
+}
+
+type serve struct {
+	fromDir string
+	addr    string
+}
+
+func runServe(serve *serve, args []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv, err := server.NewServer(serve.fromDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	utils.PrintReport(srv.Report())
+
+	fmt.Printf("serving %s at %s\n", serve.fromDir, serve.addr)
+	if err := srv.ListenAndServe(ctx, serve.addr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/brightzheng100/helm-packager/pkg/archive"
+	"github.com/brightzheng100/helm-packager/pkg/verifier"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmdLongDesc = `  Verify command verifies the exported local Helm charts and their images offline, before they're imported,
+  e.g. the cosign signatures of the images, copied with pull --copy-artifacts, by the public keys only,
+  without any access to Rekor or Fulcio.
+
+  Usage:
+
+  helm-packager verify \
+    --from-dir <EXPORTED DIR WITH CHARTS AND IMAGES> | --from-archive <ARCHIVE FILE OR ITS FIRST VOLUME> \
+    --image-signatures \
+    --key <PUBLIC_KEY> [--key <PUBLIC_KEY>]...
+
+  Every image must be signed by any of the keys, and the command fails with the images unsigned or mis-signed listed.
+
+  Examples:
+
+  # Verify every image in "./_charts" is signed by the approved key
+
+  helm-packager verify \
+    --from-dir ./_charts \
+    --image-signatures \
+    --key cosign.pub
+`
+
+var vf = &verify{}
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify command verifies the exported local Helm charts and their images offline, e.g. the cosign signatures of the images",
+	Long:  verifyCmdLongDesc,
+	Run: func(cmd *cobra.Command, args []string) {
+		runVerify(vf, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringVar(&vf.fromDir, "from-dir", "", "Local directory that has exported Helm charts and images, e.g. ./charts")
+	verifyCmd.Flags().StringVar(&vf.fromArchive, "from-archive", "", "The archive pulled with --archive, or its first volume, e.g. bundle.tar.zst or bundle.tar.zst.000, instead of --from-dir")
+	verifyCmd.Flags().BoolVar(&vf.imageSignatures, "image-signatures", false, "Verify the cosign signatures of the images, copied with pull --copy-artifacts, by the public keys of --key")
+	verifyCmd.Flags().StringSliceVar(&vf.keys, "key", []string{}, "The cosign public key, e.g. cosign.pub, which any image can be signed by; can be repeated for multiple keys")
+
+	verifyCmd.MarkFlagsOneRequired("from-dir", "from-archive")
+	verifyCmd.MarkFlagsMutuallyExclusive("from-dir", "from-archive")
+	verifyCmd.MarkFlagsRequiredTogether("image-signatures", "key")
+	verifyCmd.MarkFlagRequired("image-signatures")
+}
+
+type verify struct {
+	fromDir     string
+	fromArchive string
+
+	imageSignatures bool
+	keys            []string
+}
+
+func runVerify(verify *verify, args []string) {
+	keys, err := verifier.LoadPublicKeys(verify.keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// the archive is extracted and verified into a temporary directory, to verify from
+	fromDir := verify.fromDir
+	if verify.fromArchive != "" {
+		fromDir, err = os.MkdirTemp("", "helm-packager-")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := archive.Extract(verify.fromArchive, fromDir); err != nil {
+			os.RemoveAll(fromDir)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	err = verifier.NewVerifier(fromDir).
+		WithImageSignatureKeys(keys).
+		Verify()
+	if verify.fromArchive != "" {
+		os.RemoveAll(fromDir)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/brightzheng100/helm-packager/pkg/chartloader"
+	"github.com/brightzheng100/helm-packager/pkg/chartwriter"
+	"github.com/brightzheng100/helm-packager/pkg/imageswriter"
+	"github.com/brightzheng100/helm-packager/pkg/pipeline"
+)
+
+var (
+	embedDir   = "../embed/charts/robotshop"
+	fromRepo   = "oci://registry-1.docker.io/bitnamicharts"
+	fromCharts = []string{"apache:10.2.3", "nginx"}
+	toDir      = "./_charts"
+	toRepoDir  = "./_repo"
+)
+
+func main() {
+	ctx := context.Background()
+
+	// create folders if needed
+	for _, dir := range []string{toDir, toRepoDir} {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			err := os.Mkdir(dir, 0766)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	// combine the local charts and the remote Bitnami charts into one bundle
+	cl1 := chartloader.NewEmbedChartLoader(os.DirFS(embedDir))
+	cl2 := chartloader.NewRemoteChartLoader(fromRepo, fromCharts, toDir)
+
+	// and fan out to the local files and a static Helm chart repository at the same time
+	cw1 := chartwriter.NewFileChartWriter(toDir)
+	cw2 := chartwriter.NewRepoChartWriter(toRepoDir, "")
+	iw := imageswriter.NewFileImagesWriter(toDir)
+
+	cp := pipeline.NewBuilder(ctx).
+		WithChartLoader(cl1).
+		WithChartLoader(cl2).
+		WithChartWriter(cw1).
+		WithChartWriter(cw2).
+		WithImagesWriter(iw).
+		ConfigureChartFilesIncluded(false).
+		Complete()
+
+	err := cp.Process()
+	if err != nil {
+		panic(err)
+	}
+}
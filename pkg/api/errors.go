@@ -0,0 +1,54 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"fmt"
+)
+
+// Stage represents the stage in the pipeline where an error happens
+type Stage string
+
+const (
+	StageLoad      Stage = "load"
+	StageTransform Stage = "transform"
+	StageWrite     Stage = "write"
+	StageRender    Stage = "render"
+	StagePolicy    Stage = "policy"
+	StagePull      Stage = "pull"
+	StageSave      Stage = "save"
+	StagePush      Stage = "push"
+	StageVerify    Stage = "verify"
+)
+
+// ChartError represents an error of a Helm chart at a stage
+type ChartError struct {
+	Chart string
+	Stage Stage
+	Err   error
+}
+
+func (e *ChartError) Error() string {
+	return fmt.Sprintf("%s chart %s: %v", e.Stage, e.Chart, e.Err)
+}
+
+func (e *ChartError) Unwrap() error {
+	return e.Err
+}
+
+// ImageError represents an error of an image of a Helm chart at a stage
+type ImageError struct {
+	Chart string
+	Image string
+	Stage Stage
+	Err   error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("%s image %s of chart %s: %v", e.Stage, e.Image, e.Chart, e.Err)
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,113 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// regexPrefix is the prefix of the regex patterns, while the others are glob patterns
+const regexPrefix = "regex:"
+
+// imagePattern is a pattern of ImageFilter with its compiled regexp
+type imagePattern struct {
+	pattern string
+	regexp  *regexp.Regexp
+}
+
+// Compile compiles the patterns of the filter, globally and per chart, once,
+// so that an invalid pattern fails when the filter is configured, instead of when every chart is filtered
+func (f *ImageFilter) Compile() error {
+	var err error
+	if f.include, err = compilePatterns(f.Include); err != nil {
+		return err
+	}
+	if f.exclude, err = compilePatterns(f.Exclude); err != nil {
+		return err
+	}
+	for chartName, chartFilter := range f.Charts {
+		if err := chartFilter.Compile(); err != nil {
+			return fmt.Errorf("chart %s: %w", chartName, err)
+		}
+		f.Charts[chartName] = chartFilter
+	}
+	f.compiled = true
+	return nil
+}
+
+// Match tells whether the image of the chart is kept by the global and the chart's patterns,
+// or returns the reason why it's skipped otherwise.
+// The filter is compiled first if it's not yet.
+func (f *ImageFilter) Match(chartName, imgref string) (bool, string, error) {
+	if !f.compiled {
+		if err := f.Compile(); err != nil {
+			return false, "", err
+		}
+	}
+
+	include := append([]imagePattern{}, f.include...)
+	exclude := append([]imagePattern{}, f.exclude...)
+	if chartFilter, ok := f.Charts[chartName]; ok {
+		include = append(include, chartFilter.include...)
+		exclude = append(exclude, chartFilter.exclude...)
+	}
+
+	if len(include) > 0 && matchCompiled(include, imgref) == "" {
+		return false, "not included", nil
+	}
+	if pattern := matchCompiled(exclude, imgref); pattern != "" {
+		return false, fmt.Sprintf("excluded by %s", pattern), nil
+	}
+	return true, "", nil
+}
+
+// MatchAny returns the first of the glob or regex patterns the string matches, or empty if there is none
+func MatchAny(patterns []string, s string) (string, error) {
+	compiled, err := compilePatterns(patterns)
+	if err != nil {
+		return "", err
+	}
+	return matchCompiled(compiled, s), nil
+}
+
+// matchCompiled returns the first pattern the image matches, or empty if there is none
+func matchCompiled(patterns []imagePattern, imgref string) string {
+	for _, p := range patterns {
+		if p.regexp.MatchString(imgref) {
+			return p.pattern
+		}
+	}
+	return ""
+}
+
+func compilePatterns(patterns []string) ([]imagePattern, error) {
+	compiled := []imagePattern{}
+	for _, pattern := range patterns {
+		r, err := compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, imagePattern{pattern: pattern, regexp: r})
+	}
+	return compiled, nil
+}
+
+// compilePattern compiles the regex pattern prefixed with "regex:",
+// or the glob pattern, where "*" matches any characters, including "/", and "?" matches one character
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		r, err := regexp.Compile(strings.TrimPrefix(pattern, regexPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern %s: %w", pattern, err)
+		}
+		return r, nil
+	}
+
+	glob := regexp.QuoteMeta(pattern)
+	glob = strings.ReplaceAll(glob, `\*`, ".*")
+	glob = strings.ReplaceAll(glob, `\?`, ".")
+	return regexp.Compile("^" + glob + "$")
+}
@@ -21,6 +21,12 @@ type ChartLoader interface {
 	Finish(ctx context.Context, config Config) error
 }
 
+// ChartTransformer defines the interfaces for how to transform the loaded Helm charts before they're written.
+// A transformer may add, drop or replace charts by returning a different list of charts.
+type ChartTransformer interface {
+	Transform(ctx context.Context, charts []*Chart, config Config) ([]*Chart, error)
+}
+
 // ChartWriter defines the interfaces for how to write a Helm chart
 type ChartWriter interface {
 	Write(ctx context.Context, chart *Chart, config Config) error
@@ -4,14 +4,45 @@
 package api
 
 import (
+	"time"
+
 	"github.com/xlab/treeprint"
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/provenance"
 	"helm.sh/helm/v3/pkg/registry"
 )
 
 // Chart represents a loaded Helm chart.
 type Chart struct {
 	C *chart.Chart
+
+	// Source is where the chart is loaded from, e.g. the URL of the chart repository, if it's known
+	Source string
+
+	// Origin is the chart before any transformation which changes its image references.
+	// When it's set, the images are extracted from it instead, as they may not be in the target registry yet.
+	Origin *chart.Chart
+
+	// UpstreamVersion is the version of the chart as it's loaded, if a transformation has changed it,
+	// e.g. 15.4.4 of the chart re-packaged as 15.4.4+mirror
+	UpstreamVersion string
+
+	// Provenance is the provenance of the chart archive, as it's loaded, which is dropped once the chart is transformed.
+	// When it's set, the chart archive is written as it is, with its .prov file, instead of re-packaged from the chart.
+	Provenance *Provenance
+}
+
+// Provenance represents the chart archive, and the .prov file which signs it
+type Provenance struct {
+	// Archive is the chart archive as it's loaded, e.g. downloaded from the chart repository
+	Archive string
+	// File is the .prov file of the chart archive
+	File string
+
+	// SignedBy and Fingerprint are the identities and the fingerprint of the key which signs the chart archive,
+	// which are empty if it's not verified
+	SignedBy    []string
+	Fingerprint string
 }
 
 // Config represents the configuration in the pipeline
@@ -19,7 +50,153 @@ type Config struct {
 	ChartFilesIncluded bool
 	Dryrun             bool
 
+	// ContinueOnError tells the pipeline and its components to carry on with the rest of charts and images
+	// when a chart or an image fails, and to fail with all the failures aggregated at the end
+	ContinueOnError bool
+
+	// ValuesFiles are the values files per chart name, to render the charts with before the images are extracted,
+	// e.g. to enable the optional components which are deployed
+	ValuesFiles map[string][]string
+
+	// ImagePolicy tells which images are allowed, which is checked right after the images are extracted, if it's set
+	ImagePolicy *ImagePolicy
+
+	// ImageFilter tells which images to skip, after the images are extracted and before they're pulled
+	ImageFilter ImageFilter
+
+	// ExtraImages are the images per chart name, which can't be extracted from the charts,
+	// like the ones pulled at runtime, to be pulled together with the extracted ones
+	ExtraImages map[string][]string
+
+	// IncludeHooks tells to extract the images from the Helm hooks too, which are left out by default
+	IncludeHooks bool
+
+	// DropTestHooks tells to drop the Helm test hooks, annotated with helm.sh/hook: test, out of the hooks included
+	DropTestHooks bool
+
+	// Platforms are the platforms of the images to keep, e.g. linux/amd64, or "all" for all platforms.
+	// When there are multiple platforms, the images are saved as OCI image layouts instead of tarballs.
+	Platforms []string
+
+	// CopyArtifacts tells to copy the cosign signatures, attestations and SBOMs, and the OCI referrers, of the images,
+	// which are saved as OCI image layouts next to the images, saved as OCI image layouts too
+	CopyArtifacts bool
+
+	// PinDigests tells to resolve the image references to their digests at pull time, and to pull the images by the digests,
+	// where the references pinned to the digests are recorded in the bundle manifest
+	PinDigests bool
+	// Pins are the digests the image references resolve to, by image reference, which are resolved only once,
+	// and shared by the transformers rewriting the charts with them and the images writers
+	Pins map[string]string
+
+	// Since is the manifest of the previous bundle, where the charts and image blobs in it are left out of the bundle
+	Since *Manifest
+
+	// Verify tells to verify the provenance of the charts, by the public keys in Keyring, and to keep their .prov files
+	Verify  bool
+	Keyring string
+
+	// Signer signs the charts re-packaged, e.g. transformed, with the .prov files written next to them, if it's set
+	Signer *provenance.Signatory
+
+	// Layout is how the charts and their images are laid out in the output directory
+	Layout Layout
+
+	// Retry is the retry policy of the calls to chart repositories and image registries
+	Retry RetryPolicy
+
+	// RegistryAuth are the credentials of the image registries by host, e.g. of the OCI chart repositories of the bundle spec,
+	// which take precedence over the ones of docker login
+	RegistryAuth map[string]RegistryAuth
+
 	TreeRoot *Tree
+	Report   *Report
+	Manifest *Manifest
+}
+
+// RegistryAuth represents the credentials of an image registry
+type RegistryAuth struct {
+	Username string
+	Password string
+}
+
+// ImageFilter represents which images to include and exclude, by the glob patterns,
+// where "*" matches any characters, or the regex patterns prefixed with "regex:".
+// An image is included if it matches any of Include, or Include is empty, and it matches none of Exclude.
+type ImageFilter struct {
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+
+	// Charts are the filters per chart name, which apply on top of the global ones
+	Charts map[string]ImageFilter `yaml:"charts,omitempty"`
+
+	// the patterns compiled by Compile
+	compiled         bool
+	include, exclude []imagePattern
+}
+
+// ImagePolicy represents the rules the images extracted from the charts must comply with, for example:
+//
+//	allowedRegistries:
+//	  - docker.io/bitnami
+//	  - harbor.example.com
+//	deniedRepositories:
+//	  - regex:.*/(busybox|curl)
+//	requireDigest: false
+//	disallowedTags:
+//	  - latest
+//	action: fail
+//
+// where the repository of an image is its reference without the tag and digest, e.g. docker.io/bitnami/nginx,
+// and the patterns are the glob patterns, or the regex patterns prefixed with "regex:", as the image filter's.
+type ImagePolicy struct {
+	// AllowedRegistries are the registries, or the registries with the namespaces, the images must be from, if any
+	AllowedRegistries []string `yaml:"allowedRegistries,omitempty"`
+	// DeniedRepositories are the patterns of the repositories the images must not be from
+	DeniedRepositories []string `yaml:"deniedRepositories,omitempty"`
+	// RequireDigest requires the images to be pinned by digest, e.g. nginx:1.25.3@sha256:...
+	RequireDigest bool `yaml:"requireDigest,omitempty"`
+	// DisallowedTags are the patterns of the tags disallowed, where an image with neither tag nor digest is regarded as latest
+	DisallowedTags []string `yaml:"disallowedTags,omitempty"`
+	// Action is what to do with the violations, which is PolicyFail by default
+	Action PolicyAction `yaml:"action,omitempty"`
+}
+
+// PolicyAction represents what to do with the violations of the image policy
+type PolicyAction string
+
+const (
+	// PolicyFail fails the chart with the violations
+	PolicyFail PolicyAction = "fail"
+	// PolicyWarn only records the violations in the report as warnings
+	PolicyWarn PolicyAction = "warn"
+)
+
+// ImagesFile represents the file which tells how to deal with the images of the charts
+type ImagesFile struct {
+	ImageFilter `yaml:",inline"`
+
+	// ExtraImages are the images per chart name to be pulled, besides the ones extracted from the chart
+	ExtraImages map[string][]string `yaml:"extraImages,omitempty"`
+}
+
+// Layout represents how the charts and their images are laid out in the output directory
+type Layout string
+
+const (
+	// LayoutVersioned lays out every version of a chart in ${chartName}/${chartVersion}/, which is the default
+	LayoutVersioned Layout = "name/version"
+	// LayoutFlat lays out a chart in ${chartName}/, which keeps only one version of the chart
+	LayoutFlat Layout = "name"
+)
+
+// RetryPolicy represents how to retry on transient failures, like 5xx, 429 and connection resets
+type RetryPolicy struct {
+	Attempts        int           // the max attempts, where 1 or less means no retry
+	Backoff         time.Duration // the backoff before the first retry, which doubles for every retry
+	MaxBackoff      time.Duration // the max backoff, where 0 means unlimited
+	Jitter          float64       // the fraction, from 0 to 1, of the backoff to randomize
+	HonorRetryAfter bool          // whether to wait as long as the Retry-After header of the responses tells
 }
 
 // Tree is a wrapper of treeprint.Tree for tree view display
@@ -27,6 +204,96 @@ type Tree struct {
 	T treeprint.Tree
 }
 
+// Report represents the records of what has been done in the pipeline, on top of the tree view
+type Report struct {
+	Records []*Record
+}
+
+// Record represents a record in the report
+type Record struct {
+	Chart   string
+	Kind    string
+	Message string
+}
+
+// Manifest represents the bundle manifest, which records how the bundle is laid out and what's in it
+type Manifest struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Layout     Layout           `yaml:"layout"`
+	Charts     []*ManifestChart `yaml:"charts"`
+
+	// Base is the digest of the previous bundle manifest, which the delta bundle is relative to
+	Base string `yaml:"base,omitempty"`
+	// Origins are the images in the previous bundles, which the blobs left out of the delta bundle are from,
+	// by the digests of the blobs, so that the blobs can be mounted from them when pushed
+	Origins map[string]string `yaml:"origins,omitempty"`
+	// RegistryMapping is the registry mapping the image references in the charts are rewritten by,
+	// e.g. docker.io=my.registry.com/mirror, which the images are pushed by too
+	RegistryMapping map[string]string `yaml:"registryMapping,omitempty"`
+}
+
+// ManifestChart represents a chart in the bundle, where the paths are relative to the bundle directory
+type ManifestChart struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Path    string `yaml:"path,omitempty"`
+	Digest  string `yaml:"digest,omitempty"`
+	// Source is where the chart is loaded from, e.g. the URL of the chart repository
+	Source string `yaml:"source,omitempty"`
+	// UpstreamVersion is the version of the chart as it's loaded, if it's changed by the transformations
+	UpstreamVersion string `yaml:"upstreamVersion,omitempty"`
+	// Prov is the .prov file of the chart archive, relative to the bundle directory, if its provenance is kept
+	Prov   string           `yaml:"prov,omitempty"`
+	Images []*ManifestImage `yaml:"images,omitempty"`
+}
+
+// ManifestImage represents an image of a chart in the bundle
+type ManifestImage struct {
+	Ref    string `yaml:"ref"`
+	Path   string `yaml:"path,omitempty"`
+	Source string `yaml:"source,omitempty"`
+
+	// RefDigest is the digest the image reference resolves to, e.g. of the image index of a multi-platform image,
+	// which tells whether a tag has been moved
+	RefDigest string `yaml:"refDigest,omitempty"`
+	// Pinned is the image reference pinned to RefDigest, e.g. docker.io/bitnami/nginx:1.25.3@sha256:..., if the digests are pinned
+	Pinned string `yaml:"pinned,omitempty"`
+	// Digest is the digest of the image manifest, or the image index, saved
+	Digest string `yaml:"digest,omitempty"`
+	// Platforms are the platforms of the image captured, e.g. linux/amd64
+	Platforms []string `yaml:"platforms,omitempty"`
+	// Blobs are the digests of the configs and layers of the image
+	Blobs []string `yaml:"blobs,omitempty"`
+	// Omitted are the blobs left out of the delta bundle, as they're in the previous bundle already
+	Omitted []string `yaml:"omitted,omitempty"`
+
+	// ArtifactsPath is the OCI image layout of the artifacts of the image, e.g. its cosign signatures, if they're copied
+	ArtifactsPath string              `yaml:"artifactsPath,omitempty"`
+	Artifacts     []*ManifestArtifact `yaml:"artifacts,omitempty"`
+}
+
+// ArtifactKind represents the kind of the artifact related to an image
+type ArtifactKind string
+
+const (
+	ArtifactSignature   ArtifactKind = "signature"   // the cosign signature, tagged sha256-<digest>.sig
+	ArtifactAttestation ArtifactKind = "attestation" // the cosign attestation, tagged sha256-<digest>.att
+	ArtifactSBOM        ArtifactKind = "sbom"        // the cosign SBOM, tagged sha256-<digest>.sbom
+	ArtifactReferrer    ArtifactKind = "referrer"    // the OCI 1.1 referrer, which refers to the image as its subject
+)
+
+// ManifestArtifact represents an artifact related to an image, by the cosign tag convention, or as an OCI referrer
+type ManifestArtifact struct {
+	Kind ArtifactKind `yaml:"kind"`
+	// Tag is the tag of the cosign artifact, e.g. sha256-<digest>.sig, while the referrers are pushed by digest
+	Tag    string `yaml:"tag,omitempty"`
+	Digest string `yaml:"digest"`
+	// Subject is the digest of the image manifest, or the image index, the artifact is for
+	Subject      string `yaml:"subject"`
+	ArtifactType string `yaml:"artifactType,omitempty"`
+}
+
 // RemoteChart represents a Helm chart from repot repository
 type RemoteChart struct {
 	Chart
@@ -0,0 +1,264 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// testBundle writes the bundle of two versions of a chart, which share an image written once as an OCI image layout,
+// and returns the manifest and the contents of all the files expected in the bundle by their names
+func testBundle(t *testing.T, dir string) (*api.Manifest, map[string][]byte) {
+	t.Helper()
+
+	// the random contents are hardly compressed, so that the archive is split into multiple volumes
+	random := func(size int) []byte {
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	files := map[string][]byte{
+		"nginx/15.4.4/nginx-15.4.4.tgz":                       random(8 << 10),
+		"nginx/15.4.4/nginx-15.4.4.tgz.prov":                  []byte("-----BEGIN PGP SIGNED MESSAGE-----\n"),
+		"nginx/15.5.0/nginx-15.5.0.tgz":                       random(8 << 10),
+		"nginx/15.4.4/images/nginx/oci-layout":                []byte(`{"imageLayoutVersion": "1.0.0"}`),
+		"nginx/15.4.4/images/nginx/index.json":                []byte(`{"schemaVersion": 2}`),
+		"nginx/15.4.4/images/nginx/blobs/sha256/0123456789ab": random(32 << 10),
+		"index.yaml": []byte("apiVersion: v1\n"),
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	image := func(path string) []*api.ManifestImage {
+		return []*api.ManifestImage{{Ref: "docker.io/bitnami/nginx:1.25", Path: path}}
+	}
+	m := utils.NewManifest(api.LayoutVersioned)
+	m.Charts = []*api.ManifestChart{
+		{Name: "nginx", Version: "15.4.4", Path: "nginx/15.4.4/nginx-15.4.4.tgz", Prov: "nginx/15.4.4/nginx-15.4.4.tgz.prov", Images: image("nginx/15.4.4/images/nginx")},
+		{Name: "nginx", Version: "15.5.0", Path: "nginx/15.5.0/nginx-15.5.0.tgz", Images: image("nginx/15.5.0/images/nginx")},
+	}
+
+	// the image shared is archived as the hard links of the files of the one archived first
+	for name, data := range files {
+		if after, ok := strings.CutPrefix(name, "nginx/15.4.4/images/"); ok {
+			files["nginx/15.5.0/images/"+after] = data
+		}
+	}
+	return m, files
+}
+
+// writeArchive archives the bundle, as the pull does, and returns the volumes, and the files archived
+func writeArchive(t *testing.T, archivePath string, volumeSize int64) ([]string, map[string][]byte) {
+	t.Helper()
+
+	dir := t.TempDir()
+	m, files := testBundle(t, dir)
+
+	w, err := Create(archivePath, volumeSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mc := range m.Charts {
+		if err := w.AddChart(dir, mc); err != nil {
+			w.Abort()
+			t.Fatal(err)
+		}
+	}
+	if err := w.AddDir(dir); err != nil {
+		w.Abort()
+		t.Fatal(err)
+	}
+	if err := w.Close(m, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the files are removed once they're archived
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("files left in the bundle directory: %v, %v", entries, err)
+	}
+	return w.Volumes(), files
+}
+
+func TestWriteExtract(t *testing.T) {
+	tests := []struct {
+		name       string
+		archive    string
+		volumeSize int64
+		// extractFirst tells the archive is extracted by its first volume, instead of the path it's created by
+		extractFirst bool
+		volumes      int
+	}{
+		{"zstd", "bundle.tar.zst", 0, false, 1},
+		{"gzip", "bundle.tar.gz", 0, false, 1},
+		{"tgz", "bundle.tgz", 0, false, 1},
+		{"tar", "bundle.tar", 0, false, 1},
+		{"split", "bundle.tar.zst", 16 << 10, false, 4},
+		{"split, by first volume", "bundle.tar.gz", 16 << 10, true, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := filepath.Join(t.TempDir(), tt.archive)
+			volumes, want := writeArchive(t, archivePath, tt.volumeSize)
+			if len(volumes) != tt.volumes {
+				t.Errorf("volumes = %v, want %d", volumes, tt.volumes)
+			}
+
+			from := archivePath
+			if tt.extractFirst {
+				from = volumes[0]
+			}
+			toDir := t.TempDir()
+			if err := Extract(from, toDir); err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string][]byte{}
+			err := filepath.WalkDir(toDir, func(p string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				name, err := filepath.Rel(toDir, p)
+				if err != nil {
+					return err
+				}
+				data, err := os.ReadFile(p)
+				got[filepath.ToSlash(name)] = data
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, data := range want {
+				if g, ok := got[name]; !ok {
+					t.Errorf("%s not extracted", name)
+				} else if !bytes.Equal(g, data) {
+					t.Errorf("%s extracted with other contents", name)
+				}
+				delete(got, name)
+			}
+			if _, ok := got[utils.ManifestFileName]; !ok {
+				t.Errorf("%s not extracted", utils.ManifestFileName)
+			}
+			delete(got, utils.ManifestFileName)
+			for name := range got {
+				t.Errorf("%s extracted, which is unexpected", name)
+			}
+
+			m, err := utils.ReadBundle(toDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Charts) != 2 {
+				t.Errorf("charts in the bundle manifest = %d, want 2", len(m.Charts))
+			}
+		})
+	}
+}
+
+// TestExtractTampered extracts the archive rewritten with the files tampered,
+// where the checksums embedded must fail the extraction
+func TestExtractTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(hdr *tar.Header, data []byte) (*tar.Header, []byte)
+		want   string
+	}{
+		{"checksum mismatch", func(hdr *tar.Header, data []byte) (*tar.Header, []byte) {
+			if hdr.Name == "index.yaml" {
+				data = []byte("apiVersion: v2\n")
+			}
+			return hdr, data
+		}, "index.yaml: checksum mismatch"},
+		{"missing", func(hdr *tar.Header, data []byte) (*tar.Header, []byte) {
+			if hdr.Name == "index.yaml" {
+				return nil, nil
+			}
+			return hdr, data
+		}, "index.yaml: missing"},
+		{"unexpected", func(hdr *tar.Header, data []byte) (*tar.Header, []byte) {
+			if hdr.Name == "index.yaml" {
+				hdr.Name = "index.yml"
+			}
+			return hdr, data
+		}, "index.yml: unexpected"},
+		{"no checksums", func(hdr *tar.Header, data []byte) (*tar.Header, []byte) {
+			if hdr.Name == ChecksumsFileName {
+				return nil, nil
+			}
+			return hdr, data
+		}, "no " + ChecksumsFileName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := filepath.Join(t.TempDir(), "bundle.tar")
+			writeArchive(t, archivePath, 0)
+
+			data, err := os.ReadFile(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			tr := tar.NewReader(bytes.NewReader(data))
+			tw := tar.NewWriter(&buf)
+			for {
+				hdr, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hdr, content = tt.tamper(hdr, content); hdr == nil {
+					continue
+				}
+				if hdr.Typeflag == tar.TypeReg {
+					hdr.Size = int64(len(content))
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := tw.Write(content); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err = Extract(archivePath, t.TempDir())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Extract() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package archive packages the bundle into a single compressed archive, optionally split into volumes,
+// with the bundle manifest and the checksums of all the files embedded, and extracts it back
+package archive
@@ -0,0 +1,156 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Extract extracts the archive, or its volumes, into the directory,
+// and verifies the files extracted against the checksums embedded
+func Extract(archivePath, toDir string) error {
+	volumes, err := Volumes(archivePath)
+	if err != nil {
+		return err
+	}
+	vr := newVolumeReader(volumes)
+	defer vr.Close()
+
+	var decompressor io.Reader
+	base := strings.TrimSuffix(volumes[0], fmt.Sprintf(volumeSuffix, 0))
+	switch {
+	case strings.HasSuffix(base, ".tar.zst"):
+		zr, err := zstd.NewReader(vr)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		decompressor = zr
+	case strings.HasSuffix(base, ".tar.gz"), strings.HasSuffix(base, ".tgz"):
+		gr, err := gzip.NewReader(vr)
+		if err != nil {
+			return fmt.Errorf("could not read archive %s: %w", archivePath, err)
+		}
+		defer gr.Close()
+		decompressor = gr
+	case strings.HasSuffix(base, ".tar"):
+		decompressor = vr
+	default:
+		return fmt.Errorf("unsupported archive %s, expected .tar.zst, .tar.gz, .tgz or .tar", archivePath)
+	}
+
+	// the checksums of the files are computed as they're extracted, and verified at the end
+	extracted := map[string]string{}
+	var expected []byte
+	tr := tar.NewReader(decompressor)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read archive %s: %w", archivePath, err)
+		}
+
+		if hdr.Name == ChecksumsFileName {
+			if expected, err = io.ReadAll(tr); err != nil {
+				return fmt.Errorf("could not read archive %s: %w", archivePath, err)
+			}
+			continue
+		}
+
+		target, err := securejoin.SecureJoin(toDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			sum, err := extractFile(tr, target)
+			if err != nil {
+				return fmt.Errorf("could not extract %s: %w", hdr.Name, err)
+			}
+			extracted[hdr.Name] = sum
+		case tar.TypeLink:
+			source, err := securejoin.SecureJoin(toDir, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(source, target); err != nil {
+				return fmt.Errorf("could not extract %s: %w", hdr.Name, err)
+			}
+			extracted[hdr.Name] = extracted[hdr.Linkname]
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected %s in archive %s", hdr.Name, archivePath)
+		}
+	}
+
+	if expected == nil {
+		return fmt.Errorf("invalid archive %s: no %s", archivePath, ChecksumsFileName)
+	}
+	return verify(expected, extracted)
+}
+
+func extractFile(r io.Reader, target string) (string, error) {
+	f, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verify verifies the checksums of the files extracted, where every file is expected, and nothing else
+func verify(expected []byte, extracted map[string]string) error {
+	failures := []error{}
+	scanner := bufio.NewScanner(bytes.NewReader(expected))
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+		actual, ok := extracted[name]
+		switch {
+		case !ok:
+			failures = append(failures, fmt.Errorf("%s: missing", name))
+		case actual != sum:
+			failures = append(failures, fmt.Errorf("%s: checksum mismatch, expected %s, got %s", name, sum, actual))
+		}
+		delete(extracted, name)
+	}
+	for name := range extracted {
+		failures = append(failures, fmt.Errorf("%s: unexpected", name))
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("could not verify the archive: %w", errors.Join(failures...))
+	}
+	return nil
+}
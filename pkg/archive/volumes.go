@@ -0,0 +1,151 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package archive
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// volumeSuffix is the suffix of the volumes, e.g. bundle.tar.zst.000, bundle.tar.zst.001
+const volumeSuffix = ".%03d"
+
+// volumeWriter writes into the volumes of the fixed size, which are created as they're needed,
+// or into the single file if the size is 0
+type volumeWriter struct {
+	path string
+	size int64
+
+	volumes []string
+	current *os.File
+	written int64
+}
+
+func newVolumeWriter(path string, size int64) *volumeWriter {
+	return &volumeWriter{path: path, size: size}
+}
+
+func (w *volumeWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if w.current == nil || (w.size > 0 && w.written >= w.size) {
+			if err := w.next(); err != nil {
+				return n, err
+			}
+		}
+
+		chunk := p
+		if w.size > 0 && int64(len(chunk)) > w.size-w.written {
+			chunk = chunk[:w.size-w.written]
+		}
+		m, err := w.current.Write(chunk)
+		n += m
+		w.written += int64(m)
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// next closes the current volume, if there is, and creates the next one
+func (w *volumeWriter) next() error {
+	if w.current != nil {
+		if err := w.current.Close(); err != nil {
+			return err
+		}
+	}
+
+	path := w.path
+	if w.size > 0 {
+		path += fmt.Sprintf(volumeSuffix, len(w.volumes))
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create archive volume %s: %w", path, err)
+	}
+	w.volumes = append(w.volumes, path)
+	w.current = f
+	w.written = 0
+	return nil
+}
+
+func (w *volumeWriter) Close() error {
+	if w.current == nil {
+		// an empty archive still has its file
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	return w.current.Close()
+}
+
+// Volumes returns the volumes of the archive, which is the path itself if it's not split,
+// or the volumes of it, if the path is of the archive split, e.g. bundle.tar.zst, or of its first volume, e.g. bundle.tar.zst.000
+func Volumes(path string) ([]string, error) {
+	if _, err := os.Stat(path); err == nil && !strings.HasSuffix(path, fmt.Sprintf(volumeSuffix, 0)) {
+		return []string{path}, nil
+	}
+
+	base := strings.TrimSuffix(path, fmt.Sprintf(volumeSuffix, 0))
+	volumes := []string{}
+	for i := 0; ; i++ {
+		volume := base + fmt.Sprintf(volumeSuffix, i)
+		if _, err := os.Stat(volume); err != nil {
+			break
+		}
+		volumes = append(volumes, volume)
+	}
+	if len(volumes) == 0 {
+		return nil, fmt.Errorf("no archive %s, nor its volumes %s", path, base+fmt.Sprintf(volumeSuffix, 0))
+	}
+	return volumes, nil
+}
+
+// volumeReader reads the volumes in order, as a single stream
+type volumeReader struct {
+	volumes []string
+	current *os.File
+}
+
+func newVolumeReader(volumes []string) *volumeReader {
+	return &volumeReader{volumes: volumes}
+}
+
+func (r *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.volumes) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(r.volumes[0])
+			if err != nil {
+				return 0, fmt.Errorf("could not open archive volume %s: %w", r.volumes[0], err)
+			}
+			r.current = f
+			r.volumes = r.volumes[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *volumeReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// ChecksumsFileName is the file name of the checksums of all the files in the archive, in the format of sha256sum
+const ChecksumsFileName = "SHA256SUMS"
+
+// Writer streams the files of the bundle into the archive, where every file is removed once it's archived,
+// so that there is no full copy of the bundle staged on disk
+type Writer struct {
+	volumes    *volumeWriter
+	compressor io.WriteCloser
+	tw         *tar.Writer
+
+	// checksums are the checksums of the files archived, by their names in the archive
+	checksums map[string]string
+	// images are the images, and their artifacts, archived, by image reference,
+	// so that the images shared by multiple charts are archived only once, and linked to from the others
+	images map[string]*archivedImage
+}
+
+// archivedImage represents the image archived, which is a tarball, or an OCI image layout with multiple files
+type archivedImage struct {
+	path  string
+	files []string
+}
+
+// Create creates the archive, which is compressed by its extension, .tar.zst, .tar.gz, .tgz or .tar,
+// and split into the volumes of the size, e.g. bundle.tar.zst.000, bundle.tar.zst.001, if the size is more than 0
+func Create(archivePath string, volumeSize int64) (*Writer, error) {
+	volumes := newVolumeWriter(archivePath, volumeSize)
+
+	var compressor io.WriteCloser
+	switch {
+	case strings.HasSuffix(archivePath, ".tar.zst"):
+		zw, err := zstd.NewWriter(volumes)
+		if err != nil {
+			return nil, err
+		}
+		compressor = zw
+	case strings.HasSuffix(archivePath, ".tar.gz"), strings.HasSuffix(archivePath, ".tgz"):
+		compressor = gzip.NewWriter(volumes)
+	case strings.HasSuffix(archivePath, ".tar"):
+		compressor = nopWriteCloser{volumes}
+	default:
+		return nil, fmt.Errorf("unsupported archive %s, expected .tar.zst, .tar.gz, .tgz or .tar", archivePath)
+	}
+
+	return &Writer{
+		volumes:    volumes,
+		compressor: compressor,
+		tw:         tar.NewWriter(compressor),
+		checksums:  map[string]string{},
+		images:     map[string]*archivedImage{},
+	}, nil
+}
+
+// AddChart archives the chart and its images, written into the bundle directory, as the bundle manifest tells.
+// The image shared with a chart archived already is linked to the files archived, as it's removed already.
+func (w *Writer) AddChart(dir string, mc *api.ManifestChart) error {
+	for _, p := range []string{mc.Path, mc.Prov} {
+		if p == "" {
+			continue
+		}
+		if _, err := w.add(dir, p); err != nil {
+			return err
+		}
+	}
+
+	for _, image := range mc.Images {
+		if err := w.addImage(dir, image.Ref, image.Path); err != nil {
+			return err
+		}
+		if err := w.addImage(dir, image.Ref+" artifacts", image.ArtifactsPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addImage archives the image, or its artifacts, by the key, or links to the files archived already
+func (w *Writer) addImage(dir, key, path string) error {
+	if path == "" {
+		return nil
+	}
+
+	if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(path))); err == nil {
+		files, err := w.add(dir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := w.images[key]; !ok {
+			w.images[key] = &archivedImage{path: path, files: files}
+		}
+		return nil
+	}
+
+	archived, ok := w.images[key]
+	if !ok {
+		return fmt.Errorf("could not archive image %s: %s not found", key, path)
+	}
+	return w.link(archived, path)
+}
+
+// AddDir archives the rest of the files in the bundle directory, e.g. index.yaml and the chart files
+func (w *Writer) AddDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := w.add(dir, entry.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add archives the file, or all the files in the directory, relative to the bundle directory, and removes them.
+// It returns the names of the files archived.
+func (w *Writer) add(dir, rel string) ([]string, error) {
+	root := filepath.Join(dir, filepath.FromSlash(rel))
+	files := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if err := w.addFile(name, p); err != nil {
+			return fmt.Errorf("could not archive %s: %w", name, err)
+		}
+		files = append(files, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, os.RemoveAll(root)
+}
+
+func (w *Writer) addFile(name, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     0644,
+		ModTime:  info.ModTime(),
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w.tw, h), f); err != nil {
+		return err
+	}
+	w.checksums[name] = hex.EncodeToString(h.Sum(nil))
+
+	return nil
+}
+
+// link archives the files of the image archived already as hard links, under the new path
+func (w *Writer) link(archived *archivedImage, newPath string) error {
+	for _, file := range archived.files {
+		name := newPath + strings.TrimPrefix(file, archived.path)
+		hdr := &tar.Header{
+			Typeflag: tar.TypeLink,
+			Name:     name,
+			Linkname: file,
+			Mode:     0644,
+		}
+		if err := w.tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("could not archive %s: %w", name, err)
+		}
+		w.checksums[name] = w.checksums[file]
+	}
+	return nil
+}
+
+// Close archives the bundle manifest, and the checksums of all the files, and closes the archive
+func (w *Writer) Close(m *api.Manifest, dir string) error {
+	if m != nil {
+		if err := utils.WriteManifest(m, dir); err != nil {
+			return err
+		}
+		if _, err := w.add(dir, utils.ManifestFileName); err != nil {
+			return err
+		}
+	}
+
+	names := []string{}
+	for name := range w.checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var checksums bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&checksums, "%s  %s\n", w.checksums[name], name)
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ChecksumsFileName,
+		Size:     int64(checksums.Len()),
+		Mode:     0644,
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(checksums.Bytes()); err != nil {
+		return err
+	}
+
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if err := w.compressor.Close(); err != nil {
+		return err
+	}
+	return w.volumes.Close()
+}
+
+// Abort closes the archive unfinished, and removes the volumes written,
+// so that no truncated archive without its checksums is left behind
+func (w *Writer) Abort() {
+	w.tw.Close()
+	w.compressor.Close()
+	if w.volumes.current != nil {
+		w.volumes.current.Close()
+	}
+	for _, volume := range w.volumes.volumes {
+		os.Remove(volume)
+	}
+	w.volumes.volumes = nil
+}
+
+// Volumes returns the volumes written, which is the archive itself if it's not split
+func (w *Writer) Volumes() []string {
+	return w.volumes.volumes
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,221 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"helm.sh/helm/v3/pkg/chart"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// Format represents the format of the audit
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJSON  Format = "json"
+)
+
+// ParseFormat parses the format of the audit, table or json
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(format); f {
+	case FormatTable, FormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported audit format %q, expected %s or %s", format, FormatTable, FormatJSON)
+	}
+}
+
+// Flag flags what the legal review should look at in a chart
+type Flag string
+
+const (
+	FlagDeprecated Flag = "deprecated"
+	// FlagUnlicensed is for the chart with neither the license annotation nor a LICENSE file
+	FlagUnlicensed Flag = "unlicensed"
+)
+
+// licenseAnnotations are the annotations of the license, by Artifact Hub, and by the Bitnami charts
+var licenseAnnotations = []string{"artifacthub.io/license", "licenses"}
+
+// Audit is the license and metadata of the charts and their subcharts
+type Audit struct {
+	Charts []*ChartAudit `json:"charts"`
+}
+
+// ChartAudit is the license and metadata of a chart, or a subchart
+type ChartAudit struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	AppVersion string `json:"appVersion,omitempty"`
+	// Parent is the path of the charts the subchart is in, e.g. nginx or nginx/common, which is empty for the charts
+	Parent string `json:"parent,omitempty"`
+
+	// License is the license by the annotation, or identified from the LICENSE file
+	License string `json:"license,omitempty"`
+	// LicenseFile is the LICENSE file in the chart, if any
+	LicenseFile string `json:"licenseFile,omitempty"`
+
+	Maintainers []*chart.Maintainer `json:"maintainers,omitempty"`
+	Home        string              `json:"home,omitempty"`
+	Sources     []string            `json:"sources,omitempty"`
+	Deprecated  bool                `json:"deprecated"`
+	Annotations map[string]string   `json:"annotations,omitempty"`
+
+	Flags []Flag `json:"flags,omitempty"`
+}
+
+// Charts audits the charts and all their subcharts, where the subcharts follow the charts they're in
+func Charts(charts []*api.Chart) *Audit {
+	a := &Audit{Charts: []*ChartAudit{}}
+	for _, c := range charts {
+		a.add(c.C, "")
+	}
+	return a
+}
+
+func (a *Audit) add(c *chart.Chart, parent string) {
+	a.Charts = append(a.Charts, auditChart(c, parent))
+	for _, dep := range c.Dependencies() {
+		a.add(dep, path.Join(parent, c.Name()))
+	}
+}
+
+func auditChart(c *chart.Chart, parent string) *ChartAudit {
+	md := c.Metadata
+	ca := &ChartAudit{
+		Name:        md.Name,
+		Version:     md.Version,
+		AppVersion:  md.AppVersion,
+		Parent:      parent,
+		Maintainers: md.Maintainers,
+		Home:        md.Home,
+		Sources:     md.Sources,
+		Deprecated:  md.Deprecated,
+		Annotations: md.Annotations,
+	}
+
+	for _, key := range licenseAnnotations {
+		if license := strings.TrimSpace(md.Annotations[key]); license != "" {
+			ca.License = license
+			break
+		}
+	}
+	if f := licenseFile(c); f != nil {
+		ca.LicenseFile = f.Name
+		if ca.License == "" {
+			ca.License = identifyLicense(f.Data)
+		}
+	}
+
+	if ca.Deprecated {
+		ca.Flags = append(ca.Flags, FlagDeprecated)
+	}
+	if ca.License == "" && ca.LicenseFile == "" {
+		ca.Flags = append(ca.Flags, FlagUnlicensed)
+	}
+	return ca
+}
+
+// licenseFile returns the LICENSE file at the root of the chart, e.g. LICENSE, LICENSE.md or COPYING, if any
+func licenseFile(c *chart.Chart) *chart.File {
+	for _, f := range c.Files {
+		if strings.Contains(f.Name, "/") {
+			continue
+		}
+		base := strings.ToUpper(strings.TrimSuffix(f.Name, path.Ext(f.Name)))
+		if base == "LICENSE" || base == "LICENCE" || base == "COPYING" {
+			return f
+		}
+	}
+	return nil
+}
+
+// licenseTexts are the phrases to identify the common licenses from the LICENSE files by, in the order to look for them
+var licenseTexts = []struct {
+	license string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"MPL-2.0", []string{"Mozilla Public License", "Version 2.0"}},
+	{"AGPL-3.0", []string{"GNU AFFERO GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-3.0", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-3.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+}
+
+// identifyLicense identifies the license of the LICENSE file by the phrases of the common licenses,
+// or returns empty if it's unknown, which is left to the legal review of the file
+func identifyLicense(data []byte) string {
+	text := strings.Join(strings.Fields(string(data)), " ")
+	for _, lt := range licenseTexts {
+		matched := true
+		for _, phrase := range lt.phrases {
+			if !strings.Contains(text, phrase) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return lt.license
+		}
+	}
+	return ""
+}
+
+// Print prints the audit in the format, as JSON, or as a table, like:
+//
+//	CHART         VERSION  LICENSE     MAINTAINERS   SOURCES                            FLAGS
+//	nginx         15.4.4   Apache-2.0  VMware, Inc.  https://github.com/bitnami/charts  -
+//	nginx/common  2.13.3   Apache-2.0  VMware, Inc.  https://github.com/bitnami/charts  -
+//	legacy        1.0.0    -           -             -                                  deprecated, unlicensed
+//
+// where the subcharts are named after the charts they're in
+func (a *Audit) Print(w io.Writer, format Format) error {
+	if format == FormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(a)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHART\tVERSION\tLICENSE\tMAINTAINERS\tSOURCES\tFLAGS")
+	for _, ca := range a.Charts {
+		license := ca.License
+		if license == "" && ca.LicenseFile != "" {
+			license = "see " + ca.LicenseFile
+		}
+		maintainers := []string{}
+		for _, m := range ca.Maintainers {
+			if m != nil && m.Name != "" {
+				maintainers = append(maintainers, m.Name)
+			}
+		}
+		flags := []string{}
+		for _, f := range ca.Flags {
+			flags = append(flags, string(f))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			path.Join(ca.Parent, ca.Name), ca.Version, orDash(license), orDash(strings.Join(maintainers, ", ")),
+			orDash(strings.Join(ca.Sources, ", ")), orDash(strings.Join(flags, ", ")))
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
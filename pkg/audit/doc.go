@@ -0,0 +1,6 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit audits the license and metadata of the charts and their subcharts, e.g. the maintainers,
+// the source URLs and the deprecation, and flags the deprecated or unlicensed ones
+package audit
@@ -0,0 +1,90 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package chartloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+type bundlechartloader struct {
+	fromDir string
+}
+
+// NewBundleChartLoader creates a chart loader which reads the charts back from the bundle directory,
+// laid out as its bundle manifest tells, or from the legacy bundle without manifest, laid out flat in ${chartName}/
+func NewBundleChartLoader(fromDir string) *bundlechartloader {
+	return &bundlechartloader{
+		fromDir: fromDir,
+	}
+}
+
+func (cl *bundlechartloader) Load(ctx context.Context, config api.Config) ([]*api.Chart, error) {
+	manifest, err := utils.ReadBundle(cl.fromDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bundle %s: %w", cl.fromDir, err)
+	}
+
+	var charts []*api.Chart
+	var failures []error
+	for _, mc := range manifest.Charts {
+		if mc.Path == "" {
+			continue
+		}
+
+		c, err := cl.load(mc, config)
+		if err != nil {
+			err = &api.ChartError{Chart: mc.Name, Stage: api.StageLoad, Err: fmt.Errorf("version %s: %w", mc.Version, err)}
+			if !config.ContinueOnError {
+				return nil, err
+			}
+			failures = append(failures, err)
+			continue
+		}
+		charts = append(charts, c)
+	}
+
+	return charts, errors.Join(failures...)
+}
+
+// load loads the chart, with its provenance if it's kept in the bundle, which is verified if api.Config.Verify is set
+func (cl *bundlechartloader) load(mc *api.ManifestChart, config api.Config) (*api.Chart, error) {
+	chartFile := filepath.Join(cl.fromDir, filepath.FromSlash(mc.Path))
+	c, err := loader.Load(chartFile)
+	if err != nil {
+		return nil, err
+	}
+	chart := &api.Chart{C: c, Source: mc.Source}
+
+	if mc.Prov == "" {
+		if config.Verify {
+			return nil, fmt.Errorf("no provenance of %s to verify", mc.Path)
+		}
+		return chart, nil
+	}
+
+	chart.Provenance = &api.Provenance{Archive: chartFile, File: filepath.Join(cl.fromDir, filepath.FromSlash(mc.Prov))}
+	if config.Verify {
+		v, err := utils.VerifyChart(chart.Provenance.Archive, chart.Provenance.File, config.Keyring)
+		if err != nil {
+			return nil, err
+		}
+		chart.Provenance.SignedBy, chart.Provenance.Fingerprint = v.SignedBy, v.Fingerprint
+		utils.AddRecord(config.Report, mc.Name, "provenance", fmt.Sprintf("%s: signed by %s, with key fingerprint %s",
+			mc.Version, strings.Join(v.SignedBy, ", "), v.Fingerprint))
+	}
+	return chart, nil
+}
+
+func (cl *bundlechartloader) Finish(ctx context.Context, config api.Config) error {
+	return nil
+}
@@ -5,20 +5,28 @@ package chartloader
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
 	securejoin "github.com/cyphar/filepath-securejoin"
-	"github.com/pkg/errors"
+	pkgerrors "github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/downloader"
 	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/provenance"
 	"helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/repo"
 )
@@ -29,6 +37,20 @@ type remotechartloader struct {
 	fromChartRepo string
 	fromCharts    []string
 	toDir         string
+
+	username string
+	password string
+
+	certFile              string
+	keyFile               string
+	caFile                string
+	insecureSkipTLSverify bool
+	plainHTTP             bool
+
+	// index is the index.yaml of the chart repository, fetched once to list the versions of the charts
+	index *repo.IndexFile
+	// loaded are the directories of the charts' versions loaded
+	loaded []string
 }
 
 func NewRemoteChartLoader(fromChartRepo string, fromCharts []string, toDir string) *remotechartloader {
@@ -39,67 +61,201 @@ func NewRemoteChartLoader(fromChartRepo string, fromCharts []string, toDir strin
 	}
 }
 
+// WithBasicAuth sets the credentials of the chart repository
+func (cl *remotechartloader) WithBasicAuth(username, password string) *remotechartloader {
+	cl.username = username
+	cl.password = password
+	return cl
+}
+
+// WithTLS sets the client certificate and the CA of the chart repository
+func (cl *remotechartloader) WithTLS(certFile, keyFile, caFile string, insecureSkipTLSverify bool) *remotechartloader {
+	cl.certFile = certFile
+	cl.keyFile = keyFile
+	cl.caFile = caFile
+	cl.insecureSkipTLSverify = insecureSkipTLSverify
+	return cl
+}
+
+// WithPlainHTTP tells to talk to the OCI registry over plain HTTP
+func (cl *remotechartloader) WithPlainHTTP(plainHTTP bool) *remotechartloader {
+	cl.plainHTTP = plainHTTP
+	return cl
+}
+
+// Load pulls and loads the charts.
+// When a chart fails, it returns api.ChartError at once, or carries on with the rest of charts
+// if api.Config.ContinueOnError is set, and returns the loaded charts with the failures joined.
 func (cl *remotechartloader) Load(ctx context.Context, config api.Config) ([]*api.Chart, error) {
 	var charts []*api.Chart
+	var failures []error
 
 	actionConfig := new(action.Configuration)
 	client := action.NewPullWithOpts(action.WithConfig(actionConfig))
 
 	client.Settings = settings
-
-	registryClient, err := newRegistryClient(client.CertFile, client.KeyFile, client.CaFile,
-		client.InsecureSkipTLSverify, client.PlainHTTP)
-	if err != nil {
-		return nil, fmt.Errorf("missing registry client: %w", err)
+	client.Username = cl.username
+	client.Password = cl.password
+	client.CertFile = cl.certFile
+	client.KeyFile = cl.keyFile
+	client.CaFile = cl.caFile
+	client.InsecureSkipTLSverify = cl.insecureSkipTLSverify
+	client.PlainHTTP = cl.plainHTTP
+	client.Verify = config.Verify
+	client.Keyring = config.Keyring
+
+	// the credentials of the OCI registry are kept in a credentials file of its own,
+	// so that the user's one is neither needed nor touched
+	credentialsFile := settings.RegistryConfig
+	if registry.IsOCI(cl.fromChartRepo) && cl.username != "" {
+		f, err := writeCredentialsFile(cl.fromChartRepo, cl.username, cl.password)
+		if err != nil {
+			return nil, fmt.Errorf("could not write registry credentials: %w", err)
+		}
+		defer os.Remove(f)
+		credentialsFile = f
 	}
-	client.SetRegistryClient(registryClient)
 
-	for i := 0; i < len(cl.fromCharts); i++ {
-		client.Version = ">0.0.0-0"
-		chartVer := strings.Split(cl.fromCharts[i], ":")
-		chartName := chartVer[0]
-		if len(chartVer) == 2 {
-			chartVersion := chartVer[1]
-			client.Version = chartVersion
+	for _, fromChart := range cl.fromCharts {
+		ref, err := parseChartRef(fromChart)
+		if err != nil {
+			err = &api.ChartError{Chart: fromChart, Stage: api.StageLoad, Err: err}
+			if !config.ContinueOnError {
+				return nil, err
+			}
+			failures = append(failures, err)
+			continue
 		}
+		chartName := ref.name
 
-		url := fmt.Sprintf("%s/%s", cl.fromChartRepo, chartName)
-
-		client.DestDir = fmt.Sprintf("%s/%s", cl.toDir, chartName)
-		client.Untar = true       // always untar for image processing
-		client.UntarDir = "chart" // fmt.Sprintf("%s/chart", chartName)
-
-		if err := os.MkdirAll(fmt.Sprintf("%s/%s/%s", cl.toDir, chartName, "chart"), 0755); err != nil {
-			return nil, errors.Wrap(err, "failed to untar (mkdir)")
+		// the registry client is per chart so that its retries are recorded against the chart
+		retry := func(rt http.RoundTripper) http.RoundTripper {
+			return utils.NewRetryTransport(rt, config.Retry, config.Report, chartName)
 		}
-
-		//output, err := client.Run(url)
-		output, chartpath, err := cl.run(client, registryClient, url, config)
+		registryClient, err := newRegistryClient(client.CertFile, client.KeyFile, client.CaFile,
+			client.InsecureSkipTLSverify, client.PlainHTTP, credentialsFile, retry)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("missing registry client: %w", err)
+		}
+		client.SetRegistryClient(registryClient)
+		getters, err := newGetters(client, retry)
+		if err != nil {
+			return nil, fmt.Errorf("missing chart repository getters: %w", err)
 		}
-		fmt.Fprint(os.Stdout, output)
 
-		chart, err := loader.Load(chartpath)
+		versions, err := cl.versions(ctx, client, registryClient, getters, ref, config)
 		if err != nil {
-			fmt.Println(fmt.Sprintf("could not load chart '%s' from %s: %s", chartName, cl.fromChartRepo, err))
+			err = &api.ChartError{Chart: chartName, Stage: api.StageLoad, Err: err}
+			if !config.ContinueOnError {
+				return nil, err
+			}
+			failures = append(failures, err)
+			continue
+		}
+
+		for _, version := range versions {
+			client.Version = version
+			c, err := cl.load(ctx, client, registryClient, getters, chartName, version, config)
+			if err != nil {
+				err = &api.ChartError{Chart: chartName, Stage: api.StageLoad, Err: fmt.Errorf("version %s: %w", version, err)}
+				if !config.ContinueOnError {
+					return nil, err
+				}
+				failures = append(failures, err)
+				continue
+			}
+			charts = append(charts, c)
 		}
-		charts = append(charts, &api.Chart{C: chart})
 	}
 
-	return charts, nil
+	return charts, errors.Join(failures...)
+}
+
+// versions resolves the versions of the chart to load, where the exact version is taken as is
+func (cl *remotechartloader) versions(ctx context.Context, client *action.Pull, registryClient *registry.Client, getters getter.Providers, ref chartRef, config api.Config) ([]string, error) {
+	if ref.isExact() {
+		return []string{ref.version}, nil
+	}
+
+	available, err := cl.listVersions(ctx, client, registryClient, getters, ref.name, config)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := selectVersions(available, ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) > 1 && config.Layout == api.LayoutFlat {
+		return nil, fmt.Errorf("could not lay out versions %s in layout %q, use layout %q instead",
+			strings.Join(versions, ", "), api.LayoutFlat, api.LayoutVersioned)
+	}
+	if ref.version != "" || ref.lastMinors > 0 {
+		utils.AddRecord(config.Report, ref.name, "versions", fmt.Sprintf("selected %s", strings.Join(versions, ", ")))
+	}
+	return versions, nil
+}
+
+// load pulls the chart of the version into the directory of the layout, e.g. ${toDir}/${chartName}/${chartVersion}, and loads it
+func (cl *remotechartloader) load(ctx context.Context, client *action.Pull, registryClient *registry.Client, getters getter.Providers, chartName, chartVersion string, config api.Config) (*api.Chart, error) {
+	// the charts in OCI registries are referred to by URL, while the ones in chart repositories
+	// are looked up in the repository's index.yaml by name
+	url := fmt.Sprintf("%s/%s", cl.fromChartRepo, chartName)
+	if !registry.IsOCI(cl.fromChartRepo) {
+		client.RepoURL = cl.fromChartRepo
+		url = chartName
+	}
+
+	chartDir := utils.ChartDir(cl.toDir, config.Layout, chartName, chartVersion)
+	client.DestDir = chartDir
+	client.Untar = true       // always untar for image processing
+	client.UntarDir = "chart" // fmt.Sprintf("%s/chart", chartName)
+
+	if err := os.MkdirAll(filepath.Join(chartDir, "chart"), 0755); err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to untar (mkdir)")
+	}
+	cl.loaded = append(cl.loaded, chartDir)
+
+	//output, err := client.Run(url)
+	output, chartpath, v, err := cl.run(ctx, client, registryClient, getters, url)
+	if err != nil {
+		return nil, err
+	}
+	// the provenance verified is told on stderr, as stdout may be the audit to parse
+	fmt.Fprint(os.Stderr, output)
+
+	c, err := loader.Load(chartpath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load chart '%s' from %s: %w", chartName, cl.fromChartRepo, err)
+	}
+
+	// the chart archive verified is kept as it is, with its .prov file downloaded next to it
+	chart := &api.Chart{C: c, Source: cl.fromChartRepo}
+	if v != nil {
+		verified := utils.FromProvenance(v)
+		chart.Provenance = &api.Provenance{
+			Archive:     chartpath,
+			File:        chartpath + ".prov",
+			SignedBy:    verified.SignedBy,
+			Fingerprint: verified.Fingerprint,
+		}
+		utils.AddRecord(config.Report, chartName, "provenance", fmt.Sprintf("%s: signed by %s, with key fingerprint %s",
+			chartVersion, strings.Join(verified.SignedBy, ", "), verified.Fingerprint))
+	}
+
+	return chart, nil
 }
 
 // copied from https://github.com/helm/helm/blob/main/cmd/helm/root.go
-func newRegistryClient(certFile, keyFile, caFile string, insecureSkipTLSverify, plainHTTP bool) (*registry.Client, error) {
+// and modified to wrap the HTTP transport, e.g. for retries
+func newRegistryClient(certFile, keyFile, caFile string, insecureSkipTLSverify, plainHTTP bool, credentialsFile string, wrap func(http.RoundTripper) http.RoundTripper) (*registry.Client, error) {
 	if certFile != "" && keyFile != "" || caFile != "" || insecureSkipTLSverify {
-		registryClient, err := newRegistryClientWithTLS(certFile, keyFile, caFile, insecureSkipTLSverify)
+		registryClient, err := newRegistryClientWithTLS(certFile, keyFile, caFile, insecureSkipTLSverify, credentialsFile, wrap)
 		if err != nil {
 			return nil, err
 		}
 		return registryClient, nil
 	}
-	registryClient, err := newDefaultRegistryClient(plainHTTP)
+	registryClient, err := newDefaultRegistryClient(plainHTTP, credentialsFile, wrap)
 	if err != nil {
 		return nil, err
 	}
@@ -107,12 +263,16 @@ func newRegistryClient(certFile, keyFile, caFile string, insecureSkipTLSverify,
 }
 
 // copied from https://github.com/helm/helm/blob/main/cmd/helm/root.go
-func newDefaultRegistryClient(plainHTTP bool) (*registry.Client, error) {
+// and modified to wrap the HTTP transport, e.g. for retries
+func newDefaultRegistryClient(plainHTTP bool, credentialsFile string, wrap func(http.RoundTripper) http.RoundTripper) (*registry.Client, error) {
 	opts := []registry.ClientOption{
 		registry.ClientOptDebug(false),
 		registry.ClientOptEnableCache(true),
 		registry.ClientOptWriter(os.Stderr),
-		registry.ClientOptCredentialsFile(settings.RegistryConfig),
+		registry.ClientOptCredentialsFile(credentialsFile),
+		registry.ClientOptHTTPClient(&http.Client{
+			Transport: wrap(http.DefaultTransport),
+		}),
 	}
 	if plainHTTP {
 		opts = append(opts, registry.ClientOptPlainHTTP())
@@ -126,11 +286,25 @@ func newDefaultRegistryClient(plainHTTP bool) (*registry.Client, error) {
 	return registryClient, nil
 }
 
-// copied from https://github.com/helm/helm/blob/main/cmd/helm/root.go
-func newRegistryClientWithTLS(certFile, keyFile, caFile string, insecureSkipTLSverify bool) (*registry.Client, error) {
+// copied from https://github.com/helm/helm/blob/main/pkg/registry/util.go
+// and modified to wrap the HTTP transport, e.g. for retries
+func newRegistryClientWithTLS(certFile, keyFile, caFile string, insecureSkipTLSverify bool, credentialsFile string, wrap func(http.RoundTripper) http.RoundTripper) (*registry.Client, error) {
+	tlsConf, err := newClientTLS(certFile, keyFile, caFile, insecureSkipTLSverify)
+	if err != nil {
+		return nil, fmt.Errorf("can't create TLS config for client: %s", err)
+	}
 	// Create a new registry client
-	registryClient, err := registry.NewRegistryClientWithTLS(os.Stderr, certFile, keyFile, caFile, insecureSkipTLSverify,
-		settings.RegistryConfig, settings.Debug,
+	registryClient, err := registry.NewClient(
+		registry.ClientOptDebug(settings.Debug),
+		registry.ClientOptEnableCache(true),
+		registry.ClientOptWriter(os.Stderr),
+		registry.ClientOptCredentialsFile(credentialsFile),
+		registry.ClientOptHTTPClient(&http.Client{
+			Transport: wrap(&http.Transport{
+				TLSClientConfig: tlsConf,
+				Proxy:           http.ProxyFromEnvironment,
+			}),
+		}),
 	)
 	if err != nil {
 		return nil, err
@@ -138,17 +312,106 @@ func newRegistryClientWithTLS(certFile, keyFile, caFile string, insecureSkipTLSv
 	return registryClient, nil
 }
 
+// newGetters returns the getters of Helm, whose HTTP getter sends the requests by the transport wrapped, e.g. for retries.
+// As the HTTP getter takes an *http.Transport only, the wrapped transport is registered as its http and https protocols,
+// which take over all the requests, with the TLS of the chart repository set on the inner transport
+func newGetters(p *action.Pull, wrap func(http.RoundTripper) http.RoundTripper) (getter.Providers, error) {
+	tlsConf, err := newClientTLS(p.CertFile, p.KeyFile, p.CaFile, p.InsecureSkipTLSverify)
+	if err != nil {
+		return nil, fmt.Errorf("can't create TLS config for client: %s", err)
+	}
+	wrapped := wrap(&http.Transport{
+		DisableCompression: true,
+		Proxy:              http.ProxyFromEnvironment,
+		TLSClientConfig:    tlsConf,
+	})
+
+	// the empty TLSNextProto keeps HTTP/2 from being set up on the transport, which never dials itself
+	t := &http.Transport{TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{}}
+	t.RegisterProtocol("http", wrapped)
+	t.RegisterProtocol("https", wrapped)
+
+	getters := getter.Providers{{
+		Schemes: []string{"http", "https"},
+		New: func(options ...getter.Option) (getter.Getter, error) {
+			return getter.NewHTTPGetter(append([]getter.Option{getter.WithTransport(t)}, options...)...)
+		},
+	}}
+	for _, provider := range getter.All(p.Settings) {
+		if !provider.Provides("http") {
+			getters = append(getters, provider)
+		}
+	}
+	return getters, nil
+}
+
+// writeCredentialsFile writes the credentials of the OCI registry into a temporary file,
+// in the format of Docker's config.json, and returns the file's path
+func writeCredentialsFile(ociURL, username, password string) (string, error) {
+	host := strings.SplitN(strings.TrimPrefix(ociURL, fmt.Sprintf("%s://", registry.OCIScheme)), "/", 2)[0]
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	data, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			host: map[string]string{"auth": auth},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "helm-packager-registry-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// newClientTLS is a simplified version of Helm's internal tlsutil.NewClientTLS
+func newClientTLS(certFile, keyFile, caFile string, insecureSkipTLSverify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: insecureSkipTLSverify,
+	}
+
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file %s: %w", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("could not append certificates from %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
 // run is a modified version of Helm Pull command's Run function
 // run downloads the chart and untar it always for necessary image processing
-// but whether the untar files are kept or not depends on the config of api.Config.IncludeChartFiles
-func (cl *remotechartloader) run(p *action.Pull, rc *registry.Client, chartRef string, config api.Config) (string, string, error) {
+// but whether the untar files are kept or not depends on the config of api.Config.IncludeChartFiles.
+// It returns the verification of the chart's provenance too, if p.Verify is set.
+func (cl *remotechartloader) run(ctx context.Context, p *action.Pull, rc *registry.Client, getters getter.Providers, chartRef string) (string, string, *provenance.Verification, error) {
 	var out strings.Builder
 
 	c := downloader.ChartDownloader{
 		Out:     &out,
 		Keyring: p.Keyring,
 		Verify:  downloader.VerifyNever,
-		Getters: getter.All(p.Settings),
+		Getters: getters,
 		Options: []getter.Option{
 			getter.WithBasicAuth(p.Username, p.Password),
 			getter.WithPassCredentialsAll(p.PassCredentialsAll),
@@ -174,9 +437,9 @@ func (cl *remotechartloader) run(p *action.Pull, rc *registry.Client, chartRef s
 	}
 
 	if p.RepoURL != "" {
-		chartURL, err := repo.FindChartInAuthAndTLSAndPassRepoURL(p.RepoURL, p.Username, p.Password, chartRef, p.Version, p.CertFile, p.KeyFile, p.CaFile, p.InsecureSkipTLSverify, p.PassCredentialsAll, getter.All(p.Settings))
+		chartURL, err := repo.FindChartInAuthAndTLSAndPassRepoURL(p.RepoURL, p.Username, p.Password, chartRef, p.Version, p.CertFile, p.KeyFile, p.CaFile, p.InsecureSkipTLSverify, p.PassCredentialsAll, getters)
 		if err != nil {
-			return out.String(), "", err
+			return out.String(), "", nil, err
 		}
 		chartRef = chartURL
 	}
@@ -184,11 +447,11 @@ func (cl *remotechartloader) run(p *action.Pull, rc *registry.Client, chartRef s
 	// always download to the configured folder
 	saved, v, err := c.DownloadTo(chartRef, p.Version, p.DestDir)
 	if err != nil {
-		return out.String(), "", err
+		return out.String(), "", nil, err
 	}
 
 	if p.Verify {
-		for name := range v.SignedBy.Identities {
+		for _, name := range utils.FromProvenance(v).SignedBy {
 			fmt.Fprintf(&out, "Signed by: %v\n", name)
 		}
 		fmt.Fprintf(&out, "Using Key With Fingerprint: %X\n", v.SignedBy.PrimaryKey.Fingerprint)
@@ -211,13 +474,16 @@ func (cl *remotechartloader) run(p *action.Pull, rc *registry.Client, chartRef s
 
 	if _, err := os.Stat(udCheck); err != nil {
 		if err := os.MkdirAll(udCheck, 0755); err != nil {
-			return out.String(), "", errors.Wrap(err, "failed to untar (mkdir)")
+			return out.String(), "", nil, pkgerrors.Wrap(err, "failed to untar (mkdir)")
 		}
 	} else {
-		return out.String(), "", errors.Errorf("failed to untar: a file or directory with the name %s already exists", udCheck)
+		return out.String(), "", nil, pkgerrors.Errorf("failed to untar: a file or directory with the name %s already exists", udCheck)
 	}
 
-	return out.String(), saved, expandFile(ud, saved)
+	if !p.Verify {
+		v = nil
+	}
+	return out.String(), saved, v, expandFile(ud, saved)
 }
 
 // expandFile expands the src file into the dest directory.
@@ -262,12 +528,9 @@ func expand(dir string, r io.Reader) error {
 func (cl *remotechartloader) Finish(ctx context.Context, config api.Config) error {
 	// we need to clean up if chart files are not included as they will be downloaded by default
 	if !config.ChartFilesIncluded {
-		for i := 0; i < len(cl.fromCharts); i++ {
-			chartVer := strings.Split(cl.fromCharts[i], ":")
-			chartName := chartVer[0]
-
-			// remote ${toDIr}/{chartName}/chart/*
-			os.RemoveAll(fmt.Sprintf("%s/%s/%s", cl.toDir, chartName, "chart"))
+		for _, chartDir := range cl.loaded {
+			// remote ${toDir}/${chartName}/${chartVersion}/chart/*
+			os.RemoveAll(filepath.Join(chartDir, "chart"))
 		}
 	}
 
@@ -0,0 +1,79 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package chartloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+const index = `apiVersion: v1
+entries:
+  nginx:
+  - name: nginx
+    version: 15.4.4
+    urls:
+    - nginx-15.4.4.tgz
+`
+
+func TestNewGettersRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		status   int
+		wantErr  bool
+		retries  int
+	}{
+		{"index fetched after retries", 2, http.StatusTooManyRequests, false, 2},
+		{"index not found", 1, http.StatusNotFound, true, 0},
+		{"out of attempts", 3, http.StatusServiceUnavailable, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= tt.failures {
+					w.Header().Set("Retry-After", "1")
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Write([]byte(index))
+			}))
+			defer server.Close()
+
+			report := &api.Report{}
+			policy := api.RetryPolicy{Attempts: 3, Backoff: time.Minute, HonorRetryAfter: true}
+			p := action.NewPullWithOpts(action.WithConfig(new(action.Configuration)))
+			p.Settings = settings
+			getters, err := newGetters(p, func(rt http.RoundTripper) http.RoundTripper {
+				return utils.NewRetryTransport(rt, policy, report, "nginx")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := repo.NewChartRepository(&repo.Entry{Name: "test", URL: server.URL}, getters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.CachePath = t.TempDir()
+			_, err = r.DownloadIndexFile()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownloadIndexFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(report.Records) != tt.retries {
+				t.Errorf("got %d retries recorded, want %d", len(report.Records), tt.retries)
+			}
+		})
+	}
+}
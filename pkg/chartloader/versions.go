@@ -0,0 +1,189 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package chartloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+var lastMinorsRegex = regexp.MustCompile(`^last-(\d+)-minors$`)
+
+// chartRef represents the chart to load, in the form of <name>[:<version>][:last-<N>-minors],
+// where the version is the exact version, or the version constraint, e.g. ^15.0.0
+type chartRef struct {
+	name       string
+	version    string
+	lastMinors int
+}
+
+// parseChartRef parses the chart reference, for example:
+//
+//	nginx                         the latest version
+//	nginx:15.4.4                  the exact version
+//	nginx:^15.0.0                 all the versions matching the constraint
+//	nginx:last-3-minors           the latest patch of each of the last 3 minor versions
+//	nginx:^15.0.0:last-3-minors   the latest patch of each of the last 3 minor versions matching the constraint
+func parseChartRef(s string) (chartRef, error) {
+	parts := strings.SplitN(s, ":", 3)
+	ref := chartRef{name: parts[0]}
+	if ref.name == "" {
+		return ref, fmt.Errorf("invalid chart %q: empty name", s)
+	}
+
+	for _, part := range parts[1:] {
+		if m := lastMinorsRegex.FindStringSubmatch(part); m != nil {
+			n, err := strconv.Atoi(m[1])
+			if err != nil || n < 1 {
+				return ref, fmt.Errorf("invalid chart %q: invalid number of minor versions %s", s, m[1])
+			}
+			ref.lastMinors = n
+			continue
+		}
+		if ref.version != "" || ref.lastMinors > 0 {
+			return ref, fmt.Errorf("invalid chart %q: expected <name>[:<version>][:last-<N>-minors]", s)
+		}
+		if _, err := semver.NewConstraint(part); err != nil {
+			return ref, fmt.Errorf("invalid chart %q: invalid version %s: %w", s, part, err)
+		}
+		ref.version = part
+	}
+
+	return ref, nil
+}
+
+// isExact tells whether the reference is of an exact version, which needs no listing of the versions
+func (ref chartRef) isExact() bool {
+	if ref.lastMinors > 0 || ref.version == "" {
+		return false
+	}
+	_, err := semver.StrictNewVersion(ref.version)
+	return err == nil
+}
+
+// selectVersions selects the versions to load out of the available ones, from the highest to the lowest:
+// the latest one if there is no version, or all the ones matching the version constraint,
+// and then, if lastMinors is set, the latest patch of each of the last minor versions only
+func selectVersions(available []string, ref chartRef) ([]string, error) {
+	versions := []*semver.Version{}
+	for _, v := range available {
+		if version, err := semver.NewVersion(v); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	sort.Sort(sort.Reverse(semver.Collection(versions)))
+
+	var constraint *semver.Constraints
+	if ref.version != "" {
+		var err error
+		constraint, err = semver.NewConstraint(ref.version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matched := []*semver.Version{}
+	for _, version := range versions {
+		switch {
+		case constraint != nil:
+			if !constraint.Check(version) {
+				continue
+			}
+		case ref.lastMinors > 0:
+			// the prereleases are skipped as the upgrade paths are between the releases
+			if version.Prerelease() != "" {
+				continue
+			}
+		}
+		matched = append(matched, version)
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no version of chart %s matches %q", ref.name, ref.version)
+	}
+	if ref.version == "" && ref.lastMinors == 0 {
+		matched = matched[:1]
+	}
+
+	selected := []string{}
+	minors := map[string]bool{}
+	for _, version := range matched {
+		if ref.lastMinors > 0 {
+			minor := fmt.Sprintf("%d.%d", version.Major(), version.Minor())
+			if minors[minor] {
+				continue
+			}
+			if len(minors) == ref.lastMinors {
+				break
+			}
+			minors[minor] = true
+		}
+		selected = append(selected, version.Original())
+	}
+
+	return selected, nil
+}
+
+// listVersions lists the versions of the chart, from the OCI tags list, or from the index.yaml of the chart repository
+func (cl *remotechartloader) listVersions(ctx context.Context, client *action.Pull, rc *registry.Client, getters getter.Providers, chartName string, config api.Config) ([]string, error) {
+	if registry.IsOCI(cl.fromChartRepo) {
+		ref := fmt.Sprintf("%s/%s", strings.TrimPrefix(cl.fromChartRepo, fmt.Sprintf("%s://", registry.OCIScheme)), chartName)
+		tags, err := rc.Tags(ref)
+		if err != nil {
+			return nil, fmt.Errorf("could not list tags of %s: %w", ref, err)
+		}
+		return tags, nil
+	}
+
+	if cl.index == nil {
+		entry := &repo.Entry{
+			// the cached index is named after the repository URL so that the repositories don't collide
+			Name:                  fmt.Sprintf("helm-packager-%x", sha256.Sum256([]byte(cl.fromChartRepo)))[:28],
+			URL:                   cl.fromChartRepo,
+			Username:              client.Username,
+			Password:              client.Password,
+			CertFile:              client.CertFile,
+			KeyFile:               client.KeyFile,
+			CAFile:                client.CaFile,
+			InsecureSkipTLSverify: client.InsecureSkipTLSverify,
+			PassCredentialsAll:    client.PassCredentialsAll,
+		}
+		r, err := repo.NewChartRepository(entry, getters)
+		if err != nil {
+			return nil, err
+		}
+		r.CachePath = settings.RepositoryCache
+
+		indexFile, err := r.DownloadIndexFile()
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch index of %s: %w", cl.fromChartRepo, err)
+		}
+		cl.index, err = repo.LoadIndexFile(indexFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load index of %s: %w", cl.fromChartRepo, err)
+		}
+	}
+
+	versions := []string{}
+	for _, cv := range cl.index.Entries[chartName] {
+		versions = append(versions, cv.Version)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("chart %s not found in %s", chartName, cl.fromChartRepo)
+	}
+	return versions, nil
+}
@@ -10,8 +10,7 @@ import (
 	"path/filepath"
 
 	securejoin "github.com/cyphar/filepath-securejoin"
-	"helm.sh/helm/v3/pkg/chart/loader"
-	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
 
 	"github.com/brightzheng100/helm-packager/pkg/api"
 	"github.com/brightzheng100/helm-packager/pkg/utils"
@@ -19,6 +18,9 @@ import (
 
 type filechartwriter struct {
 	toDir string
+
+	// written are the directories of the charts' versions written
+	written []string
 }
 
 func NewFileChartWriter(toDir string) *filechartwriter {
@@ -27,9 +29,16 @@ func NewFileChartWriter(toDir string) *filechartwriter {
 	}
 }
 
+// Dir returns the bundle directory the charts are written into
+func (cw *filechartwriter) Dir() string {
+	return cw.toDir
+}
+
 // Write writes the chart files from fs.FS first
 // and then archive it as tarball
 func (cw *filechartwriter) Write(ctx context.Context, chart *api.Chart, config api.Config) error {
+	cw.written = append(cw.written, utils.ChartDir(cw.toDir, config.Layout, chart.C.Metadata.Name, chart.C.Metadata.Version))
+
 	// write chart files
 	err := cw.writeChartFiles(ctx, chart, config)
 	if err != nil {
@@ -46,12 +55,12 @@ func (cw *filechartwriter) Write(ctx context.Context, chart *api.Chart, config a
 }
 
 func (cw *filechartwriter) writeChartFiles(ctx context.Context, chart *api.Chart, config api.Config) error {
-	// build tree if enabled
-	if config.ChartFilesIncluded {
-		utils.AddChartFiles(config.TreeRoot, chart.C.Metadata.Name, chart.C.Raw)
+	if !config.ChartFilesIncluded {
+		return nil
 	}
+	utils.AddChartFiles(config.TreeRoot, config.Layout, chart.C.Metadata.Name, chart.C.Metadata.Version, chart.C.Raw)
 
-	chartFilesRoot := fmt.Sprintf("%s/%s/%s", cw.toDir, chart.C.Metadata.Name, "chart")
+	chartFilesRoot := filepath.Join(utils.ChartDir(cw.toDir, config.Layout, chart.C.Metadata.Name, chart.C.Metadata.Version), "chart")
 
 	for _, file := range chart.C.Raw {
 		outpath, err := securejoin.SecureJoin(chartFilesRoot, file.Name)
@@ -74,35 +83,58 @@ func (cw *filechartwriter) writeChartFiles(ctx context.Context, chart *api.Chart
 }
 
 func (cw *filechartwriter) writeChart(ctx context.Context, chart *api.Chart, config api.Config) error {
-	chartFolder := fmt.Sprintf("%s/%s", cw.toDir, chart.C.Metadata.Name)
-	chartFilesRoot := fmt.Sprintf("%s/%s/%s", cw.toDir, chart.C.Metadata.Name, "chart")
+	chartFolder := utils.ChartDir(cw.toDir, config.Layout, chart.C.Metadata.Name, chart.C.Metadata.Version)
 
-	ch, err := loader.LoadDir(chartFilesRoot)
+	// the chart is saved as it's loaded and transformed, instead of from the chart files,
+	// which may have stale files of the loader, e.g. the tests stripped
+	saved, prov, err := saveChart(chart, chartFolder, config)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to write chart %s: %w", chart.C.Metadata.Name, err)
 	}
+	utils.AddManifestSource(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, chart.Source)
+	utils.AddManifestUpstreamVersion(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, chart.UpstreamVersion)
 
-	_, err = chartutil.Save(ch, chartFolder)
+	// the chart in the previous bundle is left out of the delta bundle
+	if base := utils.FindChart(config.Since, chart.C.Metadata.Name, chart.C.Metadata.Version); base != nil {
+		if err := os.Remove(saved); err != nil {
+			return fmt.Errorf("failed to leave out chart %s: %w", saved, err)
+		}
+		if prov != "" {
+			os.Remove(prov)
+		}
+		utils.AddManifestChart(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, "", base.Digest)
+		utils.AddRecord(config.Report, chart.C.Metadata.Name, "delta", fmt.Sprintf("%s: unchanged, left out", chart.C.Metadata.Version))
+		return nil
+	}
+
+	digest, err := provenance.DigestFile(saved)
 	if err != nil {
-		return fmt.Errorf("failed to write chart %s: %w", chart.C.Metadata.Name, err)
+		return fmt.Errorf("failed to digest chart %s: %w", saved, err)
+	}
+	rel, err := filepath.Rel(cw.toDir, saved)
+	if err != nil {
+		return err
 	}
+	utils.AddManifestChart(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, rel, "sha256:"+digest)
 
-	fileName := fmt.Sprintf("%s-%s.tgz", chart.C.Metadata.Name, chart.C.Metadata.Version)
-	utils.AddChart(config.TreeRoot, chart.C.Metadata.Name, fileName)
+	fileName := filepath.Base(saved)
+	utils.AddChart(config.TreeRoot, config.Layout, chart.C.Metadata.Name, chart.C.Metadata.Version, fileName)
+	if prov != "" {
+		utils.AddManifestProv(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, rel+".prov")
+		utils.AddChart(config.TreeRoot, config.Layout, chart.C.Metadata.Name, chart.C.Metadata.Version, fileName+".prov")
+	}
 
 	return nil
 }
 
 func (cw *filechartwriter) Finish(ctx context.Context, config api.Config) error {
-	// we need to clean up if chart files are not included as they will be downloaded by default
-	// if !config.ChartFilesIncluded {
-	// 	for i := 0; i < len(cw.fromCharts); i++ {
-	// 		chartVer := strings.Split(cl.fromCharts[i], ":")
-	// 		chartName := chartVer[0]
-
-	// 		// remote ${toDIr}/{chartName}/chart/*
-	// 		os.RemoveAll(fmt.Sprintf("%s/%s/%s", cl.toDir, chartName, "chart"))
-	// 	}
-	// }
+	// we need to clean up if chart files are not included, as a loader may have downloaded them
+	// into the same directories as the charts written, e.g. when it loads into toDir too
+	if !config.ChartFilesIncluded {
+		for _, chartDir := range cw.written {
+			// remove ${toDir}/${chartName}/${chartVersion}/chart/*
+			os.RemoveAll(filepath.Join(chartDir, "chart"))
+		}
+	}
 	return nil
 }
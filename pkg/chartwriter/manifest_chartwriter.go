@@ -0,0 +1,31 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package chartwriter
+
+import (
+	"context"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+type manifestchartwriter struct {
+}
+
+// NewManifestChartWriter creates a chart writer which records the charts into the bundle manifest only,
+// without writing them, e.g. to tell what a bundle spec resolves to
+func NewManifestChartWriter() *manifestchartwriter {
+	return &manifestchartwriter{}
+}
+
+func (cw *manifestchartwriter) Write(ctx context.Context, chart *api.Chart, config api.Config) error {
+	utils.AddManifestChart(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, "", "")
+	utils.AddManifestSource(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, chart.Source)
+	utils.AddManifestUpstreamVersion(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, chart.UpstreamVersion)
+	return nil
+}
+
+func (cw *manifestchartwriter) Finish(ctx context.Context, config api.Config) error {
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package chartwriter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// saveChart saves the chart archive into the directory, and returns its path, and the path of its .prov file if there is.
+// The chart with provenance is saved as its archive is loaded, with its .prov file, so that it can be verified again,
+// while the others are re-packaged, as they're loaded and transformed, and signed if api.Config.Signer is set.
+func saveChart(chart *api.Chart, dir string, config api.Config) (string, string, error) {
+	if chart.Provenance == nil {
+		saved, err := chartutil.Save(chart.C, dir)
+		if err != nil {
+			return "", "", err
+		}
+		// the .prov file downloaded with the chart doesn't sign the re-packaged one
+		os.Remove(saved + ".prov")
+		if config.Signer == nil {
+			return saved, "", nil
+		}
+
+		prov, err := utils.SignChart(config.Signer, saved)
+		if err != nil {
+			return "", "", err
+		}
+		utils.AddRecord(config.Report, chart.C.Metadata.Name, "provenance",
+			fmt.Sprintf("%s: signed by %s", chart.C.Metadata.Version, strings.Join(utils.Signers(config.Signer), ", ")))
+		return saved, prov, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	saved := filepath.Join(dir, filepath.Base(chart.Provenance.Archive))
+	if err := copyFile(chart.Provenance.Archive, saved); err != nil {
+		return "", "", err
+	}
+	if err := copyFile(chart.Provenance.File, saved+".prov"); err != nil {
+		return "", "", err
+	}
+	return saved, saved + ".prov", nil
+}
+
+// copyFile copies the file, unless it's copied to itself
+func copyFile(src, dst string) error {
+	if filepath.Clean(src) == filepath.Clean(dst) {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
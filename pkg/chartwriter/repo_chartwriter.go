@@ -0,0 +1,123 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package chartwriter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+type repochartwriter struct {
+	toDir   string
+	baseURL string
+
+	index *repo.IndexFile
+}
+
+// NewRepoChartWriter creates a chart writer which lays out the packaged charts
+// in a flat directory, together with an index.yaml, so that the directory can be
+// served by any static HTTP server as a Helm chart repository.
+// The urls in index.yaml are relative to baseURL, or just the file names if baseURL is empty.
+func NewRepoChartWriter(toDir, baseURL string) *repochartwriter {
+	return &repochartwriter{
+		toDir:   toDir,
+		baseURL: baseURL,
+		index:   repo.NewIndexFile(),
+	}
+}
+
+// Dir returns the bundle directory the charts are written into
+func (cw *repochartwriter) Dir() string {
+	return cw.toDir
+}
+
+// Write packages the chart into the flat directory and adds it to the index,
+// or only tells what would be written if api.Config.Dryrun is set
+func (cw *repochartwriter) Write(ctx context.Context, chart *api.Chart, config api.Config) error {
+	if config.Dryrun {
+		utils.AddFile(config.TreeRoot, fmt.Sprintf("%s-%s.tgz", chart.C.Metadata.Name, chart.C.Metadata.Version))
+		return nil
+	}
+
+	if err := os.MkdirAll(cw.toDir, 0755); err != nil {
+		return fmt.Errorf("failed to mkdir %s: %w", cw.toDir, err)
+	}
+
+	saved, prov, err := saveChart(chart, cw.toDir, config)
+	if err != nil {
+		return fmt.Errorf("failed to write chart %s: %w", chart.C.Metadata.Name, err)
+	}
+	utils.AddManifestSource(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, chart.Source)
+	utils.AddManifestUpstreamVersion(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, chart.UpstreamVersion)
+
+	// the chart in the previous bundle is left out of the delta bundle
+	if base := utils.FindChart(config.Since, chart.C.Metadata.Name, chart.C.Metadata.Version); base != nil {
+		if err := os.Remove(saved); err != nil {
+			return fmt.Errorf("failed to leave out chart %s: %w", saved, err)
+		}
+		if prov != "" {
+			os.Remove(prov)
+		}
+		utils.AddManifestChart(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, "", base.Digest)
+		utils.AddRecord(config.Report, chart.C.Metadata.Name, "delta", fmt.Sprintf("%s: unchanged, left out", chart.C.Metadata.Version))
+		return nil
+	}
+
+	digest, err := provenance.DigestFile(saved)
+	if err != nil {
+		return fmt.Errorf("failed to digest chart %s: %w", saved, err)
+	}
+
+	fileName := filepath.Base(saved)
+	if !cw.index.Has(chart.C.Metadata.Name, chart.C.Metadata.Version) {
+		if err := cw.index.MustAdd(chart.C.Metadata, fileName, cw.baseURL, digest); err != nil {
+			return fmt.Errorf("failed to index chart %s: %w", fileName, err)
+		}
+	}
+
+	utils.AddManifestChart(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, fileName, "sha256:"+digest)
+	utils.AddFile(config.TreeRoot, fileName)
+	if prov != "" {
+		utils.AddManifestProv(config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version, fileName+".prov")
+		utils.AddFile(config.TreeRoot, fileName+".prov")
+	}
+
+	return nil
+}
+
+// Finish writes the index.yaml, merged with the existing one if there is, unless api.Config.Dryrun is set
+func (cw *repochartwriter) Finish(ctx context.Context, config api.Config) error {
+	if config.Dryrun {
+		utils.AddFile(config.TreeRoot, "index.yaml")
+		return nil
+	}
+
+	indexFile := filepath.Join(cw.toDir, "index.yaml")
+
+	if _, err := os.Stat(indexFile); err == nil {
+		existing, err := repo.LoadIndexFile(indexFile)
+		if err != nil {
+			return fmt.Errorf("failed to load existing index %s: %w", indexFile, err)
+		}
+		// the entries written in this run take precedence
+		cw.index.Merge(existing)
+	}
+
+	cw.index.SortEntries()
+
+	if err := cw.index.WriteFile(indexFile, 0644); err != nil {
+		return fmt.Errorf("failed to write index %s: %w", indexFile, err)
+	}
+	utils.AddFile(config.TreeRoot, "index.yaml")
+
+	return nil
+}
@@ -42,11 +42,11 @@ func (cw *stdoutchartwriter) Write(ctx context.Context, chart *api.Chart, config
 func (cw *stdoutchartwriter) writeChart(ctx context.Context, chart *api.Chart, config api.Config) {
 	fileName := fmt.Sprintf("%s-%s.tgz", chart.C.Metadata.Name, chart.C.Metadata.Version)
 
-	utils.AddChart(config.TreeRoot, chart.C.Metadata.Name, fileName)
+	utils.AddChart(config.TreeRoot, config.Layout, chart.C.Metadata.Name, chart.C.Metadata.Version, fileName)
 }
 
 func (cw *stdoutchartwriter) writeChartFiles(ctx context.Context, chart *api.Chart, config api.Config) {
-	utils.AddChartFiles(config.TreeRoot, chart.C.Metadata.Name, chart.C.Raw)
+	utils.AddChartFiles(config.TreeRoot, config.Layout, chart.C.Metadata.Name, chart.C.Metadata.Version, chart.C.Raw)
 }
 
 func (cw *stdoutchartwriter) Finish(ctx context.Context, config api.Config) error {
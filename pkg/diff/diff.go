@@ -0,0 +1,245 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// Change represents how a chart or an image is changed
+type Change string
+
+const (
+	ChangeAdded   Change = "added"
+	ChangeRemoved Change = "removed"
+	ChangeChanged Change = "changed"
+	// ChangeRetagged is for the image replaced by another tag of the same repository
+	ChangeRetagged Change = "retagged"
+	// ChangeMoved is for the image whose reference resolves to another digest
+	ChangeMoved Change = "moved"
+)
+
+// Diff represents the differences between two bundles, which is empty if they have the same charts and images
+type Diff struct {
+	Charts []*ChartDiff `json:"charts"`
+}
+
+// ChartDiff represents the differences of a chart, by its versions and the images of all its versions
+type ChartDiff struct {
+	Name         string       `json:"name"`
+	Change       Change       `json:"change"`
+	FromVersions []string     `json:"fromVersions,omitempty"`
+	ToVersions   []string     `json:"toVersions,omitempty"`
+	Images       []*ImageDiff `json:"images,omitempty"`
+}
+
+// ImageDiff represents the difference of an image of a chart
+type ImageDiff struct {
+	Change Change `json:"change"`
+	// Ref is the image, which is the one removed for ChangeRemoved, or the new one otherwise
+	Ref string `json:"ref"`
+	// FromRef is the image replaced, for ChangeRetagged
+	FromRef    string `json:"fromRef,omitempty"`
+	FromDigest string `json:"fromDigest,omitempty"`
+	ToDigest   string `json:"toDigest,omitempty"`
+}
+
+// Compare compares the charts and images of the bundles, by the chart names and the image references,
+// where the images of the same reference are compared by the digests it resolves to
+func Compare(from, to *api.Manifest) *Diff {
+	fromCharts, toCharts := chartsByName(from), chartsByName(to)
+
+	names := []string{}
+	for chartName := range fromCharts {
+		names = append(names, chartName)
+	}
+	for chartName := range toCharts {
+		if _, ok := fromCharts[chartName]; !ok {
+			names = append(names, chartName)
+		}
+	}
+	sort.Strings(names)
+
+	d := &Diff{Charts: []*ChartDiff{}}
+	for _, chartName := range names {
+		f, t := fromCharts[chartName], toCharts[chartName]
+		cd := &ChartDiff{Name: chartName, Change: ChangeChanged}
+		switch {
+		case len(f) == 0:
+			cd.Change = ChangeAdded
+		case len(t) == 0:
+			cd.Change = ChangeRemoved
+		}
+		cd.FromVersions = versions(f)
+		cd.ToVersions = versions(t)
+		cd.Images = compareImages(images(f), images(t))
+
+		if cd.Change == ChangeChanged && slices.Equal(cd.FromVersions, cd.ToVersions) && len(cd.Images) == 0 {
+			continue
+		}
+		d.Charts = append(d.Charts, cd)
+	}
+
+	return d
+}
+
+func chartsByName(m *api.Manifest) map[string][]*api.ManifestChart {
+	charts := map[string][]*api.ManifestChart{}
+	for _, c := range m.Charts {
+		charts[c.Name] = append(charts[c.Name], c)
+	}
+	return charts
+}
+
+// versions returns the versions of the chart as they're loaded, so that a chart re-packaged
+// with another version, e.g. 15.4.4+mirror by --rewrite-registry, is the same as the one upstream
+func versions(charts []*api.ManifestChart) []string {
+	versions := []string{}
+	for _, c := range charts {
+		if c.UpstreamVersion != "" {
+			versions = append(versions, c.UpstreamVersion)
+			continue
+		}
+		versions = append(versions, c.Version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// images returns the images of all the versions of the chart, by reference
+func images(charts []*api.ManifestChart) map[string]*api.ManifestImage {
+	images := map[string]*api.ManifestImage{}
+	for _, c := range charts {
+		for _, image := range c.Images {
+			images[image.Ref] = image
+		}
+	}
+	return images
+}
+
+func compareImages(from, to map[string]*api.ManifestImage) []*ImageDiff {
+	diffs := []*ImageDiff{}
+	removed := []string{}
+	for ref, image := range from {
+		if other, ok := to[ref]; ok {
+			if fromDigest, toDigest, ok := digests(image, other); ok && fromDigest != toDigest {
+				diffs = append(diffs, &ImageDiff{Change: ChangeMoved, Ref: ref, FromDigest: fromDigest, ToDigest: toDigest})
+			}
+			continue
+		}
+		removed = append(removed, ref)
+	}
+	sort.Strings(removed)
+
+	added := []string{}
+	for ref := range to {
+		if _, ok := from[ref]; !ok {
+			added = append(added, ref)
+		}
+	}
+	sort.Strings(added)
+
+	// the image removed and the one added of the same repository are paired as retagged
+	for _, ref := range removed {
+		i := slices.IndexFunc(added, func(other string) bool { return sameRepository(ref, other) })
+		if i < 0 {
+			diffs = append(diffs, &ImageDiff{Change: ChangeRemoved, Ref: ref, FromDigest: digest(from[ref])})
+			continue
+		}
+		diffs = append(diffs, &ImageDiff{Change: ChangeRetagged, Ref: added[i], FromRef: ref, FromDigest: digest(from[ref]), ToDigest: digest(to[added[i]])})
+		added = slices.Delete(added, i, i+1)
+	}
+	for _, ref := range added {
+		diffs = append(diffs, &ImageDiff{Change: ChangeAdded, Ref: ref, ToDigest: digest(to[ref])})
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Ref < diffs[j].Ref
+	})
+	return diffs
+}
+
+// digests returns the digests of the images to compare, which are the digests their references resolve to,
+// or the digests of what's saved if either is missing, e.g. in the bundles pulled before they're recorded
+func digests(from, to *api.ManifestImage) (string, string, bool) {
+	if from.RefDigest != "" && to.RefDigest != "" {
+		return from.RefDigest, to.RefDigest, true
+	}
+	if from.Digest != "" && to.Digest != "" {
+		return from.Digest, to.Digest, true
+	}
+	return "", "", false
+}
+
+func digest(image *api.ManifestImage) string {
+	if image.RefDigest != "" {
+		return image.RefDigest
+	}
+	return image.Digest
+}
+
+func sameRepository(a, b string) bool {
+	refA, errA := name.ParseReference(a)
+	refB, errB := name.ParseReference(b)
+	return errA == nil && errB == nil && refA.Context().Name() == refB.Context().Name()
+}
+
+// Print prints the differences in a human-readable way, like:
+//
+//	~ nginx 15.4.4 -> 15.5.0
+//	  + docker.io/bitnami/nginx-exporter:0.11.0
+//	  ~ docker.io/bitnami/nginx:1.25.4 <- docker.io/bitnami/nginx:1.25.3
+//	  ! docker.io/bitnami/os-shell:11 sha256:1c6a... -> sha256:9e2f...
+//	+ redis 18.6.1
+//	- apache 10.2.3
+func (d *Diff) Print(w io.Writer) {
+	if len(d.Charts) == 0 {
+		fmt.Fprintln(w, "no differences")
+		return
+	}
+
+	for _, cd := range d.Charts {
+		switch cd.Change {
+		case ChangeAdded:
+			fmt.Fprintf(w, "+ %s %s\n", cd.Name, strings.Join(cd.ToVersions, ", "))
+		case ChangeRemoved:
+			fmt.Fprintf(w, "- %s %s\n", cd.Name, strings.Join(cd.FromVersions, ", "))
+		default:
+			if slices.Equal(cd.FromVersions, cd.ToVersions) {
+				fmt.Fprintf(w, "~ %s %s\n", cd.Name, strings.Join(cd.ToVersions, ", "))
+			} else {
+				fmt.Fprintf(w, "~ %s %s -> %s\n", cd.Name, strings.Join(cd.FromVersions, ", "), strings.Join(cd.ToVersions, ", "))
+			}
+		}
+
+		for _, id := range cd.Images {
+			switch id.Change {
+			case ChangeAdded:
+				fmt.Fprintf(w, "  + %s\n", id.Ref)
+			case ChangeRemoved:
+				fmt.Fprintf(w, "  - %s\n", id.Ref)
+			case ChangeRetagged:
+				fmt.Fprintf(w, "  ~ %s <- %s\n", id.Ref, id.FromRef)
+			case ChangeMoved:
+				fmt.Fprintf(w, "  ! %s %s -> %s\n", id.Ref, id.FromDigest, id.ToDigest)
+			}
+		}
+	}
+}
+
+// PrintJSON prints the differences as JSON
+func (d *Diff) PrintJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
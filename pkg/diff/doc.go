@@ -0,0 +1,6 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package diff compares two bundles, or a bundle and what a bundle spec resolves to,
+// by their charts and images
+package diff
@@ -0,0 +1,104 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package diff
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/chartwriter"
+	"github.com/brightzheng100/helm-packager/pkg/imageswriter"
+	"github.com/brightzheng100/helm-packager/pkg/pipeline"
+	"github.com/brightzheng100/helm-packager/pkg/spec"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// Load loads the manifest of what to compare, which is a bundle directory, a bundle manifest,
+// or a bundle spec, which is resolved against the remote repositories and registries
+func Load(ctx context.Context, path string) (*api.Manifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return utils.ReadBundle(path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Kind string `yaml:"kind"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	switch header.Kind {
+	case spec.Kind:
+		bundle, err := spec.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		return Resolve(ctx, bundle)
+	case utils.ManifestKind:
+		return utils.LoadManifest(path)
+	default:
+		return nil, fmt.Errorf("neither a bundle nor a bundle spec: %s", path)
+	}
+}
+
+// Resolve resolves the bundle spec to the manifest of the bundle it would pull, without pulling it,
+// where the images are recorded with the digests their references resolve to
+func Resolve(ctx context.Context, bundle *spec.Bundle) (*api.Manifest, error) {
+	layout, err := utils.ParseLayout(bundle.Output.Layout)
+	if err != nil {
+		return nil, err
+	}
+
+	// the charts are downloaded into a temporary directory to extract their images
+	tmpDir, err := os.MkdirTemp("", "helm-packager-diff-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cls, err := bundle.Loaders(filepath.Join(tmpDir, "charts"))
+	if err != nil {
+		return nil, err
+	}
+	registryAuth, err := bundle.RegistryAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	pb := pipeline.NewBuilder(ctx).
+		WithChartWriter(chartwriter.NewManifestChartWriter()).
+		WithImagesWriter(imageswriter.NewManifestImagesWriter()).
+		ConfigureLayout(layout).
+		ConfigureValuesFiles(bundle.ValuesFiles()).
+		ConfigureImagePolicy(bundle.Images.Policy).
+		ConfigureImageFilter(bundle.ImageFilter()).
+		ConfigureExtraImages(bundle.ExtraImages()).
+		ConfigureHooks(bundle.Images.IncludeHooks, bundle.Images.DropTestHooks).
+		ConfigurePlatforms(bundle.Images.Platforms).
+		ConfigureRegistryAuth(registryAuth).
+		ConfigureQuiet(true)
+	for _, cl := range cls {
+		pb.WithChartLoader(cl)
+	}
+
+	cp := pb.Complete()
+	if err := cp.Process(); err != nil {
+		return nil, fmt.Errorf("could not resolve bundle spec: %w", err)
+	}
+
+	return cp.Manifest, nil
+}
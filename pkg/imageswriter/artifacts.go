@@ -0,0 +1,158 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package imageswriter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// cosignArtifacts are the kinds of the cosign artifacts, by the suffixes of their tags, i.e. sha256-<digest>.<suffix>
+var cosignArtifacts = []struct {
+	suffix string
+	kind   api.ArtifactKind
+}{
+	{"sig", api.ArtifactSignature},
+	{"att", api.ArtifactAttestation},
+	{"sbom", api.ArtifactSBOM},
+}
+
+// cosignTag returns the tag of the cosign artifact of the digest, e.g. sha256-<digest>.sig
+func cosignTag(digest, suffix string) string {
+	return fmt.Sprintf("%s.%s", strings.Replace(digest, ":", "-", 1), suffix)
+}
+
+// writeArtifacts discovers the artifacts of the subjects, i.e. the image and its platform images, in the repository,
+// by the cosign tag convention and as the OCI referrers, and saves them as an OCI image layout.
+// Nothing is saved if there is no artifact.
+func writeArtifacts(repo name.Repository, subjects []string, artifactsFile string, options []remote.Option) ([]*api.ManifestArtifact, error) {
+	os.RemoveAll(artifactsFile)
+
+	artifacts := []*api.ManifestArtifact{}
+	var p layout.Path
+	save := func(ref name.Reference, artifact *api.ManifestArtifact) error {
+		desc, err := remote.Get(ref, options...)
+		if err != nil {
+			return err
+		}
+		if p == "" {
+			if p, err = layout.Write(artifactsFile, empty.Index); err != nil {
+				return err
+			}
+		}
+
+		// the cosign artifacts are tagged in the layout, as they're pushed by tag
+		annotations := map[string]string{}
+		if artifact.Tag != "" {
+			annotations[specsv1.AnnotationRefName] = artifact.Tag
+		}
+		if err := appendArtifact(p, desc, annotations); err != nil {
+			return err
+		}
+
+		artifact.Digest = desc.Digest.String()
+		artifacts = append(artifacts, artifact)
+		return nil
+	}
+
+	for _, subject := range subjects {
+		for _, cosign := range cosignArtifacts {
+			tag := cosignTag(subject, cosign.suffix)
+			err := save(repo.Tag(tag), &api.ManifestArtifact{Kind: cosign.kind, Tag: tag, Subject: subject})
+			if err != nil && !isNotFound(err) {
+				return nil, fmt.Errorf("could not copy %s %s: %w", cosign.kind, tag, err)
+			}
+		}
+
+		// the registries without the referrers API are read by the fallback tag, i.e. sha256-<digest>,
+		// which is not found if there is no referrer
+		referrers, err := remote.Referrers(repo.Digest(subject), options...)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not list the referrers of %s: %w", subject, err)
+		}
+		for _, desc := range referrers.Manifests {
+			err := save(repo.Digest(desc.Digest.String()), &api.ManifestArtifact{Kind: api.ArtifactReferrer, Subject: subject, ArtifactType: desc.ArtifactType})
+			if err != nil {
+				return nil, fmt.Errorf("could not copy referrer %s: %w", desc.Digest, err)
+			}
+		}
+	}
+
+	return artifacts, nil
+}
+
+// appendArtifact appends the image, or the image index, of the artifact into the OCI image layout as it is
+func appendArtifact(p layout.Path, desc *remote.Descriptor, annotations map[string]string) error {
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		return p.AppendIndex(index, layout.WithAnnotations(annotations))
+	}
+	image, err := desc.Image()
+	if err != nil {
+		return err
+	}
+	return p.AppendImage(image, layout.WithAnnotations(annotations))
+}
+
+// artifactSubjects returns the digests the artifacts may be for, i.e. the image, or the image index and its platform images,
+// where the image index is by the digest upstream, as the one re-made for the platforms pulled is never signed
+func artifactSubjects(digest v1.Hash, artifact manifest) ([]string, error) {
+	subjects := []string{digest.String()}
+
+	index, ok := artifact.(v1.ImageIndex)
+	if !ok {
+		return subjects, nil
+	}
+	im, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range im.Manifests {
+		subjects = append(subjects, m.Digest.String())
+	}
+	return subjects, nil
+}
+
+// summarizeArtifacts summarizes the artifacts by kind, e.g. 1 signature, 2 referrers
+func summarizeArtifacts(artifacts []*api.ManifestArtifact) string {
+	counts := map[api.ArtifactKind]int{}
+	for _, artifact := range artifacts {
+		counts[artifact.Kind]++
+	}
+
+	summary := []string{}
+	for _, kind := range []api.ArtifactKind{api.ArtifactSignature, api.ArtifactAttestation, api.ArtifactSBOM, api.ArtifactReferrer} {
+		switch n := counts[kind]; {
+		case n == 1:
+			summary = append(summary, fmt.Sprintf("1 %s", kind))
+		case n > 1:
+			summary = append(summary, fmt.Sprintf("%d %ss", n, kind))
+		}
+	}
+	return strings.Join(summary, ", ")
+}
+
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,144 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package imageswriter
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// seedIndex pushes an image index of the linux/amd64 and linux/arm64 images, with the cosign artifacts
+// of the index and of every platform image, and returns the digests of the index and the platform images by platform
+func seedIndex(t *testing.T, host string) (string, map[string]string) {
+	t.Helper()
+
+	index := v1.ImageIndex(empty.Index)
+	platforms := map[string]string{}
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(256, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		platforms["linux/"+arch] = digest.String()
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+
+	ref, err := name.ParseReference(host + "/bitnami/nginx:1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := index.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts := []string{cosignTag(digest.String(), "sig"), cosignTag(digest.String(), "att")}
+	for _, platform := range platforms {
+		artifacts = append(artifacts, cosignTag(platform, "sig"))
+	}
+	for _, tag := range artifacts {
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref.Context().Tag(tag), img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return digest.String(), platforms
+}
+
+func TestWriteImagesArtifacts(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	upstream, platforms := seedIndex(t, host)
+	imgref := host + "/bitnami/nginx:1.25"
+
+	tests := []struct {
+		name      string
+		platforms []string
+		subjects  []string
+		remade    bool
+	}{
+		{"all platforms", nil, []string{upstream, platforms["linux/amd64"], platforms["linux/arm64"]}, false},
+		{"platform subset", []string{"linux/amd64"}, []string{upstream, platforms["linux/amd64"]}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := api.Config{
+				Platforms:     tt.platforms,
+				CopyArtifacts: true,
+				Layout:        api.LayoutVersioned,
+				TreeRoot:      utils.NewRootTree(),
+				Report:        utils.NewReport(),
+				Manifest:      &api.Manifest{},
+			}
+			iw := NewFileImagesWriter(t.TempDir())
+			if err := iw.writeImages(context.Background(), "nginx", "15.4.4", []string{imgref}, nil, config); err != nil {
+				t.Fatal(err)
+			}
+
+			image := config.Manifest.Charts[0].Images[0]
+			if image.RefDigest != upstream {
+				t.Errorf("ref digest = %s, want %s", image.RefDigest, upstream)
+			}
+			if remade := image.Digest != upstream; remade != tt.remade {
+				t.Errorf("image index re-made = %v, want %v", remade, tt.remade)
+			}
+
+			// the index is signed and attested upstream, and every platform image is signed
+			subjects := []string{}
+			for _, artifact := range image.Artifacts {
+				if !slices.Contains(subjects, artifact.Subject) {
+					subjects = append(subjects, artifact.Subject)
+				}
+			}
+			slices.Sort(subjects)
+			want := slices.Clone(tt.subjects)
+			slices.Sort(want)
+			if !slices.Equal(subjects, want) {
+				t.Errorf("artifact subjects = %v, want %v", subjects, want)
+			}
+			if n := len(image.Artifacts); n != len(tt.subjects)+1 {
+				t.Errorf("artifacts = %d, want %d", n, len(tt.subjects)+1)
+			}
+
+			warned := slices.ContainsFunc(config.Report.Records, func(r *api.Record) bool {
+				return r.Kind == "artifacts" && strings.Contains(r.Message, "warning")
+			})
+			if warned != tt.remade {
+				t.Errorf("warned = %v, want %v", warned, tt.remade)
+			}
+		})
+	}
+}
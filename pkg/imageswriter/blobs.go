@@ -0,0 +1,179 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package imageswriter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// imageBlobs returns the digests of the config and layers of the image
+func imageBlobs(image v1.Image) ([]string, error) {
+	config, err := image.ConfigName()
+	if err != nil {
+		return nil, err
+	}
+	blobs := []string{config.String()}
+
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, digest.String())
+	}
+	return blobs, nil
+}
+
+// indexBlobs returns the digests of the configs and layers of the images in the image index
+func indexBlobs(index v1.ImageIndex) ([]string, error) {
+	blobs := []string{}
+	err := walkIndex(index, func(image v1.Image) error {
+		imgBlobs, err := imageBlobs(image)
+		blobs = append(blobs, imgBlobs...)
+		return err
+	}, nil)
+	return blobs, err
+}
+
+// walkIndex walks the images and the image indexes in the image index, depth first,
+// where the manifests other than images and image indexes are skipped
+func walkIndex(index v1.ImageIndex, onImage func(v1.Image) error, onIndex func(v1.ImageIndex) error) error {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, desc := range manifest.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := walkIndex(child, onImage, onIndex); err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			child, err := index.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := onImage(child); err != nil {
+				return err
+			}
+		}
+	}
+	if onIndex != nil {
+		return onIndex(index)
+	}
+	return nil
+}
+
+// writeImageBlobs writes the manifest, config and layers of the image into the OCI image layout,
+// except the layers to omit, and returns the layers omitted
+func writeImageBlobs(p layout.Path, image v1.Image, omit map[string]string) ([]string, error) {
+	omitted := []string{}
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := omit[digest.String()]; ok {
+			omitted = append(omitted, digest.String())
+			continue
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return nil, err
+		}
+		err = p.WriteBlob(digest, rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not write layer %s: %w", digest, err)
+		}
+	}
+
+	config, err := image.ConfigName()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := image.RawConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.WriteBlob(config, io.NopCloser(bytes.NewReader(raw))); err != nil {
+		return nil, fmt.Errorf("could not write config %s: %w", config, err)
+	}
+
+	return omitted, writeManifest(p, image)
+}
+
+// writeIndexBlobs writes the image index, and the images in it, into the OCI image layout,
+// except the layers to omit, and returns the layers omitted
+func writeIndexBlobs(p layout.Path, index v1.ImageIndex, omit map[string]string) ([]string, error) {
+	omitted := []string{}
+	err := walkIndex(index, func(image v1.Image) error {
+		imgOmitted, err := writeImageBlobs(p, image, omit)
+		omitted = append(omitted, imgOmitted...)
+		return err
+	}, func(index v1.ImageIndex) error {
+		return writeManifest(p, index)
+	})
+	return omitted, err
+}
+
+// manifest is the image, or the image index, with its raw manifest
+type manifest interface {
+	Digest() (v1.Hash, error)
+	MediaType() (types.MediaType, error)
+	RawManifest() ([]byte, error)
+}
+
+// writeManifest writes the raw manifest of the image, or the image index, as a blob
+func writeManifest(p layout.Path, m manifest) error {
+	digest, err := m.Digest()
+	if err != nil {
+		return err
+	}
+	raw, err := m.RawManifest()
+	if err != nil {
+		return err
+	}
+	return p.WriteBlob(digest, io.NopCloser(bytes.NewReader(raw)))
+}
+
+// appendDescriptor appends the descriptor of the image, or the image index, to the index.json of the OCI image layout
+func appendDescriptor(p layout.Path, m manifest, annotations map[string]string) error {
+	digest, err := m.Digest()
+	if err != nil {
+		return err
+	}
+	mediaType, err := m.MediaType()
+	if err != nil {
+		return err
+	}
+	raw, err := m.RawManifest()
+	if err != nil {
+		return err
+	}
+	return p.AppendDescriptor(v1.Descriptor{
+		MediaType:   mediaType,
+		Size:        int64(len(raw)),
+		Digest:      digest,
+		Annotations: annotations,
+	})
+}
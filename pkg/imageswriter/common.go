@@ -12,27 +12,127 @@ import (
 	"strings"
 
 	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
 	"github.com/mikefarah/yq/v4/pkg/yqlib"
 	"gopkg.in/op/go-logging.v1"
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
 )
 
-func Templatize(ctx context.Context, chart *api.Chart) (string, error) {
+// ChartImages templatizes the chart, extracts the images from it, checks them against the image policy if any,
+// and filters them by the image filter, where the skipped images, and the violations to warn of, are recorded in the report
+func ChartImages(ctx context.Context, chart *api.Chart, config api.Config) ([]string, error) {
+	chartName := chart.C.Metadata.Name
+
+	manifest, err := Templatize(ctx, chart, config)
+	if err != nil {
+		return nil, &api.ChartError{Chart: chartName, Stage: api.StageRender, Err: fmt.Errorf("could not templatize Helm chart: %w", err)}
+	}
+
+	images, err := ExtractImages(ctx, manifest)
+	if err != nil {
+		return nil, &api.ChartError{Chart: chartName, Stage: api.StageRender, Err: fmt.Errorf("could not extract images from Helm chart: %w", err)}
+	}
+
+	if err := enforceImagePolicy(chartName, manifest, images, config); err != nil {
+		return nil, err
+	}
+
+	images, skipped, err := FilterImages(chartName, images, config.ImageFilter)
+	if err != nil {
+		return nil, &api.ChartError{Chart: chartName, Stage: api.StageRender, Err: fmt.Errorf("could not filter images of Helm chart: %w", err)}
+	}
+	for _, s := range skipped {
+		utils.AddRecord(config.Report, chartName, "skip", s)
+	}
+
+	return images, nil
+}
+
+// ManualImages returns the extra images of the chart in api.Config.ExtraImages,
+// except the ones extracted from the chart already, and records them in the report.
+// They're checked against the image policy if any, as the images extracted are.
+func ManualImages(chartName string, images []string, config api.Config) ([]string, error) {
+	manual := []string{}
+	for _, imgref := range config.ExtraImages[chartName] {
+		if slices.Contains(images, imgref) || slices.Contains(manual, imgref) {
+			continue
+		}
+		manual = append(manual, imgref)
+		utils.AddRecord(config.Report, chartName, "manual", fmt.Sprintf("%s: added manually", imgref))
+	}
+
+	// the manual images aren't in the templatized chart, so there is no resource introducing them
+	if err := enforceImagePolicy(chartName, "", manual, config); err != nil {
+		return nil, err
+	}
+	return manual, nil
+}
+
+// enforceImagePolicy checks the images of the chart against the image policy if any,
+// and fails on the violations, unless the policy only warns of them, where they're recorded in the report
+func enforceImagePolicy(chartName, manifest string, images []string, config api.Config) error {
+	if config.ImagePolicy == nil {
+		return nil
+	}
+
+	violations, err := CheckImagePolicy(manifest, images, config.ImagePolicy)
+	if err != nil {
+		return &api.ChartError{Chart: chartName, Stage: api.StagePolicy, Err: fmt.Errorf("could not check images against the image policy: %w", err)}
+	}
+	if len(violations) > 0 && config.ImagePolicy.Action != api.PolicyWarn {
+		return &api.ChartError{Chart: chartName, Stage: api.StagePolicy, Err: fmt.Errorf("%d image(s) violating the image policy:\n  %s", len(violations), strings.Join(violations, "\n  "))}
+	}
+	for _, v := range violations {
+		utils.AddRecord(config.Report, chartName, "policy", v)
+	}
+	return nil
+}
+
+// Templatize renders the chart, with the values files of the chart in api.Config.ValuesFiles if there are,
+// including the manifests of its hooks if api.Config.IncludeHooks is set, except the test hooks if api.Config.DropTestHooks is set
+func Templatize(ctx context.Context, chart *api.Chart, config api.Config) (string, error) {
+	c := chart.C
+	if chart.Origin != nil {
+		c = chart.Origin
+	}
+
 	// Create chart renderer.
 	client := action.NewInstall(&action.Configuration{})
 	client.ClientOnly = true
 	client.DryRun = true
-	client.ReleaseName = chart.C.Name()
+	client.ReleaseName = c.Name()
 	client.IncludeCRDs = false
 	client.Namespace = "fake-namespace-name"
 
+	valueOpts := &values.Options{ValueFiles: config.ValuesFiles[c.Name()]}
+	vals, err := valueOpts.MergeValues(getter.All(cli.New()))
+	if err != nil {
+		return "", fmt.Errorf("could not read values files: %w", err)
+	}
+
 	// Render chart.
-	rel, err := client.Run(chart.C, nil)
+	rel, err := client.Run(c, vals)
 	if err != nil {
 		return "", fmt.Errorf("could not render helm chart correctly: %w", err)
 	}
 
-	return rel.Manifest, nil
+	var manifest strings.Builder
+	manifest.WriteString(rel.Manifest)
+	for _, hook := range rel.Hooks {
+		if !config.IncludeHooks {
+			break
+		}
+		if config.DropTestHooks && slices.Contains(hook.Events, release.HookTest) {
+			continue
+		}
+		fmt.Fprintf(&manifest, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+	}
+
+	return manifest.String(), nil
 }
 
 // extractImages extracts the images from the templatized Helm chart
@@ -46,7 +146,7 @@ func ExtractImages(ctx context.Context, yaml string) ([]string, error) {
 	images := []string{}
 
 	// yqlib logging
-	backendLeveled := logging.AddModuleLevel(logging.NewLogBackend(os.Stdout, "", 0))
+	backendLeveled := logging.AddModuleLevel(logging.NewLogBackend(os.Stderr, "", 0))
 	backendLeveled.SetLevel(logging.WARNING, "")
 	yqlib.GetLogger().SetBackend(backendLeveled)
 
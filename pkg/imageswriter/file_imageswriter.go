@@ -5,69 +5,441 @@ package imageswriter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/brightzheng100/helm-packager/pkg/api"
 	"github.com/brightzheng100/helm-packager/pkg/utils"
-	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type fileimageswriter struct {
 	toDir string
+
+	// saved keeps the images saved already, by image reference,
+	// so that the images shared by multiple charts are pulled only once
+	saved map[string]*savedImage
+
+	// baseBlobs are the blobs of the previous bundle, which are left out of the delta bundle
+	baseBlobs map[string]string
 }
 
 func NewFileImagesWriter(toDir string) *fileimageswriter {
 	return &fileimageswriter{
 		toDir: toDir,
+		saved: map[string]*savedImage{},
 	}
 }
 
 func (iw *fileimageswriter) Write(ctx context.Context, chart *api.Chart, config api.Config) error {
-	// Templatize the chart
-	manifest, err := Templatize(ctx, chart)
+	images, err := ChartImages(ctx, chart, config)
 	if err != nil {
-		return fmt.Errorf("could not templatize Helm chart: %w", err)
+		return err
 	}
 
-	images, err := ExtractImages(ctx, manifest)
+	manual, err := ManualImages(chart.C.Metadata.Name, images, config)
 	if err != nil {
-		return fmt.Errorf("could not extract images from Helm chart: %w", err)
+		return err
 	}
 
-	if err = iw.writeImages(ctx, chart.C.Metadata.Name, images, config); err != nil {
-		return fmt.Errorf("could not write images from Helm chart: %w", err)
-	}
-
-	return nil
+	return iw.writeImages(ctx, chart.C.Metadata.Name, chart.C.Metadata.Version, images, manual, config)
 }
 
-func (iw *fileimageswriter) writeImages(ctx context.Context, chartName string, images []string, config api.Config) error {
-	utils.AddChartImages(config.TreeRoot, chartName, images)
+func (iw *fileimageswriter) writeImages(ctx context.Context, chartName, chartVersion string, images, manual []string, config api.Config) error {
+	utils.AddChartImages(config.TreeRoot, config.Layout, chartName, chartVersion, images, utils.IsOCILayout(config))
+	utils.AddChartImagesFrom(config.TreeRoot, config.Layout, chartName, chartVersion, manual, utils.IsOCILayout(config), "manual")
 
-	imgDir := fmt.Sprintf("%s/%s/%s/", iw.toDir, chartName, "images")
+	platforms, err := parsePlatforms(config.Platforms)
+	if err != nil {
+		return &api.ChartError{Chart: chartName, Stage: api.StagePull, Err: err}
+	}
+
+	imgDir := filepath.Join(utils.ChartDir(iw.toDir, config.Layout, chartName, chartVersion), "images")
 	if err := os.MkdirAll(imgDir, 0755); err != nil {
-		return fmt.Errorf("failed to mkdir %s: ", imgDir)
+		return fmt.Errorf("failed to mkdir %s: %w", imgDir, err)
+	}
+
+	// the manifests and blobs are retried by the transport, with the retries recorded against the chart
+	options := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(utils.Keychain(config.RegistryAuth)),
+		remote.WithTransport(utils.NewRetryTransport(remote.DefaultTransport, config.Retry, config.Report, chartName)),
+	}
+
+	// the blobs of the previous bundle are left out of the delta bundle
+	if config.Since != nil && iw.baseBlobs == nil {
+		iw.baseBlobs = utils.Blobs(config.Since)
 	}
 
 	// docker.io/bitnami/apache:2.4.58-debian-11-r1
-	for _, imgref := range images {
-		image, err := crane.Pull(imgref)
+	failures := []error{}
+	for _, imgref := range append(images, manual...) {
+		imgFile := filepath.Join(imgDir, utils.ImageFileName(imgref, utils.IsOCILayout(config)))
+		saved, err := iw.writeImage(imgref, imgFile, platforms, config, options)
 		if err != nil {
-			return err
+			err.Chart = chartName
+			if !config.ContinueOnError {
+				return err
+			}
+			failures = append(failures, err)
+			continue
+		}
+		if len(saved.platforms) > 0 && !saved.reused {
+			utils.AddRecord(config.Report, chartName, "platforms", fmt.Sprintf("%s: %s", imgref, strings.Join(saved.platforms, ", ")))
+		}
+		// the artifacts of the image index upstream don't hold for the one re-made for the platforms pulled
+		if config.CopyArtifacts && saved.refDigest != saved.digest && !saved.reused {
+			utils.AddRecord(config.Report, chartName, "artifacts", fmt.Sprintf("%s: warning, the image index is re-made for the platforms pulled, as %s, so it's verified only by the signatures of its platform images", imgref, saved.digest))
+		}
+
+		image := &api.ManifestImage{Ref: imgref, RefDigest: saved.refDigest, Digest: saved.digest, Platforms: saved.platforms, Blobs: saved.blobs, Omitted: saved.omitted}
+		if slices.Contains(manual, imgref) {
+			image.Source = "manual"
+		}
+		if config.PinDigests {
+			image.Pinned = utils.PinnedRef(imgref, saved.refDigest)
+			utils.AddRecord(config.Report, chartName, "pin", fmt.Sprintf("%s: %s", imgref, saved.refDigest))
+		}
+		switch {
+		case saved.file == "":
+			utils.AddRecord(config.Report, chartName, "delta", fmt.Sprintf("%s: unchanged, left out", imgref))
+		case len(saved.omitted) > 0:
+			utils.AddRecord(config.Report, chartName, "delta", fmt.Sprintf("%s: %d of %d blobs left out", imgref, len(saved.omitted), len(saved.blobs)))
+		}
+		if saved.file != "" {
+			if rel, err := filepath.Rel(iw.toDir, imgFile); err == nil {
+				image.Path = rel
+			}
+			for _, blob := range saved.omitted {
+				utils.AddManifestOrigin(config.Manifest, blob, iw.baseBlobs[blob])
+			}
+		}
+		// the artifacts are copied even if the image is left out of the delta bundle, as they may be added since
+		if saved.artifactsFile != "" {
+			if rel, err := filepath.Rel(iw.toDir, saved.artifactsFile); err == nil {
+				image.ArtifactsPath = filepath.ToSlash(rel)
+			}
+			image.Artifacts = saved.artifacts
+			utils.AddChartImageArtifacts(config.TreeRoot, config.Layout, chartName, chartVersion, imgref)
+			utils.AddRecord(config.Report, chartName, "artifacts", fmt.Sprintf("%s: %s", imgref, summarizeArtifacts(saved.artifacts)))
+		}
+		utils.AddManifestImage(config.Manifest, chartName, chartVersion, image)
+	}
+
+	return errors.Join(failures...)
+}
+
+// savedImage represents the image saved
+type savedImage struct {
+	file      string   // the file saved, or empty if the image is left out of the delta bundle
+	refDigest string   // the digest the image reference resolves to
+	digest    string   // the digest of the image manifest, or the image index, saved
+	blobs     []string // the configs and layers of the image
+	omitted   []string // the blobs left out of the delta bundle
+	platforms []string // the platforms captured
+	reused    bool     // whether the image is saved for another chart already
+
+	subjects      []string                // the digests of the image, and its platform images, which the artifacts may be for
+	artifactsFile string                  // the artifacts saved, or empty if there is none
+	artifacts     []*api.ManifestArtifact // the artifacts of the image, e.g. its cosign signatures
+}
+
+// writeImage pulls and saves the image, as a tarball of a single platform,
+// or as an OCI image layout with multiple platforms, or in a delta bundle.
+func (iw *fileimageswriter) writeImage(imgref, imgFile string, platforms []*v1.Platform, config api.Config, options []remote.Option) (*savedImage, *api.ImageError) {
+	artifactsFile := filepath.Join(filepath.Dir(imgFile), utils.ArtifactsFileName(imgref))
+	if saved, ok := iw.saved[imgref]; ok {
+		// the saved file may have been archived and removed already, where the archive links to it instead
+		if _, err := os.Stat(saved.file); err == nil {
+			if err := linkOrCopy(saved.file, imgFile); err != nil {
+				return nil, &api.ImageError{Image: imgref, Stage: api.StageSave, Err: fmt.Errorf("failed to reuse saved image %s: %w", saved.file, err)}
+			}
+		}
+		if _, err := os.Stat(saved.artifactsFile); err == nil {
+			if err := linkOrCopy(saved.artifactsFile, artifactsFile); err != nil {
+				return nil, &api.ImageError{Image: imgref, Stage: api.StageSave, Err: fmt.Errorf("failed to reuse saved artifacts %s: %w", saved.artifactsFile, err)}
+			}
+		}
+		reused := *saved
+		reused.reused = true
+		if reused.file != "" {
+			reused.file = imgFile
+		}
+		if reused.artifactsFile != "" {
+			reused.artifactsFile = artifactsFile
+		}
+		return &reused, nil
+	}
+
+	ref, err := name.ParseReference(imgref)
+	if err != nil {
+		return nil, &api.ImageError{Image: imgref, Stage: api.StagePull, Err: err}
+	}
+
+	// the image is pulled by the digest its reference resolves to, if the digests are pinned,
+	// which the charts may have been rewritten with already
+	source := ref
+	if config.PinDigests {
+		digest, err := utils.ResolveDigest(imgref, config.Pins, options)
+		if err != nil {
+			return nil, &api.ImageError{Image: imgref, Stage: api.StagePull, Err: fmt.Errorf("could not resolve the digest: %w", err)}
 		}
+		source = ref.Context().Digest(digest)
+	}
 
-		nametag := strings.Split(filepath.Base(imgref), ":")
+	var saved *savedImage
+	var ierr *api.ImageError
+	if utils.IsOCILayout(config) {
+		saved, ierr = iw.writeLayout(ref, source, imgFile, platforms, config.Since, options)
+	} else {
+		saved, ierr = iw.writeTarball(ref, imgFile, platforms, options)
+	}
+	if ierr != nil {
+		return nil, ierr
+	}
 
-		err = crane.Save(image, imgref, fmt.Sprintf("%s/%s-%s.tar", imgDir, nametag[0], nametag[1]))
+	if config.CopyArtifacts {
+		artifacts, err := writeArtifacts(ref.Context(), saved.subjects, artifactsFile, options)
+		if err != nil {
+			return nil, &api.ImageError{Image: imgref, Stage: api.StagePull, Err: err}
+		}
+		if len(artifacts) > 0 {
+			saved.artifactsFile, saved.artifacts = artifactsFile, artifacts
+		}
+	}
+	iw.saved[imgref] = saved
+
+	return saved, nil
+}
+
+func (iw *fileimageswriter) writeTarball(ref name.Reference, imgFile string, platforms []*v1.Platform, options []remote.Option) (*savedImage, *api.ImageError) {
+	if len(platforms) == 1 {
+		options = append(options, remote.WithPlatform(*platforms[0]))
+	}
+
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+	}
+	image, err := desc.Image()
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+	}
+
+	err = tarball.WriteToFile(imgFile, ref, image)
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StageSave, Err: err}
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+	}
+	blobs, err := imageBlobs(image)
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+	}
+
+	return &savedImage{file: imgFile, refDigest: desc.Digest.String(), digest: digest.String(), blobs: blobs, platforms: imagePlatforms(image)}, nil
+}
+
+// writeLayout saves the image, pulled from the source, e.g. the digest the reference is pinned to, as an OCI image layout.
+// For a delta bundle, which is relative to the manifest of the previous bundle,
+// the image is left out if it's in the previous bundle, or the blobs in the previous bundle are left out.
+func (iw *fileimageswriter) writeLayout(ref, source name.Reference, imgFile string, platforms []*v1.Platform, since *api.Manifest, options []remote.Option) (*savedImage, *api.ImageError) {
+	desc, err := remote.Get(source, options...)
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+	}
+
+	// a single platform image is kept as it is
+	var artifact manifest
+	var blobs, captured []string
+	if !desc.MediaType.IsIndex() {
+		image, err := desc.Image()
+		if err != nil {
+			return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+		}
+		if blobs, err = imageBlobs(image); err != nil {
+			return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+		}
+		artifact = image
+		captured = imagePlatforms(image)
+	} else {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+		}
+
+		// all platforms are kept if there is no specific platform
+		if len(platforms) > 0 {
+			index = mutate.RemoveManifests(index, func(d v1.Descriptor) bool {
+				return !matchPlatforms(d.Platform, platforms)
+			})
+		}
+
+		im, err := index.IndexManifest()
+		if err != nil {
+			return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+		}
+		if len(im.Manifests) == 0 {
+			return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: fmt.Errorf("no manifest for the platforms")}
+		}
+		if blobs, err = indexBlobs(index); err != nil {
+			return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+		}
+		artifact = index
+
+		captured = []string{}
+		for _, m := range im.Manifests {
+			if m.Platform != nil {
+				captured = append(captured, m.Platform.String())
+			}
+		}
+	}
+
+	digest, err := artifact.Digest()
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+	}
+	subjects, err := artifactSubjects(desc.Digest, artifact)
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StagePull, Err: err}
+	}
+	saved := &savedImage{file: imgFile, refDigest: desc.Digest.String(), digest: digest.String(), blobs: blobs, platforms: captured, subjects: subjects}
+
+	if utils.HasImage(since, saved.digest) {
+		saved.file = ""
+		saved.omitted = blobs
+		return saved, nil
+	}
+
+	os.RemoveAll(imgFile)
+	p, err := layout.Write(imgFile, empty.Index)
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StageSave, Err: err}
+	}
+	annotations := map[string]string{specsv1.AnnotationRefName: ref.String()}
+
+	switch a := artifact.(type) {
+	case v1.Image:
+		if since == nil {
+			err = p.AppendImage(a, layout.WithAnnotations(annotations))
+		} else {
+			saved.omitted, err = writeImageBlobs(p, a, iw.baseBlobs)
+		}
+	case v1.ImageIndex:
+		if since == nil {
+			err = p.AppendIndex(a, layout.WithAnnotations(annotations))
+		} else {
+			saved.omitted, err = writeIndexBlobs(p, a, iw.baseBlobs)
+		}
+	}
+	if err == nil && since != nil {
+		err = appendDescriptor(p, artifact, annotations)
+	}
+	if err != nil {
+		return nil, &api.ImageError{Image: ref.String(), Stage: api.StageSave, Err: err}
+	}
+
+	return saved, nil
+}
+
+// parsePlatforms parses the platforms, where "all" means no specific platform
+func parsePlatforms(platforms []string) ([]*v1.Platform, error) {
+	parsed := []*v1.Platform{}
+	for _, platform := range platforms {
+		if platform == utils.AllPlatforms {
+			return nil, nil
+		}
+		p, err := v1.ParsePlatform(platform)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform %s: %w", platform, err)
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// matchPlatforms tells whether the platform matches any of the platforms by OS, architecture and variant if any
+func matchPlatforms(platform *v1.Platform, platforms []*v1.Platform) bool {
+	if platform == nil {
+		return false
+	}
+	for _, p := range platforms {
+		if p.OS == platform.OS && p.Architecture == platform.Architecture && (p.Variant == "" || p.Variant == platform.Variant) {
+			return true
+		}
+	}
+	return false
+}
+
+func imagePlatforms(image v1.Image) []string {
+	config, err := image.ConfigFile()
+	if err != nil || config.Platform() == nil {
+		return nil
+	}
+	return []string{config.Platform().String()}
+}
+
+// linkOrCopy hard links the file, or the files in the directory, or copies them if they can't be linked
+func linkOrCopy(src, dst string) error {
+	if src == dst {
+		return nil
+	}
+	os.RemoveAll(dst)
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
 		if err != nil {
 			return err
 		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.Link(path, target); err == nil {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
 	}
+	defer in.Close()
 
-	return nil
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
 
 func (iw *fileimageswriter) Finish(ctx context.Context, config api.Config) error {
@@ -0,0 +1,35 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package imageswriter
+
+import (
+	"fmt"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// regexPrefix is the prefix of the regex patterns, while the others are glob patterns
+const regexPrefix = "regex:"
+
+// FilterImages filters the images of the chart by the global and the chart's include and exclude patterns.
+// An image is kept if it matches any include pattern, or there is no include pattern,
+// and it matches no exclude pattern.
+// It returns the images kept, and the images skipped with the reasons.
+func FilterImages(chartName string, images []string, filter api.ImageFilter) ([]string, []string, error) {
+	kept := []string{}
+	skipped := []string{}
+	for _, imgref := range images {
+		ok, reason, err := filter.Match(chartName, imgref)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			skipped = append(skipped, fmt.Sprintf("%s: %s", imgref, reason))
+			continue
+		}
+		kept = append(kept, imgref)
+	}
+
+	return kept, skipped, nil
+}
@@ -0,0 +1,98 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package imageswriter
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+type manifestimageswriter struct {
+	// resolved keeps the digests resolved already, by image reference,
+	// so that the images shared by multiple charts are resolved only once
+	resolved map[string]string
+}
+
+// NewManifestImagesWriter creates an images writer which records the images, with the digests
+// their references resolve to, into the bundle manifest only, without pulling them,
+// e.g. to tell what a bundle spec resolves to
+func NewManifestImagesWriter() *manifestimageswriter {
+	return &manifestimageswriter{
+		resolved: map[string]string{},
+	}
+}
+
+func (iw *manifestimageswriter) Write(ctx context.Context, chart *api.Chart, config api.Config) error {
+	images, err := ChartImages(ctx, chart, config)
+	if err != nil {
+		return err
+	}
+
+	manual, err := ManualImages(chart.C.Metadata.Name, images, config)
+	if err != nil {
+		return err
+	}
+
+	return iw.writeImages(ctx, chart.C.Metadata.Name, chart.C.Metadata.Version, images, manual, config)
+}
+
+func (iw *manifestimageswriter) writeImages(ctx context.Context, chartName, chartVersion string, images, manual []string, config api.Config) error {
+	// the manifests are retried by the transport, with the retries recorded against the chart
+	options := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(utils.Keychain(config.RegistryAuth)),
+		remote.WithTransport(utils.NewRetryTransport(remote.DefaultTransport, config.Retry, config.Report, chartName)),
+	}
+
+	failures := []error{}
+	for _, imgref := range append(images, manual...) {
+		refDigest, err := iw.resolve(imgref, options)
+		if err != nil {
+			err := &api.ImageError{Chart: chartName, Image: imgref, Stage: api.StagePull, Err: err}
+			if !config.ContinueOnError {
+				return err
+			}
+			failures = append(failures, err)
+			continue
+		}
+
+		image := &api.ManifestImage{Ref: imgref, RefDigest: refDigest}
+		if slices.Contains(manual, imgref) {
+			image.Source = "manual"
+		}
+		utils.AddManifestImage(config.Manifest, chartName, chartVersion, image)
+	}
+
+	return errors.Join(failures...)
+}
+
+// resolve resolves the digest the image reference points to, by the HEAD request of its manifest
+func (iw *manifestimageswriter) resolve(imgref string, options []remote.Option) (string, error) {
+	if refDigest, ok := iw.resolved[imgref]; ok {
+		return refDigest, nil
+	}
+
+	ref, err := name.ParseReference(imgref)
+	if err != nil {
+		return "", err
+	}
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		return "", err
+	}
+	iw.resolved[imgref] = desc.Digest.String()
+
+	return iw.resolved[imgref], nil
+}
+
+func (iw *manifestimageswriter) Finish(ctx context.Context, config api.Config) error {
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package imageswriter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"gopkg.in/yaml.v3"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// CheckImagePolicy checks the images, e.g. extracted from the templatized chart, against the image policy,
+// and returns the violations, each with the image, the resources which introduce it, and the reasons
+func CheckImagePolicy(manifest string, images []string, policy *api.ImagePolicy) ([]string, error) {
+	sources := imageSources(manifest)
+
+	violations := []string{}
+	for _, imgref := range images {
+		reasons, err := checkImage(imgref, policy)
+		if err != nil {
+			return nil, err
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+
+		violation := imgref
+		if resources := sources[imgref]; len(resources) > 0 {
+			violation = fmt.Sprintf("%s in %s", imgref, strings.Join(resources, ", "))
+		}
+		violations = append(violations, fmt.Sprintf("%s: %s", violation, strings.Join(reasons, ", ")))
+	}
+	return violations, nil
+}
+
+// checkImage returns the reasons why the image violates the image policy, if any
+func checkImage(imgref string, policy *api.ImagePolicy) ([]string, error) {
+	repository, tag, digest, err := imageParts(imgref)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+
+	reasons := []string{}
+	if len(policy.AllowedRegistries) > 0 && !isAllowedRegistry(repository, policy.AllowedRegistries) {
+		reasons = append(reasons, fmt.Sprintf("repository %s not from the allowed registries", repository))
+	}
+	pattern, err := api.MatchAny(policy.DeniedRepositories, repository)
+	if err != nil {
+		return nil, err
+	}
+	if pattern != "" {
+		reasons = append(reasons, fmt.Sprintf("repository %s denied by %s", repository, pattern))
+	}
+	if policy.RequireDigest && digest == "" {
+		reasons = append(reasons, "not pinned by digest")
+	}
+	if tag != "" {
+		pattern, err := api.MatchAny(policy.DisallowedTags, tag)
+		if err != nil {
+			return nil, err
+		}
+		if pattern != "" {
+			reasons = append(reasons, fmt.Sprintf("tag %s disallowed by %s", tag, pattern))
+		}
+	}
+	return reasons, nil
+}
+
+// imageParts splits the image reference into its repository, with the registry normalized, e.g. docker.io/library/nginx,
+// its tag and its digest, where the tag is latest if there is neither tag nor digest
+func imageParts(imgref string) (string, string, string, error) {
+	ref, digest, _ := strings.Cut(imgref, "@")
+	t, err := name.NewTag(ref)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid image reference: %w", err)
+	}
+	repository := fmt.Sprintf("%s/%s", utils.NormalizeRegistry(t.RegistryStr()), t.RepositoryStr())
+
+	tag := ""
+	if strings.Contains(ref[strings.LastIndex(ref, "/")+1:], ":") {
+		tag = t.TagStr()
+	} else if digest == "" {
+		tag = name.DefaultTag
+	}
+	return repository, tag, digest, nil
+}
+
+// isAllowedRegistry tells whether the repository is from any of the registries, which may come with the namespaces,
+// e.g. docker.io/bitnami allows docker.io/bitnami/nginx, but not docker.io/bitnamicharts/nginx
+func isAllowedRegistry(repository string, registries []string) bool {
+	for _, registry := range registries {
+		host, namespace, _ := strings.Cut(strings.TrimSuffix(registry, "/"), "/")
+		prefix := utils.NormalizeRegistry(host)
+		if namespace != "" {
+			prefix = prefix + "/" + namespace
+		}
+		if repository == prefix || strings.HasPrefix(repository, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// imageSources maps the images to the resources in the templatized chart which introduce them,
+// e.g. Deployment/nginx (nginx/templates/deployment.yaml), as the images are extracted by yq
+func imageSources(manifest string) map[string][]string {
+	sources := map[string][]string{}
+
+	docs := [][]string{{}}
+	for _, line := range strings.Split(manifest, "\n") {
+		if strings.TrimSpace(line) == "---" {
+			docs = append(docs, []string{})
+			continue
+		}
+		docs[len(docs)-1] = append(docs[len(docs)-1], line)
+	}
+
+	for _, lines := range docs {
+		source := ""
+		for _, line := range lines {
+			if s, ok := strings.CutPrefix(line, "# Source: "); ok {
+				source = strings.TrimSpace(s)
+				break
+			}
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &doc); err != nil || len(doc.Content) == 0 {
+			continue
+		}
+		root := doc.Content[0]
+
+		resource := fmt.Sprintf("%s/%s", mappingValue(root, "kind"), mappingValue(mappingNode(root, "metadata"), "name"))
+		if source != "" {
+			resource = fmt.Sprintf("%s (%s)", resource, source)
+		}
+		walkImages(root, func(imgref string) {
+			if !slices.Contains(sources[imgref], resource) {
+				sources[imgref] = append(sources[imgref], resource)
+			}
+		})
+	}
+	return sources
+}
+
+// walkImages calls fn with the scalar values of the image fields at any level of the node
+func walkImages(node *yaml.Node, fn func(string)) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key, value := node.Content[i], node.Content[i+1]; key.Value == "image" && value.Kind == yaml.ScalarNode {
+				fn(value.Value)
+			}
+		}
+	}
+	for _, child := range node.Content {
+		walkImages(child, fn)
+	}
+}
+
+// mappingNode returns the value of the key in the mapping node, or nil if there is none
+func mappingNode(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the scalar value of the key in the mapping node, or empty if there is none
+func mappingValue(node *yaml.Node, key string) string {
+	if value := mappingNode(node, key); value != nil && value.Kind == yaml.ScalarNode {
+		return value.Value
+	}
+	return ""
+}
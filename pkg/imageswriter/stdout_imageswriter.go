@@ -5,7 +5,6 @@ package imageswriter
 
 import (
 	"context"
-	"fmt"
 	"regexp"
 
 	"github.com/brightzheng100/helm-packager/pkg/api"
@@ -23,26 +22,22 @@ func NewStdoutImagesWriter() *stdoutimageswriter {
 }
 
 func (iw *stdoutimageswriter) Write(ctx context.Context, chart *api.Chart, config api.Config) error {
-	// Templatize the chart
-	manifest, err := Templatize(ctx, chart)
+	images, err := ChartImages(ctx, chart, config)
 	if err != nil {
-		return fmt.Errorf("could not templatize Helm chart: %w", err)
+		return err
 	}
 
-	images, err := ExtractImages(ctx, manifest)
+	manual, err := ManualImages(chart.C.Metadata.Name, images, config)
 	if err != nil {
-		return fmt.Errorf("could not extract images from Helm chart: %w", err)
+		return err
 	}
 
-	if err = iw.writeImages(ctx, chart.C.Metadata.Name, images, config); err != nil {
-		return fmt.Errorf("could not write images from Helm chart: %w", err)
-	}
-
-	return nil
+	return iw.writeImages(ctx, chart.C.Metadata.Name, chart.C.Metadata.Version, images, manual, config)
 }
 
-func (iw *stdoutimageswriter) writeImages(ctx context.Context, chartName string, images []string, config api.Config) error {
-	utils.AddChartImages(config.TreeRoot, chartName, images)
+func (iw *stdoutimageswriter) writeImages(ctx context.Context, chartName, chartVersion string, images, manual []string, config api.Config) error {
+	utils.AddChartImages(config.TreeRoot, config.Layout, chartName, chartVersion, images, utils.IsOCILayout(config))
+	utils.AddChartImagesFrom(config.TreeRoot, config.Layout, chartName, chartVersion, manual, utils.IsOCILayout(config), "manual")
 	return nil
 }
 
@@ -6,7 +6,12 @@ package pipeline
 import (
 	"context"
 
+	"helm.sh/helm/v3/pkg/provenance"
+
 	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/archive"
+	"github.com/brightzheng100/helm-packager/pkg/audit"
+	"github.com/brightzheng100/helm-packager/pkg/sbom"
 	"github.com/brightzheng100/helm-packager/pkg/utils"
 )
 
@@ -20,6 +25,10 @@ func NewBuilder(ctx context.Context) *Builder {
 			ctx: ctx,
 			Config: api.Config{
 				TreeRoot: utils.NewRootTree(),
+				Report:   utils.NewReport(),
+				Manifest: utils.NewManifest(api.LayoutVersioned),
+				Layout:   api.LayoutVersioned,
+				Retry:    utils.DefaultRetryPolicy(),
 			},
 		},
 	}
@@ -30,26 +39,172 @@ func (pb *Builder) ConfigureChartFilesIncluded(included bool) *Builder {
 	return pb
 }
 
+func (pb *Builder) ConfigureLayout(layout api.Layout) *Builder {
+	pb.cp.Layout = layout
+	pb.cp.Manifest.Layout = layout
+	return pb
+}
+
+// WithBundleManifest tells to write the bundle manifest into the directory, at the end of the pipeline
+func (pb *Builder) WithBundleManifest(dir string) *Builder {
+	pb.cp.manifestDir = dir
+	return pb
+}
+
+// WithArchive tells to stream the bundle, written into the directory, into the archive,
+// with the bundle manifest embedded, so that the directory is for staging only
+func (pb *Builder) WithArchive(dir string, aw *archive.Writer) *Builder {
+	pb.cp.archiveDir = dir
+	pb.cp.archive = aw
+	return pb
+}
+
+// WithSBOM tells to generate the SBOM of the bundle from the bundle manifest, in the format,
+// which is written next to the bundle manifest, or archived with it
+func (pb *Builder) WithSBOM(format sbom.Format) *Builder {
+	pb.cp.sbomFormat = format
+	return pb
+}
+
+// WithAudit tells to print the audit of the license and metadata of the charts and their subcharts, in the format,
+// instead of the tree, where the deprecated or unlicensed charts are flagged, and the report is printed to stderr
+func (pb *Builder) WithAudit(format audit.Format) *Builder {
+	pb.cp.auditFormat = format
+	return pb
+}
+
+// ConfigureSince makes the delta bundle relative to the previous bundle of the manifest,
+// where the digest of the manifest is recorded as the base of the delta bundle
+func (pb *Builder) ConfigureSince(since *api.Manifest, digest string) *Builder {
+	pb.cp.Since = since
+	pb.cp.Manifest.Base = digest
+	return pb
+}
+
+// ConfigureVerify tells to verify the provenance of the charts loaded, by the public keys in the keyring,
+// and to keep the chart archives verified as they are, with their .prov files
+func (pb *Builder) ConfigureVerify(verify bool, keyring string) *Builder {
+	pb.cp.Verify = verify
+	pb.cp.Keyring = keyring
+	return pb
+}
+
+// ConfigureSigner signs the charts re-packaged, e.g. transformed, by the signer of utils.NewSigner(...),
+// which are written with their .prov files, as `helm package --sign` does
+func (pb *Builder) ConfigureSigner(signer *provenance.Signatory) *Builder {
+	pb.cp.Signer = signer
+	return pb
+}
+
+// ConfigureQuiet tells not to print the tree and the report at the end of the pipeline
+func (pb *Builder) ConfigureQuiet(quiet bool) *Builder {
+	pb.cp.quiet = quiet
+	return pb
+}
+
 func (pb *Builder) ConfigureDryrun(dryrun bool) *Builder {
 	pb.cp.Dryrun = dryrun
 	return pb
 }
 
+// WithChartLoader adds a chart loader, which can be called multiple times to combine
+// the charts from multiple sources, where the identical charts are loaded only once
 func (pb *Builder) WithChartLoader(cl api.ChartLoader) *Builder {
-	pb.cp.cl = cl
+	pb.cp.cls = append(pb.cp.cls, cl)
+	return pb
+}
+
+func (pb *Builder) ConfigureContinueOnError(continueOnError bool) *Builder {
+	pb.cp.ContinueOnError = continueOnError
 	return pb
 }
 
+func (pb *Builder) ConfigureValuesFiles(valuesFiles map[string][]string) *Builder {
+	pb.cp.ValuesFiles = valuesFiles
+	return pb
+}
+
+// ConfigureImagePolicy checks the images extracted from the charts against the image policy,
+// which fails the charts with the violations, or only warns of them in the report
+func (pb *Builder) ConfigureImagePolicy(policy *api.ImagePolicy) *Builder {
+	pb.cp.ImagePolicy = policy
+	return pb
+}
+
+func (pb *Builder) ConfigureImageFilter(filter api.ImageFilter) *Builder {
+	pb.cp.ImageFilter = filter
+	return pb
+}
+
+func (pb *Builder) ConfigureExtraImages(extraImages map[string][]string) *Builder {
+	pb.cp.ExtraImages = extraImages
+	return pb
+}
+
+func (pb *Builder) ConfigureHooks(include, dropTests bool) *Builder {
+	pb.cp.IncludeHooks = include
+	pb.cp.DropTestHooks = dropTests
+	return pb
+}
+
+func (pb *Builder) ConfigurePlatforms(platforms []string) *Builder {
+	pb.cp.Platforms = platforms
+	return pb
+}
+
+// ConfigureCopyArtifacts copies the cosign signatures, attestations and SBOMs, and the OCI referrers, of the images,
+// which are saved next to the images, and pushed together with them
+func (pb *Builder) ConfigureCopyArtifacts(copyArtifacts bool) *Builder {
+	pb.cp.CopyArtifacts = copyArtifacts
+	return pb
+}
+
+// ConfigurePinDigests resolves the image references to their digests at pull time, which the images are pulled by,
+// and records the references pinned to them in the bundle manifest
+func (pb *Builder) ConfigurePinDigests(pin bool) *Builder {
+	pb.cp.PinDigests = pin
+	pb.cp.Pins = map[string]string{}
+	return pb
+}
+
+func (pb *Builder) ConfigureRegistryAuth(auth map[string]api.RegistryAuth) *Builder {
+	pb.cp.RegistryAuth = auth
+	return pb
+}
+
+func (pb *Builder) ConfigureRetry(policy api.RetryPolicy) *Builder {
+	pb.cp.Retry = policy
+	return pb
+}
+
+// WithTransformers appends the chart transformers, which run in order between loading and writing
+func (pb *Builder) WithTransformers(cts ...api.ChartTransformer) *Builder {
+	pb.cp.cts = append(pb.cp.cts, cts...)
+	return pb
+}
+
+// WithChartWriter adds a chart writer, which can be called multiple times to fan out to multiple sinks
 func (pb *Builder) WithChartWriter(cw api.ChartWriter) *Builder {
-	pb.cp.cw = cw
+	pb.cp.cws = append(pb.cp.cws, cw)
 	return pb
 }
 
+// WithImagesWriter adds an images writer, which can be called multiple times to fan out to multiple sinks
 func (pb *Builder) WithImagesWriter(iw api.ImagesWriter) *Builder {
-	pb.cp.iw = iw
+	pb.cp.iws = append(pb.cp.iws, iw)
 	return pb
 }
 
 func (pb *Builder) Complete() *packager {
+	// the bundle manifest is written into the directory the charts are written into, unless it's told where,
+	// so that the bundle is always read back in its layout
+	if pb.cp.manifestDir == "" && pb.cp.archive == nil {
+		for _, cw := range pb.cp.cws {
+			if d, ok := cw.(interface{ Dir() string }); ok {
+				pb.cp.manifestDir = d.Dir()
+				break
+			}
+		}
+	}
 	return pb.cp
 }
@@ -5,10 +5,17 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 
 	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/archive"
+	"github.com/brightzheng100/helm-packager/pkg/audit"
+	"github.com/brightzheng100/helm-packager/pkg/sbom"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
 )
 
 type packager struct {
@@ -19,21 +26,73 @@ type packager struct {
 	chart *api.Chart
 	tree  *api.Tree
 
-	cl api.ChartLoader
-	cw api.ChartWriter
-	iw api.ImagesWriter
+	cls []api.ChartLoader
+	cts []api.ChartTransformer
+	cws []api.ChartWriter
+	iws []api.ImagesWriter
+
+	// manifestDir is the directory to write the bundle manifest into, if it's set
+	manifestDir string
+
+	// archive streams the bundle, written into archiveDir, into the archive chart by chart, if it's set
+	archive    *archive.Writer
+	archiveDir string
+
+	// sbomFormat is the format of the SBOM to generate from the bundle manifest, if it's set
+	sbomFormat sbom.Format
+
+	// auditFormat is the format of the audit of the charts to print instead of the tree, if it's set,
+	// where the report is printed to stderr, to keep the audit parsable
+	auditFormat audit.Format
+
+	// quiet tells not to print the tree and the report, e.g. when the bundle manifest is all that matters
+	quiet bool
 }
 
 func (cp *packager) GetPackager() *packager {
 	return cp
 }
 
-func (cp *packager) Process() error {
+// Process loads, transforms and writes the charts and their images.
+// By default, it fails fast at the first error of any chart or image.
+// If api.Config.ContinueOnError is set, it carries on with the rest of charts and images instead,
+// and fails with all the failures, which are listed in the report too, aggregated at the end.
+func (cp *packager) Process() (err error) {
+	failures := []error{}
+
+	// the archive is removed if the pipeline stops before it's closed, so that no truncated archive is left behind
+	archived := false
+	if cp.archive != nil {
+		defer func() {
+			if err != nil && !archived {
+				cp.archive.Abort()
+			}
+		}()
+	}
 
 	// load charts
-	charts, err := cp.cl.Load(cp.ctx, cp.Config)
-	if err != nil {
-		return fmt.Errorf("could not load Helm charts: %w", err)
+	charts := []*api.Chart{}
+	for _, cl := range cp.cls {
+		loaded, err := cl.Load(cp.ctx, cp.Config)
+		if err != nil {
+			if !cp.ContinueOnError {
+				return fmt.Errorf("could not load Helm charts: %w", err)
+			}
+			failures = append(failures, err)
+		}
+		charts = append(charts, loaded...)
+	}
+	charts = dedupCharts(cp.Config.Report, charts)
+
+	// transform charts, where any error fails the pipeline as a partially transformed bundle is inconsistent
+	for _, ct := range cp.cts {
+		transformed, err := ct.Transform(cp.ctx, charts, cp.Config)
+		if err != nil {
+			return fmt.Errorf("could not transform Helm charts: %w", err)
+		}
+		recordTransformation(cp.Config.Report, charts, transformed)
+		recordProvenanceDropped(cp.Config.Report, charts, transformed)
+		charts = transformed
 	}
 
 	// sort charts by name
@@ -41,27 +100,203 @@ func (cp *packager) Process() error {
 		return charts[i].C.Metadata.Name < charts[j].C.Metadata.Name
 	})
 
+	// the flat layout keeps only one version of a chart
+	if cp.Layout == api.LayoutFlat {
+		for i := 1; i < len(charts); i++ {
+			if charts[i].C.Metadata.Name == charts[i-1].C.Metadata.Name {
+				return fmt.Errorf("could not lay out multiple versions of chart %s in layout %q, use layout %q instead",
+					charts[i].C.Metadata.Name, api.LayoutFlat, api.LayoutVersioned)
+			}
+		}
+	}
+
+	// the charts are audited as they're shipped, i.e. transformed
+	var a *audit.Audit
+	if cp.auditFormat != "" {
+		a = audit.Charts(charts)
+	}
+
 	for _, chart := range charts {
 		// write chart
-		err = cp.cw.Write(cp.ctx, chart, cp.Config)
-		if err != nil {
-			return fmt.Errorf("could not write Helm chart: %w", err)
+		for _, cw := range cp.cws {
+			if err := cw.Write(cp.ctx, chart, cp.Config); err != nil {
+				err = asTypedError(err, chart.C.Metadata.Name, api.StageWrite)
+				if !cp.ContinueOnError {
+					return fmt.Errorf("could not write Helm chart: %w", err)
+				}
+				failures = append(failures, err)
+			}
 		}
 
 		// write images
-		err = cp.iw.Write(cp.ctx, chart, cp.Config)
-		if err != nil {
-			return fmt.Errorf("could not write images: %w", err)
+		for _, iw := range cp.iws {
+			if err := iw.Write(cp.ctx, chart, cp.Config); err != nil {
+				err = asTypedError(err, chart.C.Metadata.Name, api.StageSave)
+				if !cp.ContinueOnError {
+					return fmt.Errorf("could not write images: %w", err)
+				}
+				failures = append(failures, err)
+			}
+		}
+
+		// archive the chart and its images, which are removed from the bundle directory then
+		if cp.archive != nil {
+			if mc := utils.FindChart(cp.Config.Manifest, chart.C.Metadata.Name, chart.C.Metadata.Version); mc != nil {
+				if err := cp.archive.AddChart(cp.archiveDir, mc); err != nil {
+					return fmt.Errorf("could not archive Helm chart %s: %w", chart.C.Metadata.Name, err)
+				}
+			}
 		}
 	}
 
 	// clean up
-	cp.cl.Finish(cp.ctx, cp.Config)
-	cp.cw.Finish(cp.ctx, cp.Config)
-	cp.iw.Finish(cp.ctx, cp.Config)
+	for _, cl := range cp.cls {
+		if err := cl.Finish(cp.ctx, cp.Config); err != nil {
+			failures = append(failures, fmt.Errorf("could not finish loading Helm charts: %w", err))
+		}
+	}
+	for _, cw := range cp.cws {
+		if err := cw.Finish(cp.ctx, cp.Config); err != nil {
+			failures = append(failures, fmt.Errorf("could not finish writing Helm charts: %w", err))
+		}
+	}
+	for _, iw := range cp.iws {
+		if err := iw.Finish(cp.ctx, cp.Config); err != nil {
+			failures = append(failures, fmt.Errorf("could not finish writing images: %w", err))
+		}
+	}
+
+	if cp.manifestDir != "" {
+		if err := utils.WriteManifest(cp.Config.Manifest, cp.manifestDir); err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	// the SBOM is written next to the bundle manifest, or archived with it
+	if cp.sbomFormat != "" && (cp.manifestDir != "" || cp.archive != nil) {
+		dir := cp.manifestDir
+		if cp.archive != nil {
+			dir = cp.archiveDir
+		}
+		if file, err := sbom.Write(cp.Config.Manifest, cp.sbomFormat, dir); err != nil {
+			failures = append(failures, err)
+		} else {
+			utils.AddRecord(cp.Config.Report, "", "sbom", fmt.Sprintf("%s written", filepath.Base(file)))
+		}
+	}
+
+	if cp.archive != nil {
+		if err := cp.archive.AddDir(cp.archiveDir); err != nil {
+			return fmt.Errorf("could not archive the bundle: %w", err)
+		}
+		if err := cp.archive.Close(cp.Config.Manifest, cp.archiveDir); err != nil {
+			return fmt.Errorf("could not archive the bundle: %w", err)
+		}
+		archived = true
+	}
 
 	// output
-	fmt.Println(cp.Config.TreeRoot.T.String())
+	utils.RecordFailures(cp.Config.Report, failures)
+	if a != nil {
+		if err := a.Print(os.Stdout, cp.auditFormat); err != nil {
+			failures = append(failures, fmt.Errorf("could not print the audit: %w", err))
+		}
+		if !cp.quiet {
+			utils.FprintReport(os.Stderr, cp.Config.Report)
+		}
+	} else if !cp.quiet {
+		fmt.Println(cp.Config.TreeRoot.T.String())
+		utils.PrintReport(cp.Config.Report)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d failure(s) in the pipeline: %w", len(utils.FlattenErrors(failures)), errors.Join(failures...))
+	}
 
 	return nil
 }
+
+// asTypedError wraps the error as api.ChartError, unless it's typed already
+func asTypedError(err error, chartName string, stage api.Stage) error {
+	var ce *api.ChartError
+	var ie *api.ImageError
+	if errors.As(err, &ce) || errors.As(err, &ie) {
+		return err
+	}
+	return &api.ChartError{Chart: chartName, Stage: stage, Err: err}
+}
+
+// dedupCharts drops the identical charts, with the same name and version, loaded by multiple loaders
+func dedupCharts(report *api.Report, charts []*api.Chart) []*api.Chart {
+	type chartKey struct {
+		name, version string
+	}
+	seen := map[chartKey]bool{}
+	deduped := []*api.Chart{}
+	for _, chart := range charts {
+		key := chartKey{chart.C.Metadata.Name, chart.C.Metadata.Version}
+		if seen[key] {
+			utils.AddRecord(report, chart.C.Metadata.Name, "dedup", fmt.Sprintf("dropped duplicate %s", chart.C.Metadata.Version))
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, chart)
+	}
+	return deduped
+}
+
+// recordTransformation records the charts added, dropped or replaced by a transformer into the report
+func recordTransformation(report *api.Report, before, after []*api.Chart) {
+	beforeSet := map[*api.Chart]bool{}
+	for _, chart := range before {
+		beforeSet[chart] = true
+	}
+	afterSet := map[*api.Chart]bool{}
+	for _, chart := range after {
+		afterSet[chart] = true
+	}
+
+	// the dropped charts, by name, which may be replaced by the added ones
+	dropped := map[string]*api.Chart{}
+	for _, chart := range before {
+		if !afterSet[chart] {
+			dropped[chart.C.Metadata.Name] = chart
+		}
+	}
+
+	for _, chart := range after {
+		if beforeSet[chart] {
+			continue
+		}
+		name := chart.C.Metadata.Name
+		if d, ok := dropped[name]; ok {
+			// the transformers record their own edits of a chart kept in the same version
+			if d.C.Metadata.Version != chart.C.Metadata.Version {
+				utils.AddRecord(report, name, "transform", fmt.Sprintf("replaced %s with %s", d.C.Metadata.Version, chart.C.Metadata.Version))
+			}
+			delete(dropped, name)
+		} else {
+			utils.AddRecord(report, name, "transform", fmt.Sprintf("added %s", chart.C.Metadata.Version))
+		}
+	}
+
+	for _, chart := range before {
+		if d, ok := dropped[chart.C.Metadata.Name]; ok && d == chart {
+			utils.AddRecord(report, chart.C.Metadata.Name, "transform", fmt.Sprintf("dropped %s", chart.C.Metadata.Version))
+		}
+	}
+}
+
+// recordProvenanceDropped records the provenance of the charts transformed into the report,
+// which is dropped, as the .prov file doesn't sign the transformed chart
+func recordProvenanceDropped(report *api.Report, before, after []*api.Chart) {
+	afterSet := map[*api.Chart]bool{}
+	for _, chart := range after {
+		afterSet[chart] = true
+	}
+	for _, chart := range before {
+		if chart.Provenance != nil && !afterSet[chart] {
+			utils.AddRecord(report, chart.C.Metadata.Name, "provenance", fmt.Sprintf("%s: dropped, as the chart is transformed", chart.C.Metadata.Version))
+		}
+	}
+}
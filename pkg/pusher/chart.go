@@ -0,0 +1,126 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package pusher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/registry"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+var settings = cli.New()
+
+// pushChart pushes the chart archive to the OCI registry, or uploads it to the ChartMuseum
+func (p *Pusher) pushChart(ctx context.Context, chart *api.ManifestChart) error {
+	if chart.Path == "" {
+		utils.AddRecord(p.report, chart.Name, "delta", fmt.Sprintf("%s: left out of the bundle, expected in the repository", chart.Version))
+		return nil
+	}
+
+	chartFile := filepath.Join(p.fromDir, filepath.FromSlash(chart.Path))
+	data, err := os.ReadFile(chartFile)
+	if err != nil {
+		return &api.ChartError{Chart: chart.Name, Stage: api.StagePush, Err: err}
+	}
+
+	// the .prov file is pushed together with the chart archive it signs, which is verified again first if asked for
+	var prov []byte
+	if chart.Prov != "" {
+		provFile := filepath.Join(p.fromDir, filepath.FromSlash(chart.Prov))
+		if p.verify {
+			v, err := utils.VerifyChart(chartFile, provFile, p.keyring)
+			if err != nil {
+				return &api.ChartError{Chart: chart.Name, Stage: api.StagePush, Err: fmt.Errorf("version %s: %w", chart.Version, err)}
+			}
+			utils.AddRecord(p.report, chart.Name, "provenance", fmt.Sprintf("%s: signed by %s, with key fingerprint %s",
+				chart.Version, strings.Join(v.SignedBy, ", "), v.Fingerprint))
+		}
+		if prov, err = os.ReadFile(provFile); err != nil {
+			return &api.ChartError{Chart: chart.Name, Stage: api.StagePush, Err: err}
+		}
+	} else if p.verify {
+		return &api.ChartError{Chart: chart.Name, Stage: api.StagePush, Err: fmt.Errorf("version %s: no provenance to verify", chart.Version)}
+	}
+
+	// the requests are retried by the transport, with the retries recorded against the chart
+	transport := utils.NewRetryTransport(http.DefaultTransport, p.retry, p.report, chart.Name)
+	if registry.IsOCI(p.toChartRepo) {
+		err = p.pushOCIChart(chart, data, prov, transport)
+	} else {
+		err = p.uploadChart(ctx, "/api/charts", data, transport)
+		if err == nil && prov != nil {
+			err = p.uploadChart(ctx, "/api/prov", prov, transport)
+		}
+	}
+	if err != nil {
+		return &api.ChartError{Chart: chart.Name, Stage: api.StagePush, Err: fmt.Errorf("version %s: %w", chart.Version, err)}
+	}
+	pushed := fmt.Sprintf("%s: pushed to %s", chart.Version, p.toChartRepo)
+	if prov != nil {
+		pushed = fmt.Sprintf("%s: pushed to %s, with provenance", chart.Version, p.toChartRepo)
+	}
+	utils.AddRecord(p.report, chart.Name, "push", pushed)
+
+	return nil
+}
+
+// pushOCIChart pushes the chart archive, with its .prov file if there is, to the OCI registry, as ${toChartRepo}/${chartName}:${chartVersion}
+func (p *Pusher) pushOCIChart(chart *api.ManifestChart, data, prov []byte, transport http.RoundTripper) error {
+	opts := []registry.ClientOption{
+		registry.ClientOptWriter(io.Discard),
+		registry.ClientOptCredentialsFile(settings.RegistryConfig),
+		registry.ClientOptHTTPClient(&http.Client{Transport: transport}),
+	}
+	if p.plainHTTP {
+		opts = append(opts, registry.ClientOptPlainHTTP())
+	}
+	rc, err := registry.NewClient(opts...)
+	if err != nil {
+		return err
+	}
+
+	ref := fmt.Sprintf("%s/%s:%s", strings.TrimPrefix(p.toChartRepo, fmt.Sprintf("%s://", registry.OCIScheme)), chart.Name, chart.Version)
+	pushOpts := []registry.PushOption{}
+	if prov != nil {
+		pushOpts = append(pushOpts, registry.PushOptProvData(prov))
+	}
+	_, err = rc.Push(data, ref, pushOpts...)
+	return err
+}
+
+// uploadChart uploads the chart archive, or its .prov file, to the ChartMuseum, by its API of POST /api/charts, or POST /api/prov
+func (p *Pusher) uploadChart(ctx context.Context, path string, data []byte, transport http.RoundTripper) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.toChartRepo+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if p.username != "" {
+		req.SetBasicAuth(p.username, p.password)
+	}
+
+	client := &http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to upload to %s: %s %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pusher pushes the bundle, pulled by the pipeline, to the target Helm chart repository and image registry
+package pusher
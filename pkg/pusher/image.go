@@ -0,0 +1,269 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package pusher
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// pushImage pushes the saved image to the image registry, keeping its repository path and tag,
+// e.g. docker.io/bitnami/apache:2.4.58 becomes my.registry.com/mirror/bitnami/apache:2.4.58
+func (p *Pusher) pushImage(ctx context.Context, chartName string, image *api.ManifestImage, origins map[string]string) error {
+	ref, err := p.targetRef(image.Ref)
+	if err != nil {
+		return &api.ImageError{Chart: chartName, Image: image.Ref, Stage: api.StagePush, Err: err}
+	}
+
+	// the manifests and blobs are retried by the transport, with the retries recorded against the chart
+	options := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithTransport(utils.NewRetryTransport(remote.DefaultTransport, p.retry, p.report, chartName)),
+	}
+
+	// the image left out of the delta bundle should have been pushed with the previous bundle
+	if image.Path == "" {
+		if _, err := remote.Head(ref, options...); err != nil {
+			return &api.ImageError{Chart: chartName, Image: image.Ref, Stage: api.StagePush,
+				Err: fmt.Errorf("left out of the bundle, but not found as %s, push the previous bundle first: %w", ref, err)}
+		}
+		utils.AddRecord(p.report, chartName, "delta", fmt.Sprintf("%s: left out of the bundle, found as %s", image.Ref, ref))
+	} else {
+		imgPath := filepath.Join(p.fromDir, filepath.FromSlash(image.Path))
+		if strings.HasSuffix(imgPath, ".tar") {
+			err = p.pushTarball(ref, imgPath, options)
+		} else {
+			err = p.pushLayout(ref, imgPath, p.mounts(image, origins), options)
+		}
+		if err != nil {
+			return &api.ImageError{Chart: chartName, Image: image.Ref, Stage: api.StagePush, Err: err}
+		}
+		utils.AddRecord(p.report, chartName, "push", fmt.Sprintf("%s: pushed as %s", image.Ref, ref))
+	}
+
+	// the artifacts are pushed after the image, which the referrers refer to
+	if image.ArtifactsPath == "" {
+		return nil
+	}
+	if err := p.pushArtifacts(ref.Context(), image, options); err != nil {
+		return &api.ImageError{Chart: chartName, Image: image.Ref, Stage: api.StagePush, Err: fmt.Errorf("could not push artifacts: %w", err)}
+	}
+	utils.AddRecord(p.report, chartName, "artifacts", fmt.Sprintf("%s: %d artifact(s) pushed to %s", image.Ref, len(image.Artifacts), ref.Context()))
+
+	return nil
+}
+
+func (p *Pusher) pushTarball(ref name.Reference, imgPath string, options []remote.Option) error {
+	img, err := tarball.ImageFromPath(imgPath, nil)
+	if err != nil {
+		return err
+	}
+	return remote.Write(ref, img, options...)
+}
+
+// pushLayout pushes the image, or the image index, in the OCI image layout,
+// where the blobs left out of the delta bundle are mounted from the images they're from
+func (p *Pusher) pushLayout(ref name.Reference, imgPath string, mounts map[string]name.Reference, options []remote.Option) error {
+	lp, err := layout.FromPath(imgPath)
+	if err != nil {
+		return err
+	}
+	index, err := lp.ImageIndex()
+	if err != nil {
+		return err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+	if len(manifest.Manifests) != 1 {
+		return fmt.Errorf("expected 1 image in %s, but found %d", imgPath, len(manifest.Manifests))
+	}
+
+	desc := manifest.Manifests[0]
+	if desc.MediaType.IsIndex() {
+		ii, err := index.ImageIndex(desc.Digest)
+		if err != nil {
+			return err
+		}
+		return remote.WriteIndex(ref, &mountableIndex{index: ii, mounts: mounts}, options...)
+	}
+	img, err := index.Image(desc.Digest)
+	if err != nil {
+		return err
+	}
+	return remote.Write(ref, &mountableImage{Image: img, mounts: mounts}, options...)
+}
+
+// pushArtifacts pushes the artifacts of the image into the repository of the image,
+// where the cosign artifacts keep their tags, e.g. sha256-<digest>.sig, while the referrers are pushed by digest
+func (p *Pusher) pushArtifacts(repo name.Repository, image *api.ManifestImage, options []remote.Option) error {
+	lp, err := layout.FromPath(filepath.Join(p.fromDir, filepath.FromSlash(image.ArtifactsPath)))
+	if err != nil {
+		return err
+	}
+	index, err := lp.ImageIndex()
+	if err != nil {
+		return err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, artifact := range image.Artifacts {
+		var ref name.Reference = repo.Digest(artifact.Digest)
+		if artifact.Tag != "" {
+			ref = repo.Tag(artifact.Tag)
+		}
+
+		var desc *v1.Descriptor
+		for i := range manifest.Manifests {
+			if manifest.Manifests[i].Digest.String() == artifact.Digest {
+				desc = &manifest.Manifests[i]
+				break
+			}
+		}
+		if desc == nil {
+			return fmt.Errorf("%s %s not found in %s", artifact.Kind, artifact.Digest, image.ArtifactsPath)
+		}
+
+		if err := pushManifest(ref, index, desc, options); err != nil {
+			return fmt.Errorf("could not push %s %s: %w", artifact.Kind, ref, err)
+		}
+	}
+	return nil
+}
+
+// pushManifest pushes the image, or the image index, of the descriptor in the index as it is
+func pushManifest(ref name.Reference, index v1.ImageIndex, desc *v1.Descriptor, options []remote.Option) error {
+	if desc.MediaType.IsIndex() {
+		ii, err := index.ImageIndex(desc.Digest)
+		if err != nil {
+			return err
+		}
+		return remote.WriteIndex(ref, ii, options...)
+	}
+	img, err := index.Image(desc.Digest)
+	if err != nil {
+		return err
+	}
+	return remote.Write(ref, img, options...)
+}
+
+// mounts returns the images in the image registry which the blobs left out of the delta bundle can be mounted from
+func (p *Pusher) mounts(image *api.ManifestImage, origins map[string]string) map[string]name.Reference {
+	mounts := map[string]name.Reference{}
+	for _, blob := range image.Omitted {
+		origin, ok := origins[blob]
+		if !ok {
+			continue
+		}
+		if ref, err := p.targetRef(origin); err == nil {
+			mounts[blob] = ref
+		}
+	}
+	return mounts
+}
+
+// targetRef maps the image reference by the registry mapping the charts are rewritten by, if any,
+// so that the images are pushed to where the charts point at, or to the image registry otherwise
+func (p *Pusher) targetRef(imgref string) (name.Reference, error) {
+	mapping := map[string]string{}
+	if p.toImageRegistry != "" {
+		mapping["*"] = p.toImageRegistry
+	}
+	for source, target := range p.mapping {
+		mapping[source] = target
+	}
+	target, ok := utils.MapImageRef(imgref, mapping)
+	if !ok {
+		return nil, fmt.Errorf("no image registry to push %s to, as its registry is not in the registry mapping of the bundle", imgref)
+	}
+
+	opts := []name.Option{}
+	if p.plainHTTP {
+		opts = append(opts, name.Insecure)
+	}
+	return name.ParseReference(target, opts...)
+}
+
+// mountableImage makes the layers of the image mountable from the other images in the same registry
+type mountableImage struct {
+	v1.Image
+	mounts map[string]name.Reference
+}
+
+func (i *mountableImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for n, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if ref, ok := i.mounts[digest.String()]; ok {
+			layers[n] = &remote.MountableLayer{Layer: layer, Reference: ref}
+		}
+	}
+	return layers, nil
+}
+
+// mountableIndex makes the layers of the images in the image index mountable from the other images in the same registry
+type mountableIndex struct {
+	index  v1.ImageIndex
+	mounts map[string]name.Reference
+}
+
+func (i *mountableIndex) MediaType() (types.MediaType, error) {
+	return i.index.MediaType()
+}
+
+func (i *mountableIndex) Digest() (v1.Hash, error) {
+	return i.index.Digest()
+}
+
+func (i *mountableIndex) Size() (int64, error) {
+	return i.index.Size()
+}
+
+func (i *mountableIndex) IndexManifest() (*v1.IndexManifest, error) {
+	return i.index.IndexManifest()
+}
+
+func (i *mountableIndex) RawManifest() ([]byte, error) {
+	return i.index.RawManifest()
+}
+
+func (i *mountableIndex) Image(h v1.Hash) (v1.Image, error) {
+	img, err := i.index.Image(h)
+	if err != nil {
+		return nil, err
+	}
+	return &mountableImage{Image: img, mounts: i.mounts}, nil
+}
+
+func (i *mountableIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) {
+	ii, err := i.index.ImageIndex(h)
+	if err != nil {
+		return nil, err
+	}
+	return &mountableIndex{index: ii, mounts: i.mounts}, nil
+}
@@ -0,0 +1,172 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package pusher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+	"github.com/brightzheng100/helm-packager/pkg/verifier"
+)
+
+type Pusher struct {
+	fromDir    string
+	fromCharts []string
+
+	toChartRepo     string
+	toImageRegistry string
+	// mapping is the registry mapping the charts in the bundle are rewritten by, which the images are pushed by too
+	mapping map[string]string
+
+	username  string
+	password  string
+	plainHTTP bool
+
+	verify  bool
+	keyring string
+
+	imageSignatureKeys []*verifier.PublicKey
+
+	continueOnError bool
+	retry           api.RetryPolicy
+	report          *api.Report
+}
+
+// NewPusher creates a pusher which pushes the charts in the bundle directory to the chart repository,
+// which is an OCI registry, e.g. oci://my.registry.com/charts, or a ChartMuseum, e.g. https://my.chart.repo,
+// and their images to the image registry with optional namespace, e.g. my.registry.com/mirror.
+// The images of the charts rewritten by a registry mapping at pull time are pushed by the same mapping instead,
+// where the image registry is for the registries not in the mapping, and may be empty then.
+func NewPusher(fromDir, toChartRepo, toImageRegistry string) *Pusher {
+	return &Pusher{
+		fromDir:         fromDir,
+		toChartRepo:     strings.TrimSuffix(toChartRepo, "/"),
+		toImageRegistry: strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(toImageRegistry, "https://"), "http://"), "/"),
+		retry:           utils.DefaultRetryPolicy(),
+		report:          utils.NewReport(),
+	}
+}
+
+// WithCharts pushes only the charts, with optional versions, e.g. apache:10.2.3,nginx, instead of all the charts in the bundle
+func (p *Pusher) WithCharts(fromCharts []string) *Pusher {
+	p.fromCharts = fromCharts
+	return p
+}
+
+// WithBasicAuth sets the credentials of the ChartMuseum, while the OCI registries
+// use the credentials of `helm registry login` and `docker login`
+func (p *Pusher) WithBasicAuth(username, password string) *Pusher {
+	p.username = username
+	p.password = password
+	return p
+}
+
+// WithPlainHTTP pushes to the OCI registries over plain HTTP, instead of HTTPS
+func (p *Pusher) WithPlainHTTP(plainHTTP bool) *Pusher {
+	p.plainHTTP = plainHTTP
+	return p
+}
+
+// WithVerify tells to verify the provenance of the charts again, by the public keys in the keyring, before they're pushed
+func (p *Pusher) WithVerify(verify bool, keyring string) *Pusher {
+	p.verify = verify
+	p.keyring = keyring
+	return p
+}
+
+// WithImageSignatureKeys tells to verify the cosign signatures of the images, copied into the bundle, by the public keys,
+// before anything is pushed, where the push fails if any image is unsigned or mis-signed
+func (p *Pusher) WithImageSignatureKeys(keys []*verifier.PublicKey) *Pusher {
+	p.imageSignatureKeys = keys
+	return p
+}
+
+func (p *Pusher) ConfigureContinueOnError(continueOnError bool) *Pusher {
+	p.continueOnError = continueOnError
+	return p
+}
+
+func (p *Pusher) ConfigureRetry(policy api.RetryPolicy) *Pusher {
+	p.retry = policy
+	return p
+}
+
+// Push pushes the charts and their images in the bundle.
+// The charts and images left out of a delta bundle are expected in the target already,
+// as the previous bundles are pushed, where the image blobs left out are mounted from the images they're from.
+func (p *Pusher) Push(ctx context.Context) error {
+	manifest, err := utils.ReadBundle(p.fromDir)
+	if err != nil {
+		return fmt.Errorf("could not read bundle %s: %w", p.fromDir, err)
+	}
+	origins := utils.Blobs(manifest)
+
+	p.mapping = manifest.RegistryMapping
+	if p.toImageRegistry == "" && len(p.mapping) == 0 {
+		return fmt.Errorf("no image registry to push the images of bundle %s to, as its charts are not rewritten by any registry mapping", p.fromDir)
+	}
+
+	charts := []*api.ManifestChart{}
+	for _, chart := range manifest.Charts {
+		if p.selected(chart) {
+			charts = append(charts, chart)
+		}
+	}
+
+	// the signatures of all the images to push are verified before anything is pushed
+	if len(p.imageSignatureKeys) > 0 {
+		v := verifier.NewVerifier(p.fromDir).WithImageSignatureKeys(p.imageSignatureKeys).WithReport(p.report)
+		if failures := v.VerifyImages(charts); len(failures) > 0 {
+			utils.RecordFailures(p.report, failures)
+			utils.PrintReport(p.report)
+			return fmt.Errorf("%d image(s) unsigned or mis-signed, nothing pushed: %w", len(failures), errors.Join(failures...))
+		}
+	}
+
+	failures := []error{}
+	for _, chart := range charts {
+		if err := p.pushChart(ctx, chart); err != nil {
+			if !p.continueOnError {
+				return fmt.Errorf("could not push Helm chart: %w", err)
+			}
+			failures = append(failures, err)
+		}
+
+		for _, image := range chart.Images {
+			if err := p.pushImage(ctx, chart.Name, image, origins); err != nil {
+				if !p.continueOnError {
+					return fmt.Errorf("could not push images: %w", err)
+				}
+				failures = append(failures, err)
+			}
+		}
+	}
+
+	utils.RecordFailures(p.report, failures)
+	utils.PrintReport(p.report)
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d failure(s) in the push: %w", len(failures), errors.Join(failures...))
+	}
+
+	return nil
+}
+
+// selected tells whether the chart is one of the charts to push, which are all if there is none
+func (p *Pusher) selected(chart *api.ManifestChart) bool {
+	if len(p.fromCharts) == 0 {
+		return true
+	}
+	for _, fromChart := range p.fromCharts {
+		chartName, chartVersion, _ := strings.Cut(fromChart, ":")
+		if chartName == chart.Name && (chartVersion == "" || chartVersion == chart.Version) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package sbom
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// cdxDocument is the CycloneDX 1.5 document, with the fields used only
+// Ref: https://cyclonedx.org/docs/1.5/json/
+type cdxDocument struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string   `json:"timestamp"`
+	Tools     cdxTools `json:"tools"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type               string                 `json:"type"`
+	BOMRef             string                 `json:"bom-ref,omitempty"`
+	Name               string                 `json:"name"`
+	Version            string                 `json:"version,omitempty"`
+	Description        string                 `json:"description,omitempty"`
+	Hashes             []cdxHash              `json:"hashes,omitempty"`
+	PURL               string                 `json:"purl,omitempty"`
+	ExternalReferences []cdxExternalReference `json:"externalReferences,omitempty"`
+	Properties         []cdxProperty          `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// generateCycloneDX generates the CycloneDX document, with the dependencies of the charts on their images
+func generateCycloneDX(inv *inventory, created time.Time) ([]byte, error) {
+	doc := &cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid.NewString(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: created.Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: toolName}}},
+		},
+		Components:   []cdxComponent{},
+		Dependencies: []cdxDependency{},
+	}
+
+	for _, chart := range inv.charts {
+		component := cdxComponent{
+			Type:        "application",
+			BOMRef:      "chart:" + chart.name + "@" + chart.version,
+			Name:        chart.name,
+			Version:     chart.version,
+			Description: "Helm chart",
+		}
+		if chart.sha256 != "" {
+			component.Hashes = []cdxHash{{Alg: "SHA-256", Content: chart.sha256}}
+		}
+		if chart.source != "" {
+			component.ExternalReferences = []cdxExternalReference{{Type: "distribution", URL: chart.source}}
+		}
+		doc.Components = append(doc.Components, component)
+
+		dependency := cdxDependency{Ref: component.BOMRef, DependsOn: []string{}}
+		for _, image := range chart.images {
+			dependency.DependsOn = append(dependency.DependsOn, "image:"+image.ref)
+		}
+		doc.Dependencies = append(doc.Dependencies, dependency)
+	}
+
+	for _, image := range inv.images {
+		component := cdxComponent{
+			Type:    "container",
+			BOMRef:  "image:" + image.ref,
+			Name:    image.repository,
+			Version: image.tag,
+			PURL:    image.purl(),
+		}
+		if image.sha256 != "" {
+			component.Hashes = []cdxHash{{Alg: "SHA-256", Content: image.sha256}}
+		}
+		for _, platform := range image.platforms {
+			component.Properties = append(component.Properties, cdxProperty{Name: "helm-packager:platform", Value: platform})
+		}
+		if image.manual {
+			component.Properties = append(component.Properties, cdxProperty{Name: "helm-packager:source", Value: "manual"})
+		}
+		doc.Components = append(doc.Components, component)
+		doc.Dependencies = append(doc.Dependencies, cdxDependency{Ref: component.BOMRef, DependsOn: []string{}})
+	}
+
+	return marshal(doc)
+}
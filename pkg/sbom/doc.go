@@ -0,0 +1,6 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package sbom generates the SBOM of the bundle, as an SPDX or CycloneDX document, from the bundle manifest,
+// with the charts and the images as the components, and the dependencies of the charts on their images
+package sbom
@@ -0,0 +1,181 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// Format represents the format of the SBOM
+type Format string
+
+const (
+	// FormatSPDX is the SPDX 2.3 JSON document, written as bundle.spdx.json
+	FormatSPDX Format = "spdx"
+	// FormatCycloneDX is the CycloneDX 1.5 JSON document, written as bundle.cdx.json
+	FormatCycloneDX Format = "cyclonedx"
+)
+
+// toolName is the name of the tool which generates the SBOM
+const toolName = "helm-packager"
+
+// ParseFormat parses the format of the SBOM, spdx or cyclonedx
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(format); f {
+	case FormatSPDX, FormatCycloneDX:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported SBOM format %q, expected %s or %s", format, FormatSPDX, FormatCycloneDX)
+	}
+}
+
+// FileName returns the file name of the SBOM in the bundle directory
+func FileName(format Format) string {
+	if format == FormatCycloneDX {
+		return "bundle.cdx.json"
+	}
+	return "bundle.spdx.json"
+}
+
+// Write writes the SBOM of the bundle, generated from its manifest, into the bundle directory, and returns the file written
+func Write(m *api.Manifest, format Format, dir string) (string, error) {
+	data, err := Generate(m, format, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, FileName(format))
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return "", fmt.Errorf("could not write SBOM %s: %w", file, err)
+	}
+	return file, nil
+}
+
+// Generate generates the SBOM of the bundle from its manifest, created at the time
+func Generate(m *api.Manifest, format Format, created time.Time) ([]byte, error) {
+	inv := collect(m)
+	switch format {
+	case FormatSPDX:
+		return generateSPDX(inv, created)
+	case FormatCycloneDX:
+		return generateCycloneDX(inv, created)
+	default:
+		return nil, fmt.Errorf("unsupported SBOM format %q", format)
+	}
+}
+
+// marshal encodes the document as indented JSON, without escaping "&" in the purls
+func marshal(doc interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return nil, fmt.Errorf("could not encode SBOM: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// inventory is what's in the bundle, i.e. the charts and the images they depend on
+type inventory struct {
+	charts []*chartComponent
+	images []*imageComponent
+}
+
+type chartComponent struct {
+	name    string
+	version string
+	source  string
+	sha256  string
+	images  []*imageComponent
+}
+
+type imageComponent struct {
+	ref        string
+	repository string // the repository with the registry normalized, e.g. docker.io/bitnami/nginx
+	tag        string
+	sha256     string // of the image manifest, or the image index, in the bundle
+	platforms  []string
+	manual     bool
+}
+
+// collect collects the charts, sorted by name and version, and the images shared by them only once, from the manifest
+func collect(m *api.Manifest) *inventory {
+	charts := append([]*api.ManifestChart{}, m.Charts...)
+	sort.SliceStable(charts, func(i, j int) bool {
+		if charts[i].Name != charts[j].Name {
+			return charts[i].Name < charts[j].Name
+		}
+		return charts[i].Version < charts[j].Version
+	})
+
+	inv := &inventory{}
+	images := map[string]*imageComponent{}
+	for _, mc := range charts {
+		chart := &chartComponent{
+			name:    mc.Name,
+			version: mc.Version,
+			source:  mc.Source,
+			sha256:  strings.TrimPrefix(mc.Digest, "sha256:"),
+		}
+		for _, mi := range mc.Images {
+			image, ok := images[mi.Ref]
+			if !ok {
+				image = newImageComponent(mi)
+				images[mi.Ref] = image
+				inv.images = append(inv.images, image)
+			}
+			chart.images = append(chart.images, image)
+		}
+		inv.charts = append(inv.charts, chart)
+	}
+	return inv
+}
+
+func newImageComponent(mi *api.ManifestImage) *imageComponent {
+	digest := mi.Digest
+	if digest == "" {
+		digest = mi.RefDigest
+	}
+	image := &imageComponent{
+		ref:        mi.Ref,
+		repository: mi.Ref,
+		sha256:     strings.TrimPrefix(digest, "sha256:"),
+		platforms:  mi.Platforms,
+		manual:     mi.Source == "manual",
+	}
+
+	base, _, _ := strings.Cut(mi.Ref, "@")
+	if t, err := name.NewTag(base); err == nil {
+		image.repository = fmt.Sprintf("%s/%s", utils.NormalizeRegistry(t.RegistryStr()), t.RepositoryStr())
+		image.tag = t.TagStr()
+	}
+	return image
+}
+
+// purl returns the package URL of the image, e.g. pkg:oci/nginx@sha256%3A...?repository_url=docker.io/bitnami/nginx&tag=1.25.3,
+// or empty if the image has no digest
+func (image *imageComponent) purl() string {
+	if image.sha256 == "" {
+		return ""
+	}
+	purl := fmt.Sprintf("pkg:oci/%s@sha256%%3A%s?repository_url=%s", strings.ToLower(path.Base(image.repository)), image.sha256, image.repository)
+	if image.tag != "" {
+		purl += "&tag=" + url.QueryEscape(image.tag)
+	}
+	return purl
+}
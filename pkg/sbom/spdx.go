@@ -0,0 +1,144 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package sbom
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// spdxDocument is the SPDX 2.3 document, with the fields used only
+// Ref: https://spdx.github.io/spdx-spec/v2.3/
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name                  string            `json:"name"`
+	SPDXID                string            `json:"SPDXID"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose"`
+	Comment               string            `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxIDInvalid matches the characters not allowed in the SPDX identifiers
+var spdxIDInvalid = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// spdxID returns the SPDX identifier of the element, e.g. SPDXRef-Chart-nginx-15.0.0
+func spdxID(kind, name string) string {
+	return fmt.Sprintf("SPDXRef-%s-%s", kind, strings.Trim(spdxIDInvalid.ReplaceAllString(name, "-"), "-"))
+}
+
+// generateSPDX generates the SPDX document, which describes the charts, depending on their images
+func generateSPDX(inv *inventory, created time.Time) ([]byte, error) {
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              "helm-packager-bundle",
+		DocumentNamespace: fmt.Sprintf("https://github.com/brightzheng100/helm-packager/spdx/bundle-%s", uuid.NewString()),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.Format(time.RFC3339),
+			Creators: []string{"Tool: " + toolName},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelationship{},
+	}
+
+	for _, chart := range inv.charts {
+		pkg := spdxPackage{
+			Name:                  chart.name,
+			SPDXID:                spdxID("Chart", chart.name+"-"+chart.version),
+			VersionInfo:           chart.version,
+			DownloadLocation:      spdxLocation(chart.source),
+			PrimaryPackagePurpose: "APPLICATION",
+			Comment:               "Helm chart",
+		}
+		if chart.sha256 != "" {
+			pkg.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: chart.sha256}}
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: doc.SPDXID, RelationshipType: "DESCRIBES", RelatedSPDXElement: pkg.SPDXID})
+		for _, image := range chart.images {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: pkg.SPDXID, RelationshipType: "DEPENDS_ON", RelatedSPDXElement: spdxID("Image", image.ref)})
+		}
+	}
+
+	for _, image := range inv.images {
+		pkg := spdxPackage{
+			Name:                  image.repository,
+			SPDXID:                spdxID("Image", image.ref),
+			VersionInfo:           image.tag,
+			DownloadLocation:      "NOASSERTION", // the image reference is not a URL, which is in the purl instead
+			PrimaryPackagePurpose: "CONTAINER",
+			Comment:               imageComment(image),
+		}
+		if image.sha256 != "" {
+			pkg.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: image.sha256}}
+		}
+		if purl := image.purl(); purl != "" {
+			pkg.ExternalRefs = []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: purl}}
+		}
+		doc.Packages = append(doc.Packages, pkg)
+	}
+
+	return marshal(doc)
+}
+
+// spdxLocation returns the download location, which is NOASSERTION if it's unknown
+func spdxLocation(location string) string {
+	if location == "" {
+		return "NOASSERTION"
+	}
+	return location
+}
+
+// imageComment tells the platforms of the image, and whether it's added manually, instead of extracted from the charts
+func imageComment(image *imageComponent) string {
+	comment := []string{}
+	if len(image.platforms) > 0 {
+		comment = append(comment, "platforms: "+strings.Join(image.platforms, ", "))
+	}
+	if image.manual {
+		comment = append(comment, "added manually")
+	}
+	return strings.Join(comment, "; ")
+}
@@ -0,0 +1,278 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DB is the local vulnerability database, loaded from the OSV records, indexed by the ecosystems and the package names
+type DB struct {
+	// entries are the records by the ecosystem without its release, e.g. Debian for Debian:12, and by the package name
+	entries map[string]map[string][]*osvEntry
+	count   int
+}
+
+// osvEntry is an OSV record, see https://ossf.github.io/osv-schema/, with only the fields to match and report by
+type osvEntry struct {
+	ID               string                 `json:"id"`
+	Aliases          []string               `json:"aliases"`
+	Summary          string                 `json:"summary"`
+	Withdrawn        string                 `json:"withdrawn"`
+	Severity         []osvSeverity          `json:"severity"`
+	Affected         []osvAffected          `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+type osvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges            []osvRange             `json:"ranges"`
+	Versions          []string               `json:"versions"`
+	EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+}
+
+type osvRange struct {
+	Type   string     `json:"type"`
+	Events []osvEvent `json:"events"`
+}
+
+type osvEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// LoadDB loads the OSV records from the path, which is a directory of the JSON files, e.g. the OSV dump directory,
+// or the all.zip of the ecosystems exported by OSV, which may be in the directory too, or a single JSON file
+func LoadDB(path string) (*DB, error) {
+	db := &DB{entries: map[string]map[string][]*osvEntry{}}
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".json":
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return db.add(p, data)
+		case ".zip":
+			return db.addZip(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load vulnerability database %s: %w", path, err)
+	}
+	if db.count == 0 {
+		return nil, fmt.Errorf("could not load vulnerability database %s: no OSV record found", path)
+	}
+
+	return db, nil
+}
+
+// Len returns the number of the records loaded
+func (db *DB) Len() int {
+	return db.count
+}
+
+func (db *DB) addZip(path string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !strings.EqualFold(filepath.Ext(f.Name), ".json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if err := db.add(path+"!"+f.Name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds the OSV record, where the withdrawn records, and the files which aren't OSV records, are skipped
+func (db *DB) add(name string, data []byte) error {
+	entry := &osvEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return fmt.Errorf("invalid OSV record %s: %w", name, err)
+	}
+	if entry.ID == "" || entry.Withdrawn != "" {
+		return nil
+	}
+
+	for _, affected := range entry.Affected {
+		ecosystem, _, _ := strings.Cut(affected.Package.Ecosystem, ":")
+		if ecosystem == "" || affected.Package.Name == "" {
+			continue
+		}
+		packages, ok := db.entries[ecosystem]
+		if !ok {
+			packages = map[string][]*osvEntry{}
+			db.entries[ecosystem] = packages
+		}
+		// the record affecting the package in multiple releases is indexed only once
+		if entries := packages[affected.Package.Name]; len(entries) == 0 || entries[len(entries)-1] != entry {
+			packages[affected.Package.Name] = append(entries, entry)
+		}
+	}
+	db.count++
+	return nil
+}
+
+// lookup returns the records affecting the version of the package in the ecosystem, e.g. Debian:12,
+// with the affected entries matched, where the version is compared by the package manager of the ecosystem
+func (db *DB) lookup(ecosystem, pkg, version string, compare compareFunc) []*match {
+	base, _, _ := strings.Cut(ecosystem, ":")
+
+	matches := []*match{}
+	for _, entry := range db.entries[base][pkg] {
+		for i := range entry.Affected {
+			affected := &entry.Affected[i]
+			if affected.Package.Name != pkg || !matchEcosystem(affected.Package.Ecosystem, ecosystem) {
+				continue
+			}
+			if fixed, ok := isAffected(affected, version, compare); ok {
+				matches = append(matches, &match{entry: entry, affected: affected, fixed: fixed})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// match is a record matched, with the affected entry matched and the version the package is fixed in, if any
+type match struct {
+	entry    *osvEntry
+	affected *osvAffected
+	fixed    string
+}
+
+// matchEcosystem tells whether the ecosystem of the record, e.g. Ubuntu:22.04:LTS, is for the ecosystem of the image, e.g. Ubuntu:22.04,
+// where the ecosystem of the record without the release, e.g. Wolfi, is for all the releases
+func matchEcosystem(affected, ecosystem string) bool {
+	if affected == ecosystem || strings.HasPrefix(affected, ecosystem+":") {
+		return true
+	}
+	base, _, _ := strings.Cut(ecosystem, ":")
+	return affected == base
+}
+
+// isAffected tells whether the version is affected, by the versions listed or the ECOSYSTEM ranges, where the events are
+// applied in the order of their versions up to the version, and returns the version fixed in, which is after the version
+func isAffected(affected *osvAffected, version string, compare compareFunc) (string, bool) {
+	for _, v := range affected.Versions {
+		if v == version {
+			return fixedVersion(affected, version, compare), true
+		}
+	}
+
+	for _, r := range affected.Ranges {
+		if r.Type != "ECOSYSTEM" {
+			continue
+		}
+
+		vulnerable := false
+		for _, event := range sortEvents(r.Events, compare) {
+			switch {
+			case event.Introduced != "":
+				if event.Introduced == "0" || compare(version, event.Introduced) >= 0 {
+					vulnerable = true
+				}
+			case event.Fixed != "":
+				if compare(version, event.Fixed) >= 0 {
+					vulnerable = false
+				}
+			case event.LastAffected != "":
+				if compare(version, event.LastAffected) > 0 {
+					vulnerable = false
+				}
+			case event.Limit != "":
+				if compare(version, event.Limit) >= 0 {
+					vulnerable = false
+				}
+			}
+		}
+		if vulnerable {
+			return fixedVersion(affected, version, compare), true
+		}
+	}
+	return "", false
+}
+
+// sortEvents sorts the events by their versions, where the introduced "0" is before any version
+func sortEvents(events []osvEvent, compare compareFunc) []osvEvent {
+	sorted := append([]osvEvent{}, events...)
+	version := func(e osvEvent) string {
+		return e.Introduced + e.Fixed + e.LastAffected + e.Limit
+	}
+	less := func(a, b osvEvent) bool {
+		va, vb := version(a), version(b)
+		switch {
+		case va == "0" && a.Introduced != "":
+			return vb != "0" || b.Introduced == ""
+		case vb == "0" && b.Introduced != "":
+			return false
+		}
+		return compare(va, vb) < 0
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+// fixedVersion returns the lowest version fixed in of the ECOSYSTEM ranges, which is after the version
+func fixedVersion(affected *osvAffected, version string, compare compareFunc) string {
+	fixed := ""
+	for _, r := range affected.Ranges {
+		if r.Type != "ECOSYSTEM" {
+			continue
+		}
+		for _, event := range r.Events {
+			if event.Fixed == "" || compare(event.Fixed, version) <= 0 {
+				continue
+			}
+			if fixed == "" || compare(event.Fixed, fixed) < 0 {
+				fixed = event.Fixed
+			}
+		}
+	}
+	return fixed
+}
@@ -0,0 +1,109 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsAffected(t *testing.T) {
+	ranges := func(events ...osvEvent) []osvRange {
+		return []osvRange{{Type: "ECOSYSTEM", Events: events}}
+	}
+
+	tests := []struct {
+		name     string
+		affected osvAffected
+		compare  compareFunc
+		version  string
+		want     bool
+		fixed    string
+	}{
+		{"introduced 0, before fixed", osvAffected{Ranges: ranges(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.2-1"})}, compareDpkg, "1.1-1", true, "1.2-1"},
+		{"introduced 0, at fixed", osvAffected{Ranges: ranges(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.2-1"})}, compareDpkg, "1.2-1", false, ""},
+		{"introduced 0, after fixed", osvAffected{Ranges: ranges(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.2-1"})}, compareDpkg, "1.2-1+deb12u1", false, ""},
+		{"before introduced", osvAffected{Ranges: ranges(osvEvent{Introduced: "1.0"}, osvEvent{Fixed: "1.2"})}, compareDpkg, "0.9", false, ""},
+		{"at introduced", osvAffected{Ranges: ranges(osvEvent{Introduced: "1.0"}, osvEvent{Fixed: "1.2"})}, compareDpkg, "1.0", true, "1.2"},
+		{"at last_affected", osvAffected{Ranges: ranges(osvEvent{Introduced: "1.0"}, osvEvent{LastAffected: "1.5"})}, compareDpkg, "1.5", true, ""},
+		{"after last_affected", osvAffected{Ranges: ranges(osvEvent{Introduced: "1.0"}, osvEvent{LastAffected: "1.5"})}, compareDpkg, "1.5.1", false, ""},
+		{"before limit", osvAffected{Ranges: ranges(osvEvent{Introduced: "1.0"}, osvEvent{Limit: "2.0"})}, compareDpkg, "1.9", true, ""},
+		{"at limit", osvAffected{Ranges: ranges(osvEvent{Introduced: "1.0"}, osvEvent{Limit: "2.0"})}, compareDpkg, "2.0", false, ""},
+		{"reintroduced, between", osvAffected{Ranges: ranges(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.0"}, osvEvent{Introduced: "2.0"}, osvEvent{Fixed: "2.5"})}, compareDpkg, "1.5", false, ""},
+		{"reintroduced, first", osvAffected{Ranges: ranges(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.0"}, osvEvent{Introduced: "2.0"}, osvEvent{Fixed: "2.5"})}, compareDpkg, "0.5", true, "1.0"},
+		{"reintroduced, second", osvAffected{Ranges: ranges(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.0"}, osvEvent{Introduced: "2.0"}, osvEvent{Fixed: "2.5"})}, compareDpkg, "2.1", true, "2.5"},
+		{"events unsorted", osvAffected{Ranges: ranges(osvEvent{Fixed: "2.5"}, osvEvent{Introduced: "2.0"}, osvEvent{Fixed: "1.0"}, osvEvent{Introduced: "0"})}, compareDpkg, "2.1", true, "2.5"},
+		{"fixed by epoch", osvAffected{Ranges: ranges(osvEvent{Introduced: "0"}, osvEvent{Fixed: "1:3.0.7-25.el9"})}, compareRpm, "1:3.0.7-24.el9", true, "1:3.0.7-25.el9"},
+		{"apk pre-release", osvAffected{Ranges: ranges(osvEvent{Introduced: "0"}, osvEvent{Fixed: "3.1.4-r6"})}, compareApk, "3.1.4_rc1-r0", true, "3.1.4-r6"},
+		{"versions listed", osvAffected{Versions: []string{"1.0-3", "1.0-4"}}, compareDpkg, "1.0-3", true, ""},
+		{"versions not listed", osvAffected{Versions: []string{"1.0-3", "1.0-4"}}, compareDpkg, "1.0-5", false, ""},
+		{"non-ECOSYSTEM ranges", osvAffected{Ranges: []osvRange{{Type: "GIT", Events: []osvEvent{{Introduced: "0"}}}}}, compareDpkg, "1.0", false, ""},
+		{"lowest fixed after version", osvAffected{Ranges: []osvRange{
+			{Type: "ECOSYSTEM", Events: []osvEvent{{Introduced: "0"}, {Fixed: "1.5"}}},
+			{Type: "ECOSYSTEM", Events: []osvEvent{{Introduced: "0"}, {Fixed: "1.3"}}},
+			{Type: "ECOSYSTEM", Events: []osvEvent{{Introduced: "0"}, {Fixed: "0.9"}}},
+		}}, compareDpkg, "1.0", true, "1.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixed, got := isAffected(&tt.affected, tt.version, tt.compare)
+			if got != tt.want || fixed != tt.fixed {
+				t.Errorf("isAffected(%s) = %q, %v, want %q, %v", tt.version, fixed, got, tt.fixed, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	records := map[string]string{
+		"DSA-1.json": `{"id": "DSA-1", "affected": [{"package": {"ecosystem": "Debian:12", "name": "openssl"},
+			"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "3.0.11-1~deb12u2"}]}]}]}`,
+		"USN-1.json": `{"id": "USN-1", "affected": [{"package": {"ecosystem": "Ubuntu:22.04:LTS", "name": "openssl"},
+			"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "3.0.2-0ubuntu1.12"}]}]}]}`,
+		"CGA-1.json": `{"id": "CGA-1", "affected": [{"package": {"ecosystem": "Wolfi", "name": "openssl"},
+			"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "3.2.0-r0"}]}]}]}`,
+		"DSA-2.json": `{"id": "DSA-2", "withdrawn": "2023-01-01T00:00:00Z", "affected": [{"package": {"ecosystem": "Debian:12", "name": "openssl"},
+			"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}]}]}]}`,
+	}
+	for name, record := range records {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(record), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := LoadDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 3 {
+		t.Errorf("records = %d, want 3, without the withdrawn one", db.Len())
+	}
+
+	tests := []struct {
+		name      string
+		ecosystem string
+		version   string
+		compare   compareFunc
+		want      []string
+	}{
+		{"by release", "Debian:12", "3.0.11-1~deb12u1", compareDpkg, []string{"DSA-1"}},
+		{"fixed", "Debian:12", "3.0.11-1~deb12u2", compareDpkg, []string{}},
+		{"other release", "Debian:11", "1.1.1n-0+deb11u5", compareDpkg, []string{}},
+		{"release with suffix", "Ubuntu:22.04", "3.0.2-0ubuntu1.10", compareDpkg, []string{"USN-1"}},
+		{"no release", "Wolfi", "3.1.4-r5", compareApk, []string{"CGA-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := []string{}
+			for _, m := range db.lookup(tt.ecosystem, "openssl", tt.version, tt.compare) {
+				ids = append(ids, m.entry.ID)
+			}
+			if len(ids) != len(tt.want) || (len(ids) > 0 && ids[0] != tt.want[0]) {
+				t.Errorf("lookup(%s, %s) = %v, want %v", tt.ecosystem, tt.version, ids, tt.want)
+			}
+		})
+	}
+}
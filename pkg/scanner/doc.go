@@ -0,0 +1,6 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package scanner scans the images of the bundle offline, by matching the OS packages installed in them,
+// by dpkg, apk or rpm, against a local vulnerability database, e.g. an OSV export
+package scanner
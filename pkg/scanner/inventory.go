@@ -0,0 +1,279 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// Package is an OS package installed in an image, by its name and version in the vulnerability database,
+// e.g. of the source package for dpkg, which the Debian and Ubuntu records are for
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// inventory is the OS packages installed in an image, with the distribution they're for
+type inventory struct {
+	// distro is the ID and VERSION_ID of os-release, e.g. debian 12
+	distro    string
+	ecosystem string
+	manager   string
+	compare   compareFunc
+	packages  []*Package
+}
+
+// the files read from the image, without the leading /
+var (
+	osReleaseFiles = []string{"etc/os-release", "usr/lib/os-release"}
+	dpkgStatus     = "var/lib/dpkg/status"
+	dpkgStatusDir  = "var/lib/dpkg/status.d/" // of the distroless images, with a file per package
+	apkInstalled   = "lib/apk/db/installed"
+	rpmSqlite      = []string{"var/lib/rpm/rpmdb.sqlite", "usr/lib/sysimage/rpm/rpmdb.sqlite"}
+	rpmLegacy      = []string{"var/lib/rpm/Packages", "var/lib/rpm/Packages.db", "usr/lib/sysimage/rpm/Packages.db"}
+)
+
+var (
+	// errNoPackages tells the image has no OS package database, e.g. a scratch or static image
+	errNoPackages = errors.New("no OS package database found, by dpkg, apk or rpm")
+	// errUnsupported tells the OS package database of the image is unsupported, e.g. the rpm database in Berkeley DB
+	errUnsupported = errors.New("unsupported")
+)
+
+// readInventory reads the OS packages installed in the image, from its filesystem with all its layers applied
+func readInventory(img v1.Image) (*inventory, error) {
+	rc := mutate.Extract(img)
+	defer rc.Close()
+
+	files := map[string][]byte{}
+	statusD := [][]byte{}
+	legacy := ""
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read image filesystem: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		switch {
+		case slices.Contains(osReleaseFiles, name), name == dpkgStatus, name == apkInstalled, slices.Contains(rpmSqlite, name):
+		case strings.HasPrefix(name, dpkgStatusDir) && !strings.HasSuffix(name, ".md5sums"):
+		case slices.Contains(rpmLegacy, name):
+			legacy = name
+			continue
+		default:
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", name, err)
+		}
+		if strings.HasPrefix(name, dpkgStatusDir) {
+			statusD = append(statusD, data)
+			continue
+		}
+		files[name] = data
+	}
+
+	inv := &inventory{}
+	for _, name := range osReleaseFiles {
+		if data, ok := files[name]; ok {
+			id, versionID := parseOSRelease(data)
+			inv.distro = strings.TrimSpace(id + " " + versionID)
+			inv.ecosystem = ecosystem(id, versionID)
+			break
+		}
+	}
+
+	switch {
+	case files[dpkgStatus] != nil || len(statusD) > 0:
+		inv.manager, inv.compare = "dpkg", compareDpkg
+		for _, data := range append([][]byte{files[dpkgStatus]}, statusD...) {
+			inv.packages = append(inv.packages, parseDpkgStatus(data)...)
+		}
+	case files[apkInstalled] != nil:
+		inv.manager, inv.compare = "apk", compareApk
+		inv.packages = parseApkInstalled(files[apkInstalled])
+	case files[rpmSqlite[0]] != nil || files[rpmSqlite[1]] != nil:
+		inv.manager, inv.compare = "rpm", compareRpm
+		data := files[rpmSqlite[0]]
+		if data == nil {
+			data = files[rpmSqlite[1]]
+		}
+		packages, err := readRpmDB(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not read rpm database: %w", err)
+		}
+		for _, pkg := range packages {
+			version := pkg.version + "-" + pkg.release
+			if pkg.hasEpoch {
+				version = fmt.Sprintf("%d:%s", pkg.epoch, version)
+			}
+			inv.packages = append(inv.packages, &Package{Name: pkg.name, Version: version})
+		}
+	case legacy != "":
+		return nil, fmt.Errorf("%w rpm database %s, only rpmdb.sqlite is supported", errUnsupported, legacy)
+	default:
+		return nil, errNoPackages
+	}
+
+	inv.packages = dedupPackages(inv.packages)
+	return inv, nil
+}
+
+// parseOSRelease returns the ID and VERSION_ID of os-release
+func parseOSRelease(data []byte) (string, string) {
+	id, versionID := "", ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			versionID = value
+		}
+	}
+	return id, versionID
+}
+
+// ecosystem returns the OSV ecosystem of the distribution, e.g. Debian:12 for debian 12, or empty if it's unsupported
+func ecosystem(id, versionID string) string {
+	major, _, _ := strings.Cut(versionID, ".")
+	switch id {
+	case "debian":
+		if major != "" {
+			return "Debian:" + major
+		}
+	case "ubuntu":
+		if versionID != "" {
+			return "Ubuntu:" + versionID
+		}
+	case "alpine":
+		if parts := strings.Split(versionID, "."); len(parts) >= 2 {
+			return "Alpine:v" + parts[0] + "." + parts[1]
+		}
+	case "rhel":
+		if major != "" {
+			return "Red Hat:enterprise_linux:" + major
+		}
+	case "almalinux":
+		if major != "" {
+			return "AlmaLinux:" + major
+		}
+	case "rocky":
+		if major != "" {
+			return "Rocky Linux:" + major
+		}
+	case "wolfi":
+		return "Wolfi"
+	case "chainguard":
+		return "Chainguard"
+	}
+	return ""
+}
+
+// parseDpkgStatus parses the packages installed in the dpkg status, by their source packages and versions, if any,
+// e.g. Source: openssl (3.0.11-1~deb12u2) for libssl3
+func parseDpkgStatus(data []byte) []*Package {
+	packages := []*Package{}
+	for _, stanza := range stanzas(data) {
+		if !strings.HasSuffix(stanza["Status"], " installed") && stanza["Status"] != "" {
+			continue
+		}
+		name, version := stanza["Package"], stanza["Version"]
+		if source := stanza["Source"]; source != "" {
+			sourceName, sourceVersion, ok := strings.Cut(source, " ")
+			name = sourceName
+			if ok {
+				version = strings.Trim(strings.TrimSpace(sourceVersion), "()")
+			}
+		}
+		if name != "" && version != "" {
+			packages = append(packages, &Package{Name: name, Version: version})
+		}
+	}
+	return packages
+}
+
+// parseApkInstalled parses the packages installed in the apk database, by their origin packages, if any
+func parseApkInstalled(data []byte) []*Package {
+	packages := []*Package{}
+	for _, stanza := range stanzas(data) {
+		name, version := stanza["P"], stanza["V"]
+		if origin := stanza["o"]; origin != "" {
+			name = origin
+		}
+		if name != "" && version != "" {
+			packages = append(packages, &Package{Name: name, Version: version})
+		}
+	}
+	return packages
+}
+
+// stanzas splits the database into the stanzas, separated by empty lines, of the fields, where the continuation lines are ignored
+func stanzas(data []byte) []map[string]string {
+	result := []map[string]string{}
+	current := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				result = append(result, current)
+				current = map[string]string{}
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			current[key] = strings.TrimSpace(value)
+		}
+	}
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+// dedupPackages removes the duplicate packages, e.g. the binary packages of the same source package
+func dedupPackages(packages []*Package) []*Package {
+	seen := map[Package]bool{}
+	result := []*Package{}
+	for _, pkg := range packages {
+		if seen[*pkg] {
+			continue
+		}
+		seen[*pkg] = true
+		result = append(result, pkg)
+	}
+	return result
+}
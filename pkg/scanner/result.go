@@ -0,0 +1,109 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Result is the vulnerabilities found in the images of every chart in the bundle
+type Result struct {
+	Charts []*ChartResult `json:"charts"`
+}
+
+// ChartResult is the vulnerabilities found in the images of a chart, with the numbers of them by severity
+type ChartResult struct {
+	Name    string         `json:"name"`
+	Version string         `json:"version"`
+	Summary Summary        `json:"summary"`
+	Images  []*ImageResult `json:"images"`
+}
+
+// Summary is the numbers of the vulnerabilities by severity
+type Summary map[Severity]int
+
+// ImageResult is the vulnerabilities found in an image, of any of its platforms, or why it's not scanned
+type ImageResult struct {
+	Ref string `json:"ref"`
+	// Distro is the ID and VERSION_ID of the os-release of the image, e.g. debian 12
+	Distro string `json:"distro,omitempty"`
+	// Manager is the package manager the packages are installed by, e.g. dpkg
+	Manager         string           `json:"manager,omitempty"`
+	Packages        int              `json:"packages"`
+	Vulnerabilities []*Vulnerability `json:"vulnerabilities"`
+	// Skipped tells why the image is not scanned, e.g. with no OS package database
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Vulnerability is a record of the vulnerability database matched by an OS package installed in an image
+type Vulnerability struct {
+	ID           string   `json:"id"`
+	Aliases      []string `json:"aliases,omitempty"`
+	Package      string   `json:"package"`
+	Version      string   `json:"version"`
+	FixedVersion string   `json:"fixedVersion,omitempty"`
+	Severity     Severity `json:"severity"`
+	Summary      string   `json:"summary,omitempty"`
+}
+
+// Errors returns the images failed to scan, e.g. corrupted, as errors
+func (r *Result) Errors() []error {
+	errs := []error{}
+	seen := map[*ImageResult]bool{}
+	for _, cr := range r.Charts {
+		for _, ir := range cr.Images {
+			if ir.Error != "" && !seen[ir] {
+				seen[ir] = true
+				errs = append(errs, fmt.Errorf("%s: %s", ir.Ref, ir.Error))
+			}
+		}
+	}
+	return errs
+}
+
+// Print prints the vulnerabilities in a human-readable way, like:
+//
+//	nginx 15.4.4: 1 critical, 2 high, 0 medium, 0 low, 1 unknown
+//	  docker.io/bitnami/nginx:1.25.3 (debian 11, 92 dpkg packages)
+//	    CRITICAL  DSA-5139-1  openssl  1.1.1n-0+deb11u1  1.1.1n-0+deb11u2  openssl - security update
+//	  docker.io/bitnami/nginx-exporter:0.11.0: skipped, no OS package database found, by dpkg, apk or rpm
+func (r *Result) Print(w io.Writer) {
+	for _, cr := range r.Charts {
+		counts := []string{}
+		for _, severity := range severities {
+			counts = append(counts, fmt.Sprintf("%d %s", cr.Summary[severity], strings.ToLower(string(severity))))
+		}
+		fmt.Fprintf(w, "%s %s: %s\n", cr.Name, cr.Version, strings.Join(counts, ", "))
+
+		for _, ir := range cr.Images {
+			switch {
+			case ir.Error != "":
+				fmt.Fprintf(w, "  %s: failed, %s\n", ir.Ref, ir.Error)
+				continue
+			case ir.Skipped != "":
+				fmt.Fprintf(w, "  %s: skipped, %s\n", ir.Ref, ir.Skipped)
+				continue
+			}
+			fmt.Fprintf(w, "  %s (%s, %d %s packages)\n", ir.Ref, ir.Distro, ir.Packages, ir.Manager)
+
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			for _, v := range ir.Vulnerabilities {
+				fmt.Fprintf(tw, "    %s\t%s\t%s\t%s\t%s\t%s\n", v.Severity, v.ID, v.Package, v.Version, v.FixedVersion, v.Summary)
+			}
+			tw.Flush()
+		}
+	}
+}
+
+// PrintJSON prints the vulnerabilities as JSON
+func (r *Result) PrintJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
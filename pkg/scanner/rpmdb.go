@@ -0,0 +1,381 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The rpm database is read without sqlite or rpm, by walking the b-tree of the Packages table in the sqlite file,
+// see https://www.sqlite.org/fileformat.html, whose rows are the rpm headers of the packages installed.
+// Only the sqlite backend, the default since rpm 4.16, e.g. of RHEL 9, is supported, but not the Berkeley DB or ndb ones.
+
+const (
+	sqliteMagic = "SQLite format 3\x00"
+
+	// the b-tree page types of the tables
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+
+	// the tags of the rpm header, see rpmtag.h
+	rpmTagName      = 1000
+	rpmTagVersion   = 1001
+	rpmTagRelease   = 1002
+	rpmTagEpoch     = 1003
+	rpmTagSourceRPM = 1044
+
+	// the types of the rpm header entries
+	rpmTypeInt32  = 4
+	rpmTypeString = 6
+)
+
+// rpmPackage is a package in the rpm database
+type rpmPackage struct {
+	name      string
+	epoch     int
+	hasEpoch  bool
+	version   string
+	release   string
+	sourceRPM string
+}
+
+// readRpmDB reads the packages from the rpm database in sqlite, e.g. /var/lib/rpm/rpmdb.sqlite
+func readRpmDB(data []byte) ([]*rpmPackage, error) {
+	db, err := openSqlite(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// the schema of the tables is in the table on the first page, by type, name, tbl_name, rootpage and sql
+	root := 0
+	err = db.walk(1, func(values []interface{}) error {
+		if len(values) >= 4 && values[0] == "table" && values[1] == "Packages" {
+			if page, ok := values[3].(int64); ok {
+				root = int(page)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root == 0 {
+		return nil, errors.New("no Packages table in the rpm database")
+	}
+
+	packages := []*rpmPackage{}
+	err = db.walk(root, func(values []interface{}) error {
+		// the Packages table is of hnum, which is the rowid, and blob
+		if len(values) < 2 {
+			return nil
+		}
+		blob, ok := values[1].([]byte)
+		if !ok {
+			return nil
+		}
+		pkg, err := parseRpmHeader(blob)
+		if err != nil {
+			return err
+		}
+		// the imported GPG keys are in the database as packages too
+		if pkg.name != "gpg-pubkey" {
+			packages = append(packages, pkg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packages, nil
+}
+
+// parseRpmHeader parses the rpm header blob, which is of the count of the index entries and the size of the data,
+// then the index entries, of the tag, type, offset and count, and then the data
+func parseRpmHeader(blob []byte) (*rpmPackage, error) {
+	if len(blob) < 8 {
+		return nil, errors.New("invalid rpm header: too short")
+	}
+	il := int(binary.BigEndian.Uint32(blob[0:4]))
+	dl := int(binary.BigEndian.Uint32(blob[4:8]))
+	start := 8 + il*16
+	if il < 0 || dl < 0 || start+dl > len(blob) {
+		return nil, errors.New("invalid rpm header: truncated")
+	}
+	data := blob[start : start+dl]
+
+	pkg := &rpmPackage{}
+	for i := 0; i < il; i++ {
+		entry := blob[8+i*16 : 8+(i+1)*16]
+		tag := binary.BigEndian.Uint32(entry[0:4])
+		typ := binary.BigEndian.Uint32(entry[4:8])
+		offset := int(int32(binary.BigEndian.Uint32(entry[8:12])))
+		if offset < 0 || offset >= len(data) {
+			continue
+		}
+
+		switch {
+		case typ == rpmTypeString:
+			value := data[offset:]
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				value = value[:end]
+			}
+			switch tag {
+			case rpmTagName:
+				pkg.name = string(value)
+			case rpmTagVersion:
+				pkg.version = string(value)
+			case rpmTagRelease:
+				pkg.release = string(value)
+			case rpmTagSourceRPM:
+				pkg.sourceRPM = string(value)
+			}
+		case typ == rpmTypeInt32 && tag == rpmTagEpoch && offset+4 <= len(data):
+			pkg.epoch = int(int32(binary.BigEndian.Uint32(data[offset : offset+4])))
+			pkg.hasEpoch = true
+		}
+	}
+	if pkg.name == "" || pkg.version == "" {
+		return nil, errors.New("invalid rpm header: no name or version")
+	}
+	return pkg, nil
+}
+
+// sqlite is a read-only sqlite file in memory, to walk its tables by the pages
+type sqlite struct {
+	data     []byte
+	pageSize int
+	usable   int
+}
+
+func openSqlite(data []byte) (*sqlite, error) {
+	if len(data) < 100 || string(data[:16]) != sqliteMagic {
+		return nil, errors.New("not a sqlite database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 {
+		return nil, fmt.Errorf("invalid sqlite page size %d", pageSize)
+	}
+	return &sqlite{data: data, pageSize: pageSize, usable: pageSize - int(data[20])}, nil
+}
+
+func (db *sqlite) page(n int) ([]byte, error) {
+	start := (n - 1) * db.pageSize
+	if n < 1 || start+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("sqlite page %d out of range", n)
+	}
+	return db.data[start : start+db.pageSize], nil
+}
+
+// walk walks the table b-tree from its root page, and calls fn with the values of every row
+func (db *sqlite) walk(root int, fn func([]interface{}) error) error {
+	visited := map[int]bool{}
+
+	var walk func(n int) error
+	walk = func(n int) error {
+		if visited[n] {
+			return fmt.Errorf("sqlite page %d visited twice", n)
+		}
+		visited[n] = true
+
+		page, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		// the first page starts with the database header
+		header := 0
+		if n == 1 {
+			header = 100
+		}
+		if header+8 > len(page) {
+			return fmt.Errorf("invalid sqlite page %d", n)
+		}
+
+		kind := page[header]
+		cells := int(binary.BigEndian.Uint16(page[header+3 : header+5]))
+		pointers := header + 8
+		if kind == sqliteInteriorTable {
+			pointers = header + 12
+		}
+		if pointers+cells*2 > len(page) {
+			return fmt.Errorf("invalid sqlite page %d", n)
+		}
+
+		for i := 0; i < cells; i++ {
+			offset := int(binary.BigEndian.Uint16(page[pointers+i*2:]))
+			if offset >= db.usable {
+				return fmt.Errorf("invalid sqlite cell in page %d", n)
+			}
+			switch kind {
+			case sqliteInteriorTable:
+				if offset+4 > len(page) {
+					return fmt.Errorf("invalid sqlite cell in page %d", n)
+				}
+				if err := walk(int(binary.BigEndian.Uint32(page[offset:]))); err != nil {
+					return err
+				}
+			case sqliteLeafTable:
+				payload, err := db.payload(page, offset)
+				if err != nil {
+					return fmt.Errorf("invalid sqlite cell in page %d: %w", n, err)
+				}
+				values, err := parseRecord(payload)
+				if err != nil {
+					return fmt.Errorf("invalid sqlite record in page %d: %w", n, err)
+				}
+				if err := fn(values); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unexpected sqlite page type %#x of page %d", kind, n)
+			}
+		}
+
+		if kind == sqliteInteriorTable {
+			return walk(int(binary.BigEndian.Uint32(page[header+8:])))
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+// payload returns the payload of the table leaf cell, of the payload size, the rowid and the payload,
+// where the payload spilled is followed by the overflow pages
+func (db *sqlite) payload(page []byte, offset int) ([]byte, error) {
+	size, n := readVarint(page[offset:])
+	if n == 0 {
+		return nil, errors.New("invalid payload size")
+	}
+	offset += n
+	if _, n = readVarint(page[offset:]); n == 0 {
+		return nil, errors.New("invalid rowid")
+	}
+	offset += n
+
+	total := int(size)
+	local := total
+	if maxLocal := db.usable - 35; total > maxLocal {
+		minLocal := (db.usable-12)*32/255 - 23
+		local = minLocal + (total-minLocal)%(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if total < 0 || offset+local > len(page) {
+		return nil, errors.New("payload out of range")
+	}
+
+	payload := make([]byte, 0, total)
+	payload = append(payload, page[offset:offset+local]...)
+	if local == total {
+		return payload, nil
+	}
+
+	// every overflow page starts with the number of the next one
+	if offset+local+4 > len(page) {
+		return nil, errors.New("overflow page out of range")
+	}
+	next := int(binary.BigEndian.Uint32(page[offset+local:]))
+	for len(payload) < total {
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := overflow[4:db.usable]
+		if remaining := total - len(payload); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+		next = int(binary.BigEndian.Uint32(overflow[0:4]))
+	}
+	return payload, nil
+}
+
+// parseRecord parses the record, of the header size, the serial types of the columns, and then their values,
+// to nil, int64, float64 bits as int64, []byte for the blobs, or string for the texts
+func parseRecord(record []byte) ([]interface{}, error) {
+	headerSize, n := readVarint(record)
+	if n == 0 || int(headerSize) > len(record) {
+		return nil, errors.New("invalid record header")
+	}
+
+	types := []uint64{}
+	for offset := n; offset < int(headerSize); {
+		t, n := readVarint(record[offset:int(headerSize)])
+		if n == 0 {
+			return nil, errors.New("invalid serial type")
+		}
+		types = append(types, t)
+		offset += n
+	}
+
+	values := []interface{}{}
+	offset := int(headerSize)
+	for _, t := range types {
+		size := 0
+		switch {
+		case t == 0, t == 8, t == 9:
+		case t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6, t == 7:
+			size = 8
+		case t >= 12:
+			size = int(t-12) / 2
+		default:
+			return nil, fmt.Errorf("invalid serial type %d", t)
+		}
+		if offset+size > len(record) {
+			return nil, errors.New("record out of range")
+		}
+		value := record[offset : offset+size]
+		offset += size
+
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t == 8, t == 9:
+			values = append(values, int64(t-8))
+		case t <= 7:
+			// the integers are big-endian two's complement, sign extended from their sizes
+			var v int64
+			if len(value) > 0 && value[0]&0x80 != 0 {
+				v = -1
+			}
+			for _, b := range value {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case t%2 == 0:
+			values = append(values, value)
+		default:
+			values = append(values, string(value))
+		}
+	}
+	return values, nil
+}
+
+// readVarint reads the sqlite varint, of 1 to 9 bytes, and returns it with the bytes read, or 0 if it's truncated
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
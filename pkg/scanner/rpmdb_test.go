@@ -0,0 +1,79 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"os"
+	"testing"
+)
+
+// testdata/rpmdb.sqlite is made by sqlite3 with the schema of rpm 4.16, 4 KiB pages, and 63 packages,
+// so that the Packages table has interior pages, and the header of glibc-all-langpacks, with a long description,
+// spills into the overflow pages, where gpg-pubkey, the imported key, is left out of the packages
+func TestReadRpmDB(t *testing.T) {
+	data, err := os.ReadFile("testdata/rpmdb.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packages, err := readRpmDB(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 63 {
+		t.Errorf("packages = %d, want 63", len(packages))
+	}
+
+	byName := map[string]*rpmPackage{}
+	for _, pkg := range packages {
+		byName[pkg.name] = pkg
+	}
+
+	tests := []struct {
+		name string
+		want *rpmPackage
+	}{
+		{"bash", &rpmPackage{name: "bash", version: "5.1.8", release: "6.el9", sourceRPM: "bash-5.1.8-6.el9.src.rpm"}},
+		{"openssl-libs", &rpmPackage{name: "openssl-libs", epoch: 1, hasEpoch: true, version: "3.0.7", release: "24.el9", sourceRPM: "openssl-3.0.7-24.el9.src.rpm"}},
+		{"glibc-all-langpacks", &rpmPackage{name: "glibc-all-langpacks", version: "2.34", release: "60.el9", sourceRPM: "glibc-2.34-60.el9.src.rpm"}},
+		{"filler59", &rpmPackage{name: "filler59", version: "1.59", release: "1.el9", sourceRPM: "filler59-1.59-1.el9.src.rpm"}},
+		{"gpg-pubkey", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := byName[tt.name]
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("package %s read, want none", tt.name)
+			case tt.want != nil && got == nil:
+				t.Errorf("package %s not read", tt.name)
+			case tt.want != nil && *got != *tt.want:
+				t.Errorf("package = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRpmDBInvalid(t *testing.T) {
+	data, err := os.ReadFile("testdata/rpmdb.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not sqlite", []byte("Berkeley DB is not supported, only the sqlite backend of rpm is read by the scanner, so it fails")},
+		{"truncated", data[:len(data)/2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readRpmDB(tt.data); err == nil {
+				t.Errorf("readRpmDB() succeeded, want an error")
+			}
+		})
+	}
+}
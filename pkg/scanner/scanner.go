@@ -0,0 +1,202 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+type Scanner struct {
+	fromDir string
+	db      *DB
+}
+
+// NewScanner creates a scanner which scans the images in the bundle directory offline
+func NewScanner(fromDir string) *Scanner {
+	return &Scanner{
+		fromDir: fromDir,
+	}
+}
+
+// WithDB scans the images against the vulnerability database of LoadDB
+func (s *Scanner) WithDB(db *DB) *Scanner {
+	s.db = db
+	return s
+}
+
+// Scan scans the OS packages installed in every image of every chart, from the image tarballs or the OCI image layouts,
+// where every platform of a multi-platform image is scanned, and the images shared by multiple charts are scanned only once
+func (s *Scanner) Scan() (*Result, error) {
+	if s.db == nil {
+		return nil, errors.New("nothing to scan against, with no vulnerability database")
+	}
+
+	manifest, err := utils.ReadBundle(s.fromDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bundle %s: %w", s.fromDir, err)
+	}
+
+	scanned := map[string]*ImageResult{}
+	result := &Result{Charts: []*ChartResult{}}
+	for _, chart := range manifest.Charts {
+		cr := &ChartResult{Name: chart.Name, Version: chart.Version, Images: []*ImageResult{}, Summary: Summary{}}
+		for _, image := range chart.Images {
+			ir, ok := scanned[image.Ref+"\x00"+image.Path]
+			if !ok {
+				ir = s.scanImage(image)
+				scanned[image.Ref+"\x00"+image.Path] = ir
+			}
+			cr.Images = append(cr.Images, ir)
+			for _, v := range ir.Vulnerabilities {
+				cr.Summary[v.Severity]++
+			}
+		}
+		result.Charts = append(result.Charts, cr)
+	}
+	return result, nil
+}
+
+// scanImage scans the image, where what fails it, or makes it unscannable, is recorded in the result
+func (s *Scanner) scanImage(image *api.ManifestImage) *ImageResult {
+	ir := &ImageResult{Ref: image.Ref, Vulnerabilities: []*Vulnerability{}}
+
+	// the image left out of the delta bundle, or with the layers left out, is in the previous bundle
+	if image.Path == "" || len(image.Omitted) > 0 {
+		ir.Skipped = "left out of the delta bundle"
+		return ir
+	}
+
+	images, err := s.platformImages(filepath.Join(s.fromDir, filepath.FromSlash(image.Path)))
+	if err != nil {
+		ir.Error = fmt.Sprintf("could not read image %s: %v", image.Path, err)
+		return ir
+	}
+
+	// the image is skipped only if none of its platforms is scannable
+	skipped, scanned := "", false
+	seen := map[string]bool{}
+	packages := map[Package]bool{}
+	for _, img := range images {
+		inv, err := readInventory(img)
+		if errors.Is(err, errNoPackages) || errors.Is(err, errUnsupported) {
+			skipped = err.Error()
+			continue
+		}
+		if err != nil {
+			ir.Error = err.Error()
+			return ir
+		}
+		if inv.ecosystem == "" {
+			skipped = fmt.Sprintf("unsupported distribution %q", inv.distro)
+			continue
+		}
+		ir.Distro, ir.Manager = inv.distro, inv.manager
+		scanned = true
+
+		for _, pkg := range inv.packages {
+			packages[*pkg] = true
+			for _, m := range s.db.lookup(inv.ecosystem, pkg.Name, pkg.Version, inv.compare) {
+				key := m.entry.ID + "\x00" + pkg.Name + "\x00" + pkg.Version
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				ir.Vulnerabilities = append(ir.Vulnerabilities, &Vulnerability{
+					ID:           m.entry.ID,
+					Aliases:      m.entry.Aliases,
+					Package:      pkg.Name,
+					Version:      pkg.Version,
+					FixedVersion: m.fixed,
+					Severity:     severity(m.entry, m.affected),
+					Summary:      m.entry.Summary,
+				})
+			}
+		}
+	}
+	ir.Packages = len(packages)
+	if !scanned {
+		ir.Skipped = skipped
+	}
+
+	sort.SliceStable(ir.Vulnerabilities, func(i, j int) bool {
+		a, b := ir.Vulnerabilities[i], ir.Vulnerabilities[j]
+		if a.Severity != b.Severity {
+			return a.Severity.rank() < b.Severity.rank()
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.ID < b.ID
+	})
+	return ir
+}
+
+// platformImages returns the image in the tarball, or the image, or the platform images of the image index,
+// in the OCI image layout, where the attestations of the image index, for the unknown platform, are skipped
+func (s *Scanner) platformImages(imgPath string) ([]v1.Image, error) {
+	if strings.HasSuffix(imgPath, ".tar") {
+		img, err := tarball.ImageFromPath(imgPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		return []v1.Image{img}, nil
+	}
+
+	lp, err := layout.FromPath(imgPath)
+	if err != nil {
+		return nil, err
+	}
+	index, err := lp.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	im, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	if len(im.Manifests) != 1 {
+		return nil, fmt.Errorf("expected 1 image in %s, but found %d", imgPath, len(im.Manifests))
+	}
+
+	desc := im.Manifests[0]
+	if !desc.MediaType.IsIndex() {
+		img, err := index.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return []v1.Image{img}, nil
+	}
+
+	ii, err := index.ImageIndex(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	iim, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	images := []v1.Image{}
+	for _, m := range iim.Manifests {
+		if !m.MediaType.IsImage() || (m.Platform != nil && m.Platform.OS == "unknown") {
+			continue
+		}
+		img, err := ii.Image(m.Digest)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
@@ -0,0 +1,155 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"math"
+	"strings"
+)
+
+// Severity is the severity of a vulnerability
+type Severity string
+
+const (
+	SeverityCritical Severity = "CRITICAL"
+	SeverityHigh     Severity = "HIGH"
+	SeverityMedium   Severity = "MEDIUM"
+	SeverityLow      Severity = "LOW"
+	SeverityUnknown  Severity = "UNKNOWN"
+)
+
+// severities are the severities from the most severe
+var severities = []Severity{SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow, SeverityUnknown}
+
+func (s Severity) rank() int {
+	for i, severity := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return len(severities)
+}
+
+// severity returns the severity of the record, by the severity of the affected entry, or of the record, e.g. by GHSA or Ubuntu,
+// or by the CVSS v3 base score, where the severities of Red Hat, e.g. Important, are mapped too
+func severity(entry *osvEntry, affected *osvAffected) Severity {
+	for _, specific := range []map[string]interface{}{affected.EcosystemSpecific, affected.DatabaseSpecific, entry.DatabaseSpecific} {
+		if s, ok := specific["severity"].(string); ok {
+			if severity := normalizeSeverity(s); severity != SeverityUnknown {
+				return severity
+			}
+		}
+	}
+
+	for _, s := range entry.Severity {
+		switch s.Type {
+		case "CVSS_V3":
+			if score, ok := cvss3BaseScore(s.Score); ok {
+				return ratingOf(score)
+			}
+		default:
+			if severity := normalizeSeverity(s.Score); severity != SeverityUnknown {
+				return severity
+			}
+		}
+	}
+	return SeverityUnknown
+}
+
+func normalizeSeverity(s string) Severity {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "CRITICAL":
+		return SeverityCritical
+	case "HIGH", "IMPORTANT":
+		return SeverityHigh
+	case "MEDIUM", "MODERATE":
+		return SeverityMedium
+	case "LOW", "NEGLIGIBLE", "UNIMPORTANT":
+		return SeverityLow
+	}
+	return SeverityUnknown
+}
+
+// ratingOf returns the qualitative severity rating of the CVSS score
+func ratingOf(score float64) Severity {
+	switch {
+	case score >= 9.0:
+		return SeverityCritical
+	case score >= 7.0:
+		return SeverityHigh
+	case score >= 4.0:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+	return SeverityUnknown
+}
+
+// cvss3BaseScore computes the base score of the CVSS v3 vector, e.g. CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H,
+// see https://www.first.org/cvss/v3.1/specification-document
+func cvss3BaseScore(vector string) (float64, bool) {
+	if !strings.HasPrefix(vector, "CVSS:3.") {
+		return 0, false
+	}
+	metrics := map[string]string{}
+	for _, part := range strings.Split(vector, "/")[1:] {
+		if key, value, ok := strings.Cut(part, ":"); ok {
+			metrics[key] = value
+		}
+	}
+
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+	values := map[string]float64{}
+	for key, weight := range weights {
+		w, ok := weight[metrics[key]]
+		if !ok {
+			return 0, false
+		}
+		values[key] = w
+	}
+
+	changed := metrics["S"] == "C"
+	if !changed && metrics["S"] != "U" {
+		return 0, false
+	}
+	pr := map[string]float64{"N": 0.85, "L": 0.62, "H": 0.27}
+	if changed {
+		pr = map[string]float64{"N": 0.85, "L": 0.68, "H": 0.5}
+	}
+	privileges, ok := pr[metrics["PR"]]
+	if !ok {
+		return 0, false
+	}
+
+	iss := 1 - (1-values["C"])*(1-values["I"])*(1-values["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	exploitability := 8.22 * values["AV"] * values["AC"] * privileges * values["UI"]
+	if impact <= 0 {
+		return 0, true
+	}
+	score := impact + exploitability
+	if changed {
+		score = 1.08 * score
+	}
+	return roundUp(math.Min(score, 10)), true
+}
+
+// roundUp rounds up to one decimal, as defined by CVSS v3.1, to avoid the floating point errors
+func roundUp(value float64) float64 {
+	i := int64(math.Round(value * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
@@ -0,0 +1,331 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// compareFunc compares two versions of a package manager, and returns -1, 0 or 1
+type compareFunc func(a, b string) int
+
+// compareDpkg compares the versions of dpkg, [epoch:]upstream_version[-debian_revision], as dpkg --compare-versions does
+func compareDpkg(a, b string) int {
+	epochA, upstreamA, revisionA := splitDpkg(a)
+	epochB, upstreamB, revisionB := splitDpkg(b)
+	if epochA != epochB {
+		return sign(epochA - epochB)
+	}
+	if c := verrevcmp(upstreamA, upstreamB); c != 0 {
+		return c
+	}
+	return verrevcmp(revisionA, revisionB)
+}
+
+func splitDpkg(version string) (int, string, string) {
+	epoch := 0
+	if e, rest, ok := strings.Cut(version, ":"); ok {
+		epoch, _ = strconv.Atoi(e)
+		version = rest
+	}
+	revision := ""
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		version, revision = version[:i], version[i+1:]
+	}
+	return epoch, version, revision
+}
+
+// verrevcmp compares the non-digit parts lexically, where ~ is before anything, even the end, and the letters are before
+// the other characters, and the digit parts numerically, in turn
+func verrevcmp(a, b string) int {
+	order := func(s string, i int) int {
+		switch {
+		case i >= len(s) || isDigit(s[i]):
+			return 0
+		case isAlpha(s[i]):
+			return int(s[i])
+		case s[i] == '~':
+			return -1
+		default:
+			return int(s[i]) + 256
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			if oa, ob := order(a, i), order(b, j); oa != ob {
+				return sign(oa - ob)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		firstDiff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+	return 0
+}
+
+// compareRpm compares the versions of rpm, [epoch:]version[-release], as rpmvercmp does, where no epoch is 0
+func compareRpm(a, b string) int {
+	epochA, versionA, releaseA := splitRpm(a)
+	epochB, versionB, releaseB := splitRpm(b)
+	if epochA != epochB {
+		return sign(epochA - epochB)
+	}
+	if c := rpmvercmp(versionA, versionB); c != 0 {
+		return c
+	}
+	// the version without release, e.g. in the ranges, is for any release
+	if releaseA == "" || releaseB == "" {
+		return 0
+	}
+	return rpmvercmp(releaseA, releaseB)
+}
+
+func splitRpm(version string) (int, string, string) {
+	epoch := 0
+	if e, rest, ok := strings.Cut(version, ":"); ok {
+		epoch, _ = strconv.Atoi(e)
+		version = rest
+	}
+	release := ""
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		version, release = version[:i], version[i+1:]
+	}
+	return epoch, version, release
+}
+
+// rpmvercmp compares the alphabetic and numeric segments in turn, where the separators are ignored, ~ is before anything,
+// even the end, ^ is after the end only, and the numeric segments are after the alphabetic ones
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && !isAlnum(a[i]) && a[i] != '~' && a[i] != '^' {
+			i++
+		}
+		for j < len(b) && !isAlnum(b[j]) && b[j] != '~' && b[j] != '^' {
+			j++
+		}
+
+		if (i < len(a) && a[i] == '~') || (j < len(b) && b[j] == '~') {
+			if i >= len(a) || a[i] != '~' {
+				return 1
+			}
+			if j >= len(b) || b[j] != '~' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		if (i < len(a) && a[i] == '^') || (j < len(b) && b[j] == '^') {
+			if i >= len(a) {
+				return -1
+			}
+			if j >= len(b) {
+				return 1
+			}
+			if a[i] != '^' {
+				return 1
+			}
+			if b[j] != '^' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+
+		numeric := isDigit(a[i])
+		is := isAlpha
+		if numeric {
+			is = isDigit
+		}
+		si, sj := i, j
+		for i < len(a) && is(a[i]) {
+			i++
+		}
+		for j < len(b) && is(b[j]) {
+			j++
+		}
+		segA, segB := a[si:i], b[sj:j]
+		if segB == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		if numeric {
+			segA, segB = strings.TrimLeft(segA, "0"), strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				return sign(len(segA) - len(segB))
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+
+	if i >= len(a) && j >= len(b) {
+		return 0
+	}
+	if i >= len(a) {
+		return -1
+	}
+	return 1
+}
+
+// apkSuffixes are the suffixes of the apk versions in their order, where the ones before the empty are pre-releases
+var apkSuffixes = []string{"alpha", "beta", "pre", "rc", "", "cvs", "svn", "git", "hg", "p"}
+
+// apkVersion is a version of apk, like 1.2.3a_rc1_p2-r4
+type apkVersion struct {
+	numbers  []string
+	letter   byte
+	suffixes [][2]int // the suffixes by their order and number
+	revision int
+}
+
+// compareApk compares the versions of apk, by the dotted numbers, the letter, the suffixes and the revision in turn,
+// or lexically if either version is invalid
+func compareApk(a, b string) int {
+	va, okA := parseApk(a)
+	vb, okB := parseApk(b)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+
+	for k := 0; k < len(va.numbers) && k < len(vb.numbers); k++ {
+		if c := compareNumeric(va.numbers[k], vb.numbers[k]); c != 0 {
+			return c
+		}
+	}
+	if len(va.numbers) != len(vb.numbers) {
+		return sign(len(va.numbers) - len(vb.numbers))
+	}
+	if va.letter != vb.letter {
+		return sign(int(va.letter) - int(vb.letter))
+	}
+
+	release := [2]int{slices.Index(apkSuffixes, ""), 0}
+	for k := 0; k < len(va.suffixes) || k < len(vb.suffixes); k++ {
+		sa, sb := release, release
+		if k < len(va.suffixes) {
+			sa = va.suffixes[k]
+		}
+		if k < len(vb.suffixes) {
+			sb = vb.suffixes[k]
+		}
+		if sa[0] != sb[0] {
+			return sign(sa[0] - sb[0])
+		}
+		if sa[1] != sb[1] {
+			return sign(sa[1] - sb[1])
+		}
+	}
+	return sign(va.revision - vb.revision)
+}
+
+func parseApk(version string) (*apkVersion, bool) {
+	v := &apkVersion{}
+	if rest, revision, ok := strings.Cut(version, "-r"); ok {
+		r, err := strconv.Atoi(revision)
+		if err != nil {
+			return nil, false
+		}
+		v.revision = r
+		version = rest
+	}
+
+	version, suffixes, _ := strings.Cut(version, "_")
+	if n := len(version); n > 0 && isAlpha(version[n-1]) {
+		v.letter = version[n-1]
+		version = version[:n-1]
+	}
+	for _, number := range strings.Split(version, ".") {
+		if number == "" || strings.TrimFunc(number, func(r rune) bool { return r >= '0' && r <= '9' }) != "" {
+			return nil, false
+		}
+		v.numbers = append(v.numbers, number)
+	}
+
+	if suffixes != "" {
+		for _, suffix := range strings.Split(suffixes, "_") {
+			name := strings.TrimRightFunc(suffix, func(r rune) bool { return r >= '0' && r <= '9' })
+			order := slices.Index(apkSuffixes, name)
+			if name == "" || order < 0 {
+				return nil, false
+			}
+			number := 0
+			if n := suffix[len(name):]; n != "" {
+				number, _ = strconv.Atoi(n)
+			}
+			v.suffixes = append(v.suffixes, [2]int{order, number})
+		}
+	}
+	return v, true
+}
+
+// compareNumeric compares two strings of digits numerically, no matter how long they are
+func compareNumeric(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || isAlpha(c)
+}
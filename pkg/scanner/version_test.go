@@ -0,0 +1,134 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package scanner
+
+import (
+	"testing"
+)
+
+type compareCase struct {
+	a, b string
+	want int
+}
+
+// testCompare checks the comparison both ways, as it must be antisymmetric
+func testCompare(t *testing.T, compare compareFunc, tests []compareCase) {
+	t.Helper()
+	for _, tt := range tests {
+		if got := compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compare(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compare(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+// the cases are from the Debian policy and lib/dpkg/t/t-version.c of dpkg
+func TestCompareDpkg(t *testing.T) {
+	testCompare(t, compareDpkg, []compareCase{
+		{"1.0", "1.0", 0},
+		{"0:1.0", "1.0", 0},
+		{"1.0-0", "1.0", 0},
+		{"1.00", "1.0", 0},
+		{"0:0-00", "0:0-0", 0},
+		{"1.0", "1.1", -1},
+		{"1.2.3", "1.2.10", -1},
+		{"1.0.0", "1.0", 1},
+		{"1:1.0", "2.0", 1},
+		{"2:0.1", "1:9.9", 1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-9", "1.0-10", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~~a", -1},
+		{"1.0~~a", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		{"1.0", "1.0a", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0+", "1.0.", -1},
+		{"a", "b", -1},
+		{"2.36-9+deb12u4", "2.36-9", 1},
+		{"3.0.11-1~deb12u2", "3.0.11-1", -1},
+		{"1.2.13.dfsg-1", "1.2.13-1", 1},
+		{"7.88.1-10+deb12u5", "7.88.1-10+deb12u12", -1},
+	})
+}
+
+// the cases are from tests/rpmvercmp.at of rpm, and the epochs and releases on top of them
+func TestCompareRpm(t *testing.T) {
+	testCompare(t, compareRpm, []compareCase{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0.1", "2.0", 1},
+		{"2.0.1a", "2.0.1", 1},
+		{"5.5p1", "5.5p2", -1},
+		{"5.5p10", "5.5p1", 1},
+		{"10xyz", "10.1xyz", -1},
+		{"xyz10", "xyz10.1", -1},
+		{"xyz.4", "8", -1},
+		{"xyz.4", "2", -1},
+		{"5.5p2", "5.6p1", -1},
+		{"5.6p1", "6.5p1", -1},
+		{"6.0.rc1", "6.0", 1},
+		{"10b2", "10a1", 1},
+		{"10a2", "10b2", -1},
+		{"1.0aa", "1.0aaa", -1},
+		{"10.0001", "10.1", 0},
+		{"10.0001", "10.0039", -1},
+		{"4.999.9", "5.0", -1},
+		{"20101121", "20101122", -1},
+		{"2_0", "2_0", 0},
+		{"2.0", "2_0", 0},
+		{"a+", "a_", 0},
+		{"+a", "_a", 0},
+		{"+_", "_+", 0},
+		{"1b.fc17", "1.fc17", -1},
+		{"1g.fc17", "1.fc17", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~rc1~git123", "1.0~rc1", -1},
+		{"1.0^", "1.0", 1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.0^git2", -1},
+		{"1.0^git1", "1.01", -1},
+		{"1.0^20160101", "1.0.1", -1},
+		{"1.0~rc1^git1", "1.0~rc1", 1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+		{"1:1.0-1.el9", "2.0-1.el9", 1},
+		{"1:3.0.7-24.el9", "1:3.0.7-25.el9", -1},
+		{"1.0-1.el9", "1.0-1.el9_2", -1},
+		// the version without release, e.g. in the ranges, is for any release
+		{"1.0", "1.0-5.el9", 0},
+	})
+}
+
+// the cases are from test/version.data of apk-tools
+func TestCompareApk(t *testing.T) {
+	testCompare(t, compareApk, []compareCase{
+		{"2.34", "0.1.0_alpha", 1},
+		{"0.1.0_alpha", "0.1.0_alpha", 0},
+		{"0.1.0_alpha", "0.1.3_alpha", -1},
+		{"0.1.0_alpha2", "0.1.0_alpha", 1},
+		{"0.1.0_alpha", "0.1.0_beta", -1},
+		{"0.1.0_beta", "0.1.0_pre", -1},
+		{"0.1.0_pre", "0.1.0_rc1", -1},
+		{"0.1.0_rc1", "0.1.0", -1},
+		{"0.1.0", "0.1.0_cvs", -1},
+		{"0.1.0_cvs", "0.1.0_svn", -1},
+		{"0.1.0_git20230101", "0.1.0", 1},
+		{"0.1.0_p1", "0.1.0", 1},
+		{"0.1.0_p1", "0.1.0_p10", -1},
+		{"1.0", "1.0.1", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0b", -1},
+		{"1.0b_rc1", "1.0a", 1},
+		{"2.2.0-r1", "2.2.0", 1},
+		{"1.2.3-r9", "1.2.3-r10", -1},
+		{"3.0.8-r0", "3.0.10-r0", -1},
+		{"3.1.4-r5", "3.1.4-r5", 0},
+		{"1.36.1-r15", "1.36.1_p1-r0", -1},
+		{"18446744073709551616", "18446744073709551615", 1},
+	})
+}
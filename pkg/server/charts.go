@@ -0,0 +1,67 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// addChart adds the chart into the index, whose url is its path in the bundle, relative to the chart repository
+func (s *Server) addChart(index *repo.IndexFile, chart *api.ManifestChart) error {
+	// the chart left out of the delta bundle is in the previous bundle
+	if chart.Path == "" {
+		utils.AddRecord(s.report, chart.Name, "delta", fmt.Sprintf("%s: left out of the bundle, not served", chart.Version))
+		return nil
+	}
+
+	chartFile := filepath.Join(s.fromDir, filepath.FromSlash(chart.Path))
+	c, err := loader.Load(chartFile)
+	if err != nil {
+		return fmt.Errorf("could not load chart %s: %w", chartFile, err)
+	}
+	digest, err := provenance.DigestFile(chartFile)
+	if err != nil {
+		return fmt.Errorf("could not digest chart %s: %w", chartFile, err)
+	}
+	if err := index.MustAdd(c.Metadata, chart.Path, "", digest); err != nil {
+		return fmt.Errorf("could not index chart %s: %w", chartFile, err)
+	}
+	s.charts[chart.Path] = chartFile
+	if chart.Prov != "" {
+		s.charts[chart.Path+".prov"] = filepath.Join(s.fromDir, filepath.FromSlash(chart.Prov))
+	}
+
+	utils.AddRecord(s.report, chart.Name, "serve", fmt.Sprintf("%s: served as /%s", chart.Version, chart.Path))
+	return nil
+}
+
+// serveCharts serves the index.yaml, and the chart files
+func (s *Server) serveCharts(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if p == "index.yaml" {
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.Write(s.index)
+		return
+	}
+
+	chartFile, ok := s.charts[p]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if strings.HasSuffix(p, ".tgz") {
+		w.Header().Set("Content-Type", "application/gzip")
+	}
+	http.ServeFile(w, r, chartFile)
+}
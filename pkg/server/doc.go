@@ -0,0 +1,6 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package server serves the bundle, pulled by the pipeline, read-only over HTTP,
+// as a Helm chart repository and an OCI registry to pull the images from
+package server
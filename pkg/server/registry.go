@@ -0,0 +1,336 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// repository represents the images of a repository, whose manifests and blobs are read from the bundle
+type repository struct {
+	// registry is the registry the images are pulled from, e.g. index.docker.io, as the repository path is served without it
+	registry string
+
+	// tags are the digests of the manifests, by tag
+	tags      map[string]string
+	manifests map[string]*manifest
+	blobs     map[string]*blob
+}
+
+type manifest struct {
+	mediaType types.MediaType
+	raw       []byte
+}
+
+// blob is read from the image tarball, or the OCI image layout, when it's served
+type blob struct {
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// addImage adds the image, and all its manifests and blobs, into its repository
+func (s *Server) addImage(chartName string, image *api.ManifestImage) error {
+	// the image left out of the delta bundle is in the previous bundle
+	if image.Path == "" {
+		utils.AddRecord(s.report, chartName, "delta", fmt.Sprintf("%s: left out of the bundle, not served", image.Ref))
+		return nil
+	}
+
+	ref, err := name.ParseReference(image.Ref)
+	if err != nil {
+		return fmt.Errorf("could not serve image %s: %w", image.Ref, err)
+	}
+	repoName := ref.Context().RepositoryStr()
+	registry := ref.Context().RegistryStr()
+	r, ok := s.repositories[repoName]
+	if !ok {
+		r = &repository{registry: registry, tags: map[string]string{}, manifests: map[string]*manifest{}, blobs: map[string]*blob{}}
+		s.repositories[repoName] = r
+	}
+	// the images of the same repository path in different registries would be mixed up
+	if r.registry != registry {
+		return fmt.Errorf("could not serve image %s, as repository %s is served for the images of %s already", image.Ref, repoName, r.registry)
+	}
+
+	imgPath := filepath.Join(s.fromDir, filepath.FromSlash(image.Path))
+	var digest string
+	if strings.HasSuffix(imgPath, ".tar") {
+		img, err := tarball.ImageFromPath(imgPath, nil)
+		if err != nil {
+			return fmt.Errorf("could not serve image %s: %w", image.Ref, err)
+		}
+		digest, err = r.addImage(img)
+		if err != nil {
+			return fmt.Errorf("could not serve image %s: %w", image.Ref, err)
+		}
+	} else {
+		digest, err = r.addLayout(imgPath)
+		if err != nil {
+			return fmt.Errorf("could not serve image %s: %w", image.Ref, err)
+		}
+	}
+
+	served := fmt.Sprintf("%s@%s", repoName, digest)
+	if tag, ok := ref.(name.Tag); ok {
+		r.tags[tag.TagStr()] = digest
+		served = fmt.Sprintf("%s:%s", repoName, tag.TagStr())
+	}
+	utils.AddRecord(s.report, chartName, "serve", fmt.Sprintf("%s: served as %s", image.Ref, served))
+
+	return nil
+}
+
+// addLayout adds the image, or the image index, in the OCI image layout, and returns its digest
+func (r *repository) addLayout(imgPath string) (string, error) {
+	lp, err := layout.FromPath(imgPath)
+	if err != nil {
+		return "", err
+	}
+	index, err := lp.ImageIndex()
+	if err != nil {
+		return "", err
+	}
+	im, err := index.IndexManifest()
+	if err != nil {
+		return "", err
+	}
+	if len(im.Manifests) != 1 {
+		return "", fmt.Errorf("expected 1 image in %s, but found %d", imgPath, len(im.Manifests))
+	}
+
+	desc := im.Manifests[0]
+	if desc.MediaType.IsIndex() {
+		ii, err := index.ImageIndex(desc.Digest)
+		if err != nil {
+			return "", err
+		}
+		return r.addIndex(ii)
+	}
+	img, err := index.Image(desc.Digest)
+	if err != nil {
+		return "", err
+	}
+	return r.addImage(img)
+}
+
+func (r *repository) addIndex(index v1.ImageIndex) (string, error) {
+	digest, err := r.addManifest(index)
+	if err != nil {
+		return "", err
+	}
+
+	im, err := index.IndexManifest()
+	if err != nil {
+		return "", err
+	}
+	for _, desc := range im.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			ii, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return "", err
+			}
+			if _, err := r.addIndex(ii); err != nil {
+				return "", err
+			}
+		case desc.MediaType.IsImage():
+			img, err := index.Image(desc.Digest)
+			if err != nil {
+				return "", err
+			}
+			if _, err := r.addImage(img); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return digest, nil
+}
+
+func (r *repository) addImage(img v1.Image) (string, error) {
+	digest, err := r.addManifest(img)
+	if err != nil {
+		return "", err
+	}
+
+	config, err := img.RawConfigFile()
+	if err != nil {
+		return "", err
+	}
+	configName, err := img.ConfigName()
+	if err != nil {
+		return "", err
+	}
+	r.blobs[configName.String()] = &blob{
+		size: int64(len(config)),
+		open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(config)), nil },
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return "", err
+	}
+	for _, layer := range layers {
+		h, err := layer.Digest()
+		if err != nil {
+			return "", err
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return "", err
+		}
+		r.blobs[h.String()] = &blob{size: size, open: layer.Compressed}
+	}
+
+	return digest, nil
+}
+
+func (r *repository) addManifest(m interface {
+	MediaType() (types.MediaType, error)
+	Digest() (v1.Hash, error)
+	RawManifest() ([]byte, error)
+}) (string, error) {
+	mediaType, err := m.MediaType()
+	if err != nil {
+		return "", err
+	}
+	digest, err := m.Digest()
+	if err != nil {
+		return "", err
+	}
+	raw, err := m.RawManifest()
+	if err != nil {
+		return "", err
+	}
+	r.manifests[digest.String()] = &manifest{mediaType: mediaType, raw: raw}
+	return digest.String(), nil
+}
+
+// serveRegistry serves the pull API of the OCI distribution spec:
+//
+//	/v2/
+//	/v2/<name>/manifests/<reference>
+//	/v2/<name>/blobs/<digest>
+//	/v2/<name>/tags/list
+func (s *Server) serveRegistry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	p := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if p == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	}
+
+	if repoName, ok := strings.CutSuffix(p, "/tags/list"); ok {
+		s.serveTags(w, repoName)
+		return
+	}
+	if i := strings.LastIndex(p, "/manifests/"); i > 0 {
+		s.serveManifest(w, r, p[:i], p[i+len("/manifests/"):])
+		return
+	}
+	if i := strings.LastIndex(p, "/blobs/"); i > 0 {
+		s.serveBlob(w, r, p[:i], p[i+len("/blobs/"):])
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "UNSUPPORTED", "unsupported API")
+}
+
+func (s *Server) serveTags(w http.ResponseWriter, repoName string) {
+	r, ok := s.repositories[repoName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", fmt.Sprintf("repository %s not found", repoName))
+		return
+	}
+
+	tags := []string{}
+	for tag := range r.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": repoName, "tags": tags})
+}
+
+func (s *Server) serveManifest(w http.ResponseWriter, req *http.Request, repoName, reference string) {
+	r, ok := s.repositories[repoName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", fmt.Sprintf("repository %s not found", repoName))
+		return
+	}
+
+	digest := reference
+	if tagged, ok := r.tags[reference]; ok {
+		digest = tagged
+	}
+	m, ok := r.manifests[digest]
+	if !ok {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", fmt.Sprintf("manifest %s not found in %s", reference, repoName))
+		return
+	}
+
+	w.Header().Set("Content-Type", string(m.mediaType))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.raw)))
+	if req.Method == http.MethodGet {
+		w.Write(m.raw)
+	}
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, req *http.Request, repoName, digest string) {
+	r, ok := s.repositories[repoName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", fmt.Sprintf("repository %s not found", repoName))
+		return
+	}
+
+	// the blob left out of the delta bundle is missing in the OCI image layout
+	b, ok := r.blobs[digest]
+	var rc io.ReadCloser
+	var err error
+	if ok {
+		rc, err = b.open()
+	}
+	if !ok || err != nil {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", fmt.Sprintf("blob %s not found in %s", digest, repoName))
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.FormatInt(b.size, 10))
+	if req.Method == http.MethodGet {
+		io.Copy(w, rc)
+	}
+}
+
+// writeError writes the error in the format of the OCI distribution spec
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+type Server struct {
+	fromDir string
+
+	// index is the index.yaml generated for the charts, and charts are the chart files, by their paths in the urls
+	index  []byte
+	charts map[string]string
+
+	// repositories are the images, by their repository paths, e.g. bitnami/nginx for docker.io/bitnami/nginx:1.25.3
+	repositories map[string]*repository
+
+	report *api.Report
+}
+
+// NewServer creates a server of the bundle directory, where the charts and images are loaded from the bundle manifest,
+// so that the charts are served by the index.yaml generated, and the images by their repository paths and tags,
+// e.g. docker.io/bitnami/nginx:1.25.3 is served as bitnami/nginx:1.25.3
+func NewServer(fromDir string) (*Server, error) {
+	manifest, err := utils.ReadBundle(fromDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bundle %s: %w", fromDir, err)
+	}
+
+	s := &Server{
+		fromDir:      fromDir,
+		charts:       map[string]string{},
+		repositories: map[string]*repository{},
+		report:       utils.NewReport(),
+	}
+
+	index := repo.NewIndexFile()
+	for _, chart := range manifest.Charts {
+		if err := s.addChart(index, chart); err != nil {
+			return nil, err
+		}
+		for _, image := range chart.Images {
+			if err := s.addImage(chart.Name, image); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	index.SortEntries()
+	if s.index, err = yaml.Marshal(index); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Report returns the report of what's served, and what's not, e.g. what's left out of a delta bundle
+func (s *Server) Report() *api.Report {
+	return s.report
+}
+
+// ServeHTTP serves the Helm chart repository, by /index.yaml and the chart files,
+// and the pull API of the OCI distribution spec, under /v2/, where nothing can be pushed
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if strings.HasPrefix(r.URL.Path, "/v2/") {
+			writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "the registry is read-only")
+			return
+		}
+		http.Error(w, "the chart repository is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/v2/") {
+		s.serveRegistry(w, r)
+		return
+	}
+	s.serveCharts(w, r)
+}
+
+// ListenAndServe serves at the address, e.g. :5000, until the context is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// testBundle writes the bundle of a chart, with an image in the OCI image layout and another in the image tarball,
+// and returns the images by their references
+func testBundle(t *testing.T, dir string) map[string]v1.Image {
+	t.Helper()
+
+	chartDir := filepath.Join(dir, "nginx", "15.4.4")
+	if err := os.MkdirAll(chartDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "nginx", Version: "15.4.4"}}
+	if _, err := chartutil.Save(c, chartDir); err != nil {
+		t.Fatal(err)
+	}
+
+	images := map[string]v1.Image{}
+	for _, ref := range []string{"docker.io/bitnami/nginx:1.25", "docker.io/bitnami/busybox:1.36"} {
+		img, err := random.Image(256, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images[ref] = img
+	}
+
+	lp, err := layout.Write(filepath.Join(chartDir, "images", "nginx"), empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lp.AppendImage(images["docker.io/bitnami/nginx:1.25"]); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := name.NewTag("docker.io/bitnami/busybox:1.36")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tarball.WriteToFile(filepath.Join(chartDir, "images", "busybox.tar"), tag, images[tag.String()]); err != nil {
+		t.Fatal(err)
+	}
+
+	m := utils.NewManifest(api.LayoutVersioned)
+	m.Charts = []*api.ManifestChart{{
+		Name:    "nginx",
+		Version: "15.4.4",
+		Path:    "nginx/15.4.4/nginx-15.4.4.tgz",
+		Images: []*api.ManifestImage{
+			{Ref: "docker.io/bitnami/nginx:1.25", Path: "nginx/15.4.4/images/nginx"},
+			{Ref: "docker.io/bitnami/busybox:1.36", Path: "nginx/15.4.4/images/busybox.tar"},
+		},
+	}}
+	if err := utils.WriteManifest(m, dir); err != nil {
+		t.Fatal(err)
+	}
+	return images
+}
+
+func TestServeCharts(t *testing.T) {
+	dir := t.TempDir()
+	testBundle(t, dir)
+	s, err := NewServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	chartFile := filepath.Join(dir, "nginx", "15.4.4", "nginx-15.4.4.tgz")
+	chartData, err := os.ReadFile(chartFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		status      int
+		contentType string
+		body        []byte
+	}{
+		{"chart", http.MethodGet, "/nginx/15.4.4/nginx-15.4.4.tgz", http.StatusOK, "application/gzip", chartData},
+		{"chart not found", http.MethodGet, "/nginx/15.5.0/nginx-15.5.0.tgz", http.StatusNotFound, "", nil},
+		{"file not a chart", http.MethodGet, "/bundle-manifest.yaml", http.StatusNotFound, "", nil},
+		{"read-only", http.MethodPut, "/nginx/15.4.4/nginx-15.4.4.tgz", http.StatusMethodNotAllowed, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.contentType != "" && resp.Header.Get("Content-Type") != tt.contentType {
+				t.Errorf("content type = %s, want %s", resp.Header.Get("Content-Type"), tt.contentType)
+			}
+			if tt.body != nil && string(body) != string(tt.body) {
+				t.Errorf("body of %d bytes, want %d bytes", len(body), len(tt.body))
+			}
+		})
+	}
+
+	t.Run("index.yaml", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/index.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+
+		var index repo.IndexFile
+		if err := yaml.Unmarshal(data, &index); err != nil {
+			t.Fatal(err)
+		}
+		cv, err := index.Get("nginx", "15.4.4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cv.URLs) != 1 || cv.URLs[0] != "nginx/15.4.4/nginx-15.4.4.tgz" {
+			t.Errorf("urls = %v, want the path relative to the chart repository", cv.URLs)
+		}
+		digest, err := provenance.DigestFile(chartFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cv.Digest != digest {
+			t.Errorf("digest = %s, want %s", cv.Digest, digest)
+		}
+	})
+}
+
+func TestServeRegistry(t *testing.T) {
+	dir := t.TempDir()
+	images := testBundle(t, dir)
+	s, err := NewServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	nginx := images["docker.io/bitnami/nginx:1.25"]
+	nginxDigest, err := nginx.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := nginx.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerDigest, err := layers[0].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		// digest is the Docker-Content-Digest expected
+		digest string
+	}{
+		{"base", http.MethodGet, "/v2/", http.StatusOK, ""},
+		{"manifest by tag", http.MethodGet, "/v2/bitnami/nginx/manifests/1.25", http.StatusOK, nginxDigest.String()},
+		{"manifest by digest", http.MethodHead, "/v2/bitnami/nginx/manifests/" + nginxDigest.String(), http.StatusOK, nginxDigest.String()},
+		{"manifest unknown", http.MethodGet, "/v2/bitnami/nginx/manifests/1.26", http.StatusNotFound, ""},
+		{"repository unknown", http.MethodGet, "/v2/bitnami/apache/manifests/2.4", http.StatusNotFound, ""},
+		{"blob", http.MethodGet, "/v2/bitnami/nginx/blobs/" + layerDigest.String(), http.StatusOK, layerDigest.String()},
+		{"blob of another repository", http.MethodGet, "/v2/bitnami/busybox/blobs/" + layerDigest.String(), http.StatusNotFound, ""},
+		{"read-only", http.MethodPut, "/v2/bitnami/nginx/manifests/1.26", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if resp.Header.Get("Docker-Content-Digest") != tt.digest {
+				t.Errorf("digest = %s, want %s", resp.Header.Get("Docker-Content-Digest"), tt.digest)
+			}
+		})
+	}
+
+	// the images are pulled as they're in the bundle, with all their blobs, from the image layout or tarball
+	for ref, img := range images {
+		t.Run("pull "+ref, func(t *testing.T) {
+			tag, err := name.NewTag(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			served, err := name.NewTag(host+"/"+tag.RepositoryStr()+":"+tag.TagStr(), name.Insecure)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pulled, err := remote.Image(served)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := img.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := pulled.Digest(); err != nil || got != want {
+				t.Errorf("digest = %s, %v, want %s", got, err, want)
+			}
+			layers, err := pulled.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, layer := range layers {
+				rc, err := layer.Compressed()
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the blobs are verified against their digests as they're read
+				_, err = io.Copy(io.Discard, rc)
+				rc.Close()
+				if err != nil {
+					t.Errorf("could not pull blob: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package spec provides the declarative bundle spec, which lists the repositories, charts, images
+// and output of a bundle, and drives the pipeline with them
+package spec
@@ -0,0 +1,123 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package spec
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/registry"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/chartloader"
+)
+
+// Loaders creates a remote chart loader per repository, with the charts from it, which pull the charts into toDir.
+// The credentials of the repositories are read from the environment variables at this point.
+func (b *Bundle) Loaders(toDir string) ([]api.ChartLoader, error) {
+	loaders := []api.ChartLoader{}
+	for _, r := range b.Repositories {
+		charts := []string{}
+		for _, c := range b.Charts {
+			if c.Repository != r.Name {
+				continue
+			}
+			chart := c.Name
+			if c.Version != "" {
+				chart = fmt.Sprintf("%s:%s", chart, c.Version)
+			}
+			if c.LastMinors > 0 {
+				chart = fmt.Sprintf("%s:last-%d-minors", chart, c.LastMinors)
+			}
+			charts = append(charts, chart)
+		}
+		if len(charts) == 0 {
+			continue
+		}
+
+		cl := chartloader.NewRemoteChartLoader(r.URL, charts, toDir).WithPlainHTTP(r.PlainHTTP)
+		if r.Auth != nil {
+			username, password, err := r.credentials()
+			if err != nil {
+				return nil, err
+			}
+			cl.WithBasicAuth(username, password)
+		}
+		if r.TLS != nil {
+			cl.WithTLS(r.TLS.CertFile, r.TLS.KeyFile, r.TLS.CAFile, r.TLS.InsecureSkipVerify)
+		}
+		loaders = append(loaders, cl)
+	}
+	return loaders, nil
+}
+
+// RegistryAuth returns the credentials of the OCI repositories by registry host,
+// so that the images from the same registries as the charts are pulled with them too.
+// The credentials are read from the environment variables at this point.
+func (b *Bundle) RegistryAuth() (map[string]api.RegistryAuth, error) {
+	auth := map[string]api.RegistryAuth{}
+	for _, r := range b.Repositories {
+		if r.Auth == nil || !registry.IsOCI(r.URL) {
+			continue
+		}
+		username, password, err := r.credentials()
+		if err != nil {
+			return nil, err
+		}
+		host, _, _ := strings.Cut(strings.TrimPrefix(r.URL, fmt.Sprintf("%s://", registry.OCIScheme)), "/")
+		auth[host] = api.RegistryAuth{Username: username, Password: password}
+	}
+	return auth, nil
+}
+
+// credentials reads the username and password of the repository from the environment variables
+func (r Repository) credentials() (string, string, error) {
+	username, ok := os.LookupEnv(r.Auth.UsernameEnv)
+	if !ok {
+		return "", "", fmt.Errorf("missing environment variable %s for the username of repository %s", r.Auth.UsernameEnv, r.Name)
+	}
+	password, ok := os.LookupEnv(r.Auth.PasswordEnv)
+	if !ok {
+		return "", "", fmt.Errorf("missing environment variable %s for the password of repository %s", r.Auth.PasswordEnv, r.Name)
+	}
+	return username, password, nil
+}
+
+// ImageFilter returns the global and per chart include and exclude patterns of the images
+func (b *Bundle) ImageFilter() api.ImageFilter {
+	filter := api.ImageFilter{
+		Include: b.Images.Include,
+		Exclude: b.Images.Exclude,
+		Charts:  map[string]api.ImageFilter{},
+	}
+	for _, c := range b.Charts {
+		if len(c.IncludeImages) > 0 || len(c.ExcludeImages) > 0 {
+			filter.Charts[c.Name] = api.ImageFilter{Include: c.IncludeImages, Exclude: c.ExcludeImages}
+		}
+	}
+	return filter
+}
+
+// ExtraImages returns the extra images per chart name
+func (b *Bundle) ExtraImages() map[string][]string {
+	extraImages := map[string][]string{}
+	for _, c := range b.Charts {
+		if len(c.ExtraImages) > 0 {
+			extraImages[c.Name] = c.ExtraImages
+		}
+	}
+	return extraImages
+}
+
+// ValuesFiles returns the values files per chart name
+func (b *Bundle) ValuesFiles() map[string][]string {
+	valuesFiles := map[string][]string{}
+	for _, c := range b.Charts {
+		if len(c.ValuesFiles) > 0 {
+			valuesFiles[c.Name] = c.ValuesFiles
+		}
+	}
+	return valuesFiles
+}
@@ -0,0 +1,171 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package spec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+const (
+	// APIVersion is the only version of the bundle spec supported so far
+	APIVersion = "helm-packager/v1alpha1"
+	// Kind is the kind of the bundle spec
+	Kind = "Bundle"
+)
+
+// Bundle represents the bundle spec, for example:
+//
+//	apiVersion: helm-packager/v1alpha1
+//	kind: Bundle
+//	repositories:
+//	  - name: bitnami
+//	    url: oci://registry-1.docker.io/bitnamicharts
+//	charts:
+//	  - name: nginx
+//	    repository: bitnami
+//	    version: ">=15.0.0 <16.0.0"
+//	    valuesFiles:
+//	      - values/nginx.yaml
+//	images:
+//	  platforms:
+//	    - linux/amd64
+//	output:
+//	  dir: ./_charts
+type Bundle struct {
+	APIVersion   string       `yaml:"apiVersion"`
+	Kind         string       `yaml:"kind"`
+	Repositories []Repository `yaml:"repositories"`
+	Charts       []Chart      `yaml:"charts"`
+	Images       Images       `yaml:"images,omitempty"`
+	Output       Output       `yaml:"output,omitempty"`
+}
+
+// Repository represents a Helm chart repository, or an OCI registry with the oci:// scheme
+type Repository struct {
+	Name      string `yaml:"name"`
+	URL       string `yaml:"url"`
+	Auth      *Auth  `yaml:"auth,omitempty"`
+	TLS       *TLS   `yaml:"tls,omitempty"`
+	PlainHTTP bool   `yaml:"plainHTTP,omitempty"`
+}
+
+// Auth represents the reference to the credentials of the repository, which are read
+// from the environment variables, so that no secret is kept in the spec
+type Auth struct {
+	UsernameEnv string `yaml:"usernameEnv"`
+	PasswordEnv string `yaml:"passwordEnv"`
+}
+
+// TLS represents the TLS settings of the repository
+type TLS struct {
+	CAFile             string `yaml:"caFile,omitempty"`
+	CertFile           string `yaml:"certFile,omitempty"`
+	KeyFile            string `yaml:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty"`
+}
+
+// Chart represents a chart of the bundle
+type Chart struct {
+	Name       string `yaml:"name"`
+	Repository string `yaml:"repository"`
+
+	// Version is the exact version, or the version constraint, e.g. ">=15.0.0 <16.0.0",
+	// where all the versions matching it are pulled, or the latest version is pulled if it's empty
+	Version string `yaml:"version,omitempty"`
+
+	// LastMinors tells to pull the latest patch of each of the last minor versions only, out of the versions matching
+	LastMinors int `yaml:"lastMinors,omitempty"`
+
+	// ValuesFiles are the values files to render the chart with before the images are extracted
+	ValuesFiles []string `yaml:"valuesFiles,omitempty"`
+
+	ExtraImages   []string `yaml:"extraImages,omitempty"`
+	IncludeImages []string `yaml:"includeImages,omitempty"`
+	ExcludeImages []string `yaml:"excludeImages,omitempty"`
+}
+
+// Images represents the settings of the images of all the charts
+type Images struct {
+	Platforms     []string `yaml:"platforms,omitempty"`
+	Include       []string `yaml:"include,omitempty"`
+	Exclude       []string `yaml:"exclude,omitempty"`
+	IncludeHooks  bool     `yaml:"includeHooks,omitempty"`
+	DropTestHooks bool     `yaml:"dropTestHooks,omitempty"`
+	CopyArtifacts bool     `yaml:"copyArtifacts,omitempty"`
+	PinDigests    bool     `yaml:"pinDigests,omitempty"`
+
+	// Policy is the image policy the images extracted from the charts must comply with
+	Policy *api.ImagePolicy `yaml:"policy,omitempty"`
+}
+
+// Output represents where and how the bundle is written
+type Output struct {
+	// Dir is the directory to write the bundle into, where only the structure is printed if it's empty
+	Dir                string `yaml:"dir,omitempty"`
+	Layout             string `yaml:"layout,omitempty"`
+	ChartFilesIncluded bool   `yaml:"chartFilesIncluded,omitempty"`
+	ChartRepoIndex     bool   `yaml:"chartRepoIndex,omitempty"`
+	ChartRepoURL       string `yaml:"chartRepoURL,omitempty"`
+	// SBOM is the format of the SBOM of the bundle to generate, spdx or cyclonedx, if any
+	SBOM string `yaml:"sbom,omitempty"`
+	// Audit is the format of the audit of the charts to print instead of the tree, table or json, if any
+	Audit string `yaml:"audit,omitempty"`
+}
+
+// Load loads and validates the bundle spec.
+// The relative paths in the spec, like the values files, are relative to the spec file.
+func Load(path string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bundle spec %s: %w", path, err)
+	}
+
+	b := &Bundle{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(b); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse bundle spec %s: %w", path, err)
+	}
+
+	if err := b.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid bundle spec %s: %w", path, err)
+	}
+
+	b.resolvePaths(filepath.Dir(path))
+
+	return b, nil
+}
+
+// resolvePaths makes the relative paths in the spec relative to baseDir
+func (b *Bundle) resolvePaths(baseDir string) {
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+
+	for i := range b.Repositories {
+		if tls := b.Repositories[i].TLS; tls != nil {
+			tls.CAFile = resolve(tls.CAFile)
+			tls.CertFile = resolve(tls.CertFile)
+			tls.KeyFile = resolve(tls.KeyFile)
+		}
+	}
+	for i := range b.Charts {
+		for j := range b.Charts[i].ValuesFiles {
+			b.Charts[i].ValuesFiles[j] = resolve(b.Charts[i].ValuesFiles[j])
+		}
+	}
+	b.Output.Dir = resolve(b.Output.Dir)
+}
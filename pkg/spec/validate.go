@@ -0,0 +1,145 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package spec
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/brightzheng100/helm-packager/pkg/audit"
+	"github.com/brightzheng100/helm-packager/pkg/sbom"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// Validate validates the bundle spec, and returns all the errors found, each with the path of the field
+func (b *Bundle) Validate() error {
+	var errs []error
+	fail := func(field, format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, a...)))
+	}
+
+	if b.APIVersion != APIVersion {
+		fail("apiVersion", "unsupported version %q, expected %q", b.APIVersion, APIVersion)
+	}
+	if b.Kind != Kind {
+		fail("kind", "unsupported kind %q, expected %q", b.Kind, Kind)
+	}
+
+	repositories := map[string]bool{}
+	for i, r := range b.Repositories {
+		field := fmt.Sprintf("repositories[%d]", i)
+		if r.Name == "" {
+			fail(field+".name", "is required")
+		} else if repositories[r.Name] {
+			fail(field+".name", "duplicate repository %q", r.Name)
+		}
+		repositories[r.Name] = true
+
+		if r.URL == "" {
+			fail(field+".url", "is required")
+		} else if u, err := url.Parse(r.URL); err != nil || u.Host == "" {
+			fail(field+".url", "invalid URL %q", r.URL)
+		} else if u.Scheme != "oci" && u.Scheme != "http" && u.Scheme != "https" {
+			fail(field+".url", "unsupported scheme %q, expected oci, http or https", u.Scheme)
+		}
+
+		if r.Auth != nil && (r.Auth.UsernameEnv == "" || r.Auth.PasswordEnv == "") {
+			fail(field+".auth", "both usernameEnv and passwordEnv are required")
+		}
+		if r.TLS != nil && (r.TLS.CertFile == "") != (r.TLS.KeyFile == "") {
+			fail(field+".tls", "both certFile and keyFile are required for the client certificate")
+		}
+	}
+
+	if len(b.Charts) == 0 {
+		fail("charts", "at least one chart is required")
+	}
+	charts := map[string]bool{}
+	for i, c := range b.Charts {
+		field := fmt.Sprintf("charts[%d]", i)
+		if c.Name == "" {
+			fail(field+".name", "is required")
+		} else if charts[c.Name] {
+			fail(field+".name", "duplicate chart %q", c.Name)
+		}
+		charts[c.Name] = true
+
+		if c.Repository == "" {
+			fail(field+".repository", "is required")
+		} else if !repositories[c.Repository] {
+			fail(field+".repository", "unknown repository %q", c.Repository)
+		}
+
+		if c.Version != "" {
+			if _, err := semver.NewConstraint(c.Version); err != nil {
+				fail(field+".version", "invalid version constraint %q: %v", c.Version, err)
+			}
+		}
+
+		if c.LastMinors < 0 {
+			fail(field+".lastMinors", "must not be negative")
+		}
+
+		validatePatterns(field+".includeImages", c.IncludeImages, fail)
+		validatePatterns(field+".excludeImages", c.ExcludeImages, fail)
+	}
+
+	for i, platform := range b.Images.Platforms {
+		if platform == utils.AllPlatforms {
+			continue
+		}
+		if _, err := v1.ParsePlatform(platform); err != nil || !strings.Contains(platform, "/") {
+			fail(fmt.Sprintf("images.platforms[%d]", i), "invalid platform %q, expected <OS>/<ARCH>[/<VARIANT>] or %s", platform, utils.AllPlatforms)
+		}
+	}
+	validatePatterns("images.include", b.Images.Include, fail)
+	validatePatterns("images.exclude", b.Images.Exclude, fail)
+	if policy := b.Images.Policy; policy != nil {
+		validatePatterns("images.policy.deniedRepositories", policy.DeniedRepositories, fail)
+		validatePatterns("images.policy.disallowedTags", policy.DisallowedTags, fail)
+		if err := utils.ValidateImagePolicy(policy); err != nil {
+			fail("images.policy.action", "%v", err)
+		}
+	}
+
+	if _, err := utils.ParseLayout(b.Output.Layout); err != nil {
+		fail("output.layout", "%v", err)
+	}
+	if b.Output.ChartRepoURL != "" && !b.Output.ChartRepoIndex {
+		fail("output.chartRepoURL", "is only used with chartRepoIndex")
+	}
+	if b.Output.SBOM != "" {
+		if _, err := sbom.ParseFormat(b.Output.SBOM); err != nil {
+			fail("output.sbom", "%v", err)
+		}
+	}
+	if b.Output.Audit != "" {
+		if _, err := audit.ParseFormat(b.Output.Audit); err != nil {
+			fail("output.audit", "%v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validatePatterns validates the regex patterns of the images, prefixed with "regex:"
+func validatePatterns(field string, patterns []string, fail func(field, format string, a ...interface{})) {
+	for i, pattern := range patterns {
+		if pattern == "" {
+			fail(fmt.Sprintf("%s[%d]", field, i), "empty pattern")
+			continue
+		}
+		if expr, ok := strings.CutPrefix(pattern, "regex:"); ok {
+			if _, err := regexp.Compile(expr); err != nil {
+				fail(fmt.Sprintf("%s[%d]", field, i), "invalid regex %q: %v", expr, err)
+			}
+		}
+	}
+}
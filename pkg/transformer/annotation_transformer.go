@@ -0,0 +1,122 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package transformer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+type annotationtransformer struct {
+	annotations   map[string]string
+	versionSuffix string
+}
+
+// NewAnnotationTransformer creates a chart transformer which stamps the annotations
+// into Chart.yaml of the charts, e.g. to tell where and when the charts were packaged.
+// The annotated chart is re-packaged with the version changed by versionSuffix, as the image transformer does.
+func NewAnnotationTransformer(annotations map[string]string, versionSuffix string) *annotationtransformer {
+	return &annotationtransformer{
+		annotations:   annotations,
+		versionSuffix: versionSuffix,
+	}
+}
+
+func (t *annotationtransformer) Transform(ctx context.Context, charts []*api.Chart, config api.Config) ([]*api.Chart, error) {
+	if len(t.annotations) == 0 {
+		return charts, nil
+	}
+
+	keys := []string{}
+	for k := range t.annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	transformed := []*api.Chart{}
+	for _, chart := range charts {
+		chartName := chart.C.Metadata.Name
+
+		if hasAnnotations(chart.C.Metadata.Annotations, t.annotations) {
+			transformed = append(transformed, chart)
+			continue
+		}
+
+		edited, err := setChartAnnotations(rawFile(chart.C, "Chart.yaml"), keys, t.annotations)
+		if err != nil {
+			return nil, fmt.Errorf("could not annotate chart %s: %w", chartName, err)
+		}
+		version, err := newVersion(chart, t.versionSuffix)
+		if err != nil {
+			return nil, fmt.Errorf("could not change the version of chart %s: %w", chartName, err)
+		}
+		if edited, err = setChartVersion(edited, version); err != nil {
+			return nil, fmt.Errorf("could not change the version of chart %s: %w", chartName, err)
+		}
+
+		c, err := rebuild(chart.C, func(name string, data []byte) ([]byte, error) {
+			if name == "Chart.yaml" {
+				return edited, nil
+			}
+			return data, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not re-load annotated chart %s: %w", chartName, err)
+		}
+
+		for _, k := range keys {
+			utils.AddRecord(config.Report, chartName, "annotate", fmt.Sprintf("%s=%s", k, t.annotations[k]))
+		}
+		recordVersion(config.Report, chart, version)
+
+		transformed = append(transformed, &api.Chart{C: c, Origin: chart.Origin, Source: chart.Source, UpstreamVersion: upstreamVersion(chart)})
+	}
+
+	return transformed, nil
+}
+
+func hasAnnotations(current, annotations map[string]string) bool {
+	for k, v := range annotations {
+		if c, ok := current[k]; !ok || c != v {
+			return false
+		}
+	}
+	return true
+}
+
+// setChartAnnotations sets the annotations in Chart.yaml, with the comments kept
+func setChartAnnotations(data []byte, keys []string, annotations map[string]string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("empty Chart.yaml")
+	}
+
+	root := doc.Content[0]
+	node := mappingValue(root, "annotations")
+	if node == nil || node.Kind != yaml.MappingNode {
+		node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "annotations"}, node)
+	}
+
+	for _, k := range keys {
+		if v := mappingValue(node, k); v != nil {
+			v.Kind, v.Tag, v.Value = yaml.ScalarNode, "!!str", annotations[k]
+			continue
+		}
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: annotations[k]})
+	}
+
+	return encode(&doc)
+}
@@ -0,0 +1,221 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package transformer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+// rebuild re-loads the chart from its raw files edited by the edit function,
+// so that the transformed chart is consistent with its raw files, which some writers rely on.
+// A file is dropped if the edit function returns nil for it.
+func rebuild(c *chart.Chart, edit func(name string, data []byte) ([]byte, error)) (*chart.Chart, error) {
+	files := []*loader.BufferedFile{}
+	for _, f := range c.Raw {
+		data, err := edit(f.Name, f.Data)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		files = append(files, &loader.BufferedFile{Name: f.Name, Data: data})
+	}
+
+	return loader.LoadFiles(files)
+}
+
+// repackArchive edits the files in the chart archive, e.g. charts/common-2.13.3.tgz, by the edit function,
+// which is given their names in the chart, e.g. values.yaml of common/values.yaml, and returns the changes made.
+// A file is dropped if the edit function returns nil for it, and the archive is re-packed only if anything is changed.
+func repackArchive(data []byte, edit func(name string, data []byte) ([]byte, []string, error)) ([]byte, []string, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := tar.NewReader(gr)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	changes := []string{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// the files are in the directory of the chart, e.g. common/values.yaml
+		if _, name, ok := strings.Cut(hdr.Name, "/"); ok && hdr.FileInfo().Mode().IsRegular() {
+			edited, c, err := edit(name, content)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not edit %s: %w", hdr.Name, err)
+			}
+			if len(c) > 0 {
+				changes = append(changes, c...)
+				if edited == nil {
+					continue
+				}
+				content = edited
+				hdr.Size = int64(len(content))
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, nil, err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(changes) == 0 {
+		return data, nil, nil
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), changes, nil
+}
+
+// newVersion returns the version of the chart re-packaged by a transformation, which is suffixed by versionSuffix,
+// starting with "+" for build metadata or "-" for pre-release, or with the patch version bumped if it's empty.
+// The version is changed once only, so the chart changed by the transformations before keeps its version.
+func newVersion(chart *api.Chart, versionSuffix string) (string, error) {
+	if chart.UpstreamVersion != "" {
+		return chart.C.Metadata.Version, nil
+	}
+
+	v, err := semver.NewVersion(chart.C.Metadata.Version)
+	if err != nil {
+		return "", err
+	}
+
+	var nv semver.Version
+	switch {
+	case versionSuffix == "":
+		nv = v.IncPatch()
+	case strings.HasPrefix(versionSuffix, "+"):
+		nv, err = v.SetMetadata(joinIdentifiers(v.Metadata(), versionSuffix[1:]))
+	case strings.HasPrefix(versionSuffix, "-"):
+		nv, err = v.SetPrerelease(joinIdentifiers(v.Prerelease(), versionSuffix[1:]))
+	default:
+		err = fmt.Errorf("version suffix %s must start with + or -", versionSuffix)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return nv.String(), nil
+}
+
+// recordVersion records the new version of the chart, if it's changed by the transformation
+func recordVersion(report *api.Report, chart *api.Chart, version string) {
+	if version != chart.C.Metadata.Version {
+		utils.AddRecord(report, chart.C.Metadata.Name, "version", fmt.Sprintf("%s -> %s", chart.C.Metadata.Version, version))
+	}
+}
+
+// upstreamVersion returns the version of the chart as it's loaded, before any transformation changes it
+func upstreamVersion(chart *api.Chart) string {
+	if chart.UpstreamVersion != "" {
+		return chart.UpstreamVersion
+	}
+	return chart.C.Metadata.Version
+}
+
+func rawFile(c *chart.Chart, name string) []byte {
+	for _, f := range c.Raw {
+		if f.Name == name {
+			return f.Data
+		}
+	}
+	return nil
+}
+
+func isString(node *yaml.Node) bool {
+	return node != nil && node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str" && node.Value != ""
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func encode(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setChartVersion sets the version in Chart.yaml, with the comments kept
+func setChartVersion(data []byte, version string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("empty Chart.yaml")
+	}
+
+	v := mappingValue(doc.Content[0], "version")
+	if v == nil {
+		return nil, fmt.Errorf("no version in Chart.yaml")
+	}
+	v.Value = version
+
+	return encode(&doc)
+}
+
+// isSubchartArchive tells whether it's the archive of a subchart, e.g. charts/common-2.13.3.tgz
+func isSubchartArchive(name string) bool {
+	return path.Ext(name) == ".tgz" && path.Base(path.Dir(name)) == "charts"
+}
+
+func joinIdentifiers(identifiers, identifier string) string {
+	if identifiers == "" {
+		return identifier
+	}
+	return identifiers + "." + identifier
+}
@@ -0,0 +1,5 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transformer provides different implementations of Helm chart transformers
+package transformer
@@ -0,0 +1,333 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package transformer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gopkg.in/yaml.v3"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+type imagetransformer struct {
+	mapping       map[string]string
+	versionSuffix string
+	pinDigests    bool
+}
+
+// pinFunc returns the image reference pinned to its digest
+type pinFunc func(imgref string) (string, error)
+
+// NewImageTransformer creates a chart transformer which rewrites the image references
+// in values.yaml by the registry mapping, e.g. docker.io=my.registry.com/mirror.
+// The rewritten chart is re-packaged with the version suffixed by versionSuffix,
+// which starts with "+" for build metadata or "-" for pre-release,
+// or with the patch version bumped if versionSuffix is empty.
+func NewImageTransformer(mapping map[string]string, versionSuffix string) *imagetransformer {
+	return &imagetransformer{
+		mapping:       mapping,
+		versionSuffix: versionSuffix,
+	}
+}
+
+// WithPinDigests pins the image references in values.yaml to their digests too, e.g. nginx:1.25.3@sha256:...,
+// which are resolved at pull time, before they're mapped to the target registry
+func (t *imagetransformer) WithPinDigests(pin bool) *imagetransformer {
+	t.pinDigests = pin
+	return t
+}
+
+// Transform rewrites the values.yaml of the charts and their subcharts, unpacked or archived.
+// The charts which have nothing to rewrite are returned as they are.
+// The registry mapping is recorded in the bundle manifest, so that the images are pushed by the same mapping.
+func (t *imagetransformer) Transform(ctx context.Context, charts []*api.Chart, config api.Config) ([]*api.Chart, error) {
+	transformed := []*api.Chart{}
+	for _, chart := range charts {
+		c, err := t.transform(ctx, chart, config)
+		if err != nil {
+			return nil, err
+		}
+		transformed = append(transformed, c)
+	}
+	if len(t.mapping) > 0 && config.Manifest != nil {
+		config.Manifest.RegistryMapping = t.mapping
+	}
+	return transformed, nil
+}
+
+func (t *imagetransformer) transform(ctx context.Context, chart *api.Chart, config api.Config) (*api.Chart, error) {
+	chartName := chart.C.Metadata.Name
+
+	// the digests resolved are shared with the images writers, so that the images are pulled by the same digests
+	var pin pinFunc
+	if t.pinDigests {
+		options := []remote.Option{
+			remote.WithContext(ctx),
+			remote.WithAuthFromKeychain(utils.Keychain(config.RegistryAuth)),
+			remote.WithTransport(utils.NewRetryTransport(remote.DefaultTransport, config.Retry, config.Report, chartName)),
+		}
+		pin = func(imgref string) (string, error) {
+			// the values which are not image references, e.g. templated ones, are kept as they are
+			if _, err := name.ParseReference(imgref); err != nil {
+				return imgref, nil
+			}
+			digest, err := utils.ResolveDigest(imgref, config.Pins, options)
+			if err != nil {
+				return "", fmt.Errorf("could not pin %s to its digest: %w", imgref, err)
+			}
+			return utils.PinnedRef(imgref, digest), nil
+		}
+	}
+
+	rewrites := []string{}
+	edited := map[string][]byte{}
+	for _, f := range chart.C.Raw {
+		rewritten, changes, err := t.rewriteFile(f.Name, f.Data, pin)
+		if err != nil {
+			return nil, fmt.Errorf("could not rewrite %s of chart %s: %w", f.Name, chartName, err)
+		}
+		if len(changes) > 0 {
+			edited[f.Name] = rewritten
+			rewrites = append(rewrites, changes...)
+		}
+	}
+
+	if len(rewrites) == 0 {
+		return chart, nil
+	}
+
+	version, err := newVersion(chart, t.versionSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("could not change the version of chart %s: %w", chartName, err)
+	}
+	if edited["Chart.yaml"], err = setChartVersion(rawFile(chart.C, "Chart.yaml"), version); err != nil {
+		return nil, fmt.Errorf("could not change the version of chart %s: %w", chartName, err)
+	}
+
+	c, err := rebuild(chart.C, func(name string, data []byte) ([]byte, error) {
+		if e, ok := edited[name]; ok {
+			return e, nil
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not re-load rewritten chart %s: %w", chartName, err)
+	}
+
+	for _, rewrite := range rewrites {
+		utils.AddRecord(config.Report, chartName, "rewrite", rewrite)
+	}
+	recordVersion(config.Report, chart, version)
+
+	// the images are still extracted from the chart before rewriting, as they're not in the target registry yet
+	origin := chart.Origin
+	if origin == nil {
+		origin = chart.C
+	}
+
+	return &api.Chart{C: c, Origin: origin, Source: chart.Source, UpstreamVersion: upstreamVersion(chart)}, nil
+}
+
+// rewriteFile rewrites the values.yaml of the chart or any of its unpacked subcharts,
+// or the ones in the archive of a subchart, e.g. charts/common-2.13.3.tgz, which is re-packed then
+func (t *imagetransformer) rewriteFile(name string, data []byte, pin pinFunc) ([]byte, []string, error) {
+	switch {
+	case isValuesFile(name):
+		return t.rewriteValues(data, pin)
+	case isSubchartArchive(name):
+		return t.rewriteArchive(data, pin)
+	}
+	return data, nil, nil
+}
+
+// rewriteArchive rewrites the values.yaml files in the chart archive, and re-packs it with everything else kept as it is
+func (t *imagetransformer) rewriteArchive(data []byte, pin pinFunc) ([]byte, []string, error) {
+	return repackArchive(data, func(name string, data []byte) ([]byte, []string, error) {
+		return t.rewriteFile(name, data, pin)
+	})
+}
+
+// rewriteValues rewrites the image references in the values file, with the comments kept
+func (t *imagetransformer) rewriteValues(data []byte, pin pinFunc) ([]byte, []string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := t.rewriteNode(&doc, "", pin)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changes) == 0 {
+		return data, nil, nil
+	}
+
+	rewritten, err := encode(&doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rewritten, changes, nil
+}
+
+// rewriteNode walks through the node and rewrites these image references:
+//   - image: docker.io/bitnami/apache:2.4.58
+//   - image: {registry: docker.io, repository: bitnami/apache, tag: 2.4.58}
+//   - global: {imageRegistry: docker.io}
+//
+// where the image references are pinned to their digests first, if pin is set
+func (t *imagetransformer) rewriteNode(node *yaml.Node, parentKey string, pin pinFunc) ([]string, error) {
+	changes := []string{}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, n := range node.Content {
+			c, err := t.rewriteNode(n, parentKey, pin)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, c...)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			var c []string
+			var err error
+			switch {
+			case key.Value == "image" && isString(value):
+				rewritten, err := t.rewriteRef(value.Value, pin)
+				if err != nil {
+					return nil, err
+				}
+				if rewritten != value.Value {
+					c = []string{fmt.Sprintf("%s -> %s", value.Value, rewritten)}
+					value.Value = rewritten
+				}
+			case parentKey == "global" && key.Value == "imageRegistry" && isString(value):
+				// the registry is mapped to the host only, as the namespace of the target, if any,
+				// prefixes the repositories of the image objects, which the registry overrides the registries of
+				if mapped, ok := utils.MapRegistry(value.Value, t.mapping); ok {
+					host, _, _ := strings.Cut(mapped, "/")
+					if host != value.Value {
+						c = []string{fmt.Sprintf("%s -> %s", value.Value, host)}
+						value.Value = host
+					}
+				}
+			case value.Kind == yaml.MappingNode && isString(mappingValue(value, "repository")):
+				c, err = t.rewriteImageObject(value, pin)
+			default:
+				c, err = t.rewriteNode(value, key.Value, pin)
+			}
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, c...)
+		}
+	}
+
+	return changes, nil
+}
+
+// rewriteRef pins the image reference to its digest, if pin is set, and maps it to the target registry
+func (t *imagetransformer) rewriteRef(imgref string, pin pinFunc) (string, error) {
+	rewritten := imgref
+	if pin != nil {
+		pinned, err := pin(imgref)
+		if err != nil {
+			return "", err
+		}
+		rewritten = pinned
+	}
+	if mapped, ok := utils.MapImageRef(rewritten, t.mapping); ok {
+		rewritten = mapped
+	}
+	return rewritten, nil
+}
+
+// rewriteImageObject rewrites the image object with "repository" and optional "registry",
+// which is pinned to its digest first, if pin is set.
+// The registry is mapped to the host of the target, while the namespace of the target, if any, prefixes the repository,
+// e.g. {registry: my.registry.com, repository: mirror/bitnami/apache} with the mapping docker.io=my.registry.com/mirror.
+func (t *imagetransformer) rewriteImageObject(node *yaml.Node, pin pinFunc) ([]string, error) {
+	changes := []string{}
+	if pin != nil {
+		change, err := pinImageObject(node, pin)
+		if err != nil {
+			return nil, err
+		}
+		if change != "" {
+			changes = append(changes, change)
+		}
+	}
+
+	registry := mappingValue(node, "registry")
+	repository := mappingValue(node, "repository")
+
+	imgref := repository.Value
+	if isString(registry) {
+		imgref = fmt.Sprintf("%s/%s", registry.Value, repository.Value)
+	}
+
+	mapped, ok := utils.MapImageRef(imgref, t.mapping)
+	if !ok {
+		return changes, nil
+	}
+
+	if isString(registry) {
+		target, _ := utils.MapRegistry(registry.Value, t.mapping)
+		host, _, _ := strings.Cut(target, "/")
+		registry.Value = host
+		repository.Value = strings.TrimPrefix(mapped, host+"/")
+	} else {
+		repository.Value = mapped
+	}
+
+	return append(changes, fmt.Sprintf("%s -> %s", imgref, mapped)), nil
+}
+
+// pinImageObject pins the image object with "tag" to its digest, by its "digest" if it has one, e.g. the Bitnami charts,
+// or by appending the digest to its "tag" otherwise. The image object without tag, or pinned already, is kept as it is.
+func pinImageObject(node *yaml.Node, pin pinFunc) (string, error) {
+	registry := mappingValue(node, "registry")
+	repository := mappingValue(node, "repository")
+	tag := mappingValue(node, "tag")
+	digest := mappingValue(node, "digest")
+	if !isString(tag) || isString(digest) || strings.Contains(tag.Value, "@") {
+		return "", nil
+	}
+
+	imgref := fmt.Sprintf("%s:%s", repository.Value, tag.Value)
+	if isString(registry) {
+		imgref = fmt.Sprintf("%s/%s", registry.Value, imgref)
+	}
+	pinned, err := pin(imgref)
+	if err != nil || pinned == imgref {
+		return "", err
+	}
+
+	_, d, _ := strings.Cut(pinned, "@")
+	if digest != nil {
+		digest.Value, digest.Tag, digest.Style = d, "!!str", 0
+	} else {
+		tag.Value = fmt.Sprintf("%s@%s", tag.Value, d)
+	}
+	return fmt.Sprintf("%s -> %s", imgref, pinned), nil
+}
+
+// isValuesFile tells whether it's the values.yaml of the chart or any of its unpacked subcharts
+func isValuesFile(name string) bool {
+	if path.Base(name) != "values.yaml" {
+		return false
+	}
+	dir := path.Dir(name)
+	return dir == "." || path.Base(path.Dir(dir)) == "charts"
+}
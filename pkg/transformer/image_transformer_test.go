@@ -0,0 +1,188 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package transformer
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+var testMapping = map[string]string{"docker.io": "my.registry.com/mirror"}
+
+// the values rewritten by the mapping, with the comments kept, where the image of quay.io isn't mapped,
+// and the templated one isn't an image reference
+const rewrittenValues = `# the values of a chart like the Bitnami ones
+global:
+  imageRegistry: my.registry.com
+image:
+  registry: my.registry.com
+  repository: mirror/bitnami/nginx
+  tag: 1.25.3-debian-11-r1 # the tag of nginx
+  digest: ""
+exporter:
+  image: my.registry.com/mirror/bitnami/nginx-exporter:0.11.0
+sidecars:
+  - name: tool
+    image: quay.io/other/tool:1.0
+templated:
+  image: "{{ .Values.image.repository }}"
+`
+
+func TestRewriteValues(t *testing.T) {
+	data, err := os.ReadFile("testdata/values.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		mapping map[string]string
+		want    string
+		changes []string
+	}{
+		{"mapped", testMapping, rewrittenValues, []string{
+			"docker.io -> my.registry.com",
+			"docker.io/bitnami/nginx -> my.registry.com/mirror/bitnami/nginx",
+			"docker.io/bitnami/nginx-exporter:0.11.0 -> my.registry.com/mirror/bitnami/nginx-exporter:0.11.0",
+		}},
+		{"not mapped", map[string]string{"gcr.io": "my.registry.com"}, string(data), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewritten, changes, err := NewImageTransformer(tt.mapping, "+mirror").rewriteValues(data, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(rewritten) != tt.want {
+				t.Errorf("rewritten values =\n%s\nwant\n%s", rewritten, tt.want)
+			}
+			if !slices.Equal(changes, tt.changes) {
+				t.Errorf("changes = %q, want %q", changes, tt.changes)
+			}
+		})
+	}
+}
+
+// testChart loads the chart of the values fixture, with the subchart of the same values archived in charts/
+func testChart(t *testing.T, version string) *api.Chart {
+	t.Helper()
+
+	values, err := os.ReadFile("testdata/values.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "common", Version: "2.13.3"},
+		Raw:      []*chart.File{{Name: "values.yaml", Data: values}},
+	}
+	if sub.Values, err = chartutil.ReadValues(values); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	archive, err := chartutil.Save(sub, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loader.LoadFiles([]*loader.BufferedFile{
+		{Name: "Chart.yaml", Data: []byte("apiVersion: v2\nname: nginx\n# the version of nginx\nversion: " + version + "\n")},
+		{Name: "values.yaml", Data: values},
+		{Name: "charts/common-2.13.3.tgz", Data: data},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &api.Chart{C: c}
+}
+
+func TestImageTransform(t *testing.T) {
+	tests := []struct {
+		name          string
+		version       string
+		versionSuffix string
+		wantVersion   string
+	}{
+		{"build metadata", "15.4.4", "+mirror", "15.4.4+mirror"},
+		{"build metadata appended", "15.4.4+build.1", "+mirror", "15.4.4+build.1.mirror"},
+		{"pre-release", "15.4.4", "-mirror", "15.4.4-mirror"},
+		{"patch bumped", "15.4.4", "", "15.4.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart := testChart(t, tt.version)
+			config := api.Config{Report: utils.NewReport(), Manifest: &api.Manifest{}}
+
+			transformed, err := NewImageTransformer(testMapping, tt.versionSuffix).Transform(context.Background(), []*api.Chart{chart}, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := transformed[0]
+
+			if c.C.Metadata.Version != tt.wantVersion {
+				t.Errorf("version = %s, want %s", c.C.Metadata.Version, tt.wantVersion)
+			}
+			if c.UpstreamVersion != tt.version {
+				t.Errorf("upstream version = %s, want %s", c.UpstreamVersion, tt.version)
+			}
+			if c.Origin != chart.C {
+				t.Errorf("origin is not the chart before rewriting")
+			}
+			want := "apiVersion: v2\nname: nginx\n# the version of nginx\nversion: " + tt.wantVersion + "\n"
+			if got := string(rawFile(c.C, "Chart.yaml")); got != want {
+				t.Errorf("Chart.yaml =\n%s\nwant\n%s", got, want)
+			}
+			if got := string(rawFile(c.C, "values.yaml")); got != rewrittenValues {
+				t.Errorf("values.yaml =\n%s\nwant\n%s", got, rewrittenValues)
+			}
+
+			// the archived subchart is re-packed with its values rewritten, and its version kept
+			deps := c.C.Dependencies()
+			if len(deps) != 1 {
+				t.Fatalf("subcharts = %d, want 1", len(deps))
+			}
+			if got := string(rawFile(deps[0], "values.yaml")); got != rewrittenValues {
+				t.Errorf("values.yaml of subchart =\n%s\nwant\n%s", got, rewrittenValues)
+			}
+			if deps[0].Metadata.Version != "2.13.3" {
+				t.Errorf("version of subchart = %s, want 2.13.3", deps[0].Metadata.Version)
+			}
+
+			if !slices.ContainsFunc(config.Report.Records, func(r *api.Record) bool {
+				return r.Kind == "version" && r.Message == tt.version+" -> "+tt.wantVersion
+			}) {
+				t.Errorf("version change not recorded in %v", config.Report.Records)
+			}
+			if config.Manifest.RegistryMapping["docker.io"] != testMapping["docker.io"] {
+				t.Errorf("registry mapping = %v, want %v", config.Manifest.RegistryMapping, testMapping)
+			}
+		})
+	}
+}
+
+func TestImageTransformUnchanged(t *testing.T) {
+	chart := testChart(t, "15.4.4")
+	config := api.Config{Report: utils.NewReport()}
+
+	transformed, err := NewImageTransformer(map[string]string{"gcr.io": "my.registry.com"}, "+mirror").Transform(context.Background(), []*api.Chart{chart}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transformed[0] != chart {
+		t.Errorf("chart with nothing to rewrite is re-packaged")
+	}
+}
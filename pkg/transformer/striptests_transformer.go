@@ -0,0 +1,174 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package transformer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+var (
+	hookAnnotation    = regexp.MustCompile(`(?m)^\s*["']?helm\.sh/hook["']?\s*:\s*["']?([^"'#\n]*)`)
+	documentSeparator = regexp.MustCompile(`(?m)^---.*$`)
+)
+
+type stripteststransformer struct {
+	versionSuffix string
+}
+
+// NewStripTestsTransformer creates a chart transformer which strips the test templates under templates/tests/,
+// and the documents of the other templates annotated with the test hook, e.g. helm.sh/hook: pre-install,test,
+// from the charts and their subcharts, unpacked or archived, as `helm test` is hardly run in the air-gapped env.
+// The stripped chart is re-packaged with the version changed by versionSuffix, as the image transformer does.
+func NewStripTestsTransformer(versionSuffix string) *stripteststransformer {
+	return &stripteststransformer{
+		versionSuffix: versionSuffix,
+	}
+}
+
+func (t *stripteststransformer) Transform(ctx context.Context, charts []*api.Chart, config api.Config) ([]*api.Chart, error) {
+	transformed := []*api.Chart{}
+	for _, chart := range charts {
+		c, err := t.transform(chart, config)
+		if err != nil {
+			return nil, err
+		}
+		transformed = append(transformed, c)
+	}
+
+	return transformed, nil
+}
+
+func (t *stripteststransformer) transform(chart *api.Chart, config api.Config) (*api.Chart, error) {
+	chartName := chart.C.Metadata.Name
+
+	stripped := []string{}
+	edited := map[string][]byte{}
+	for _, f := range chart.C.Raw {
+		kept, s, err := stripFile(f.Name, f.Data)
+		if err != nil {
+			return nil, fmt.Errorf("could not strip %s of chart %s: %w", f.Name, chartName, err)
+		}
+		if len(s) > 0 {
+			edited[f.Name] = kept
+			stripped = append(stripped, s...)
+		}
+	}
+
+	if len(stripped) == 0 {
+		return chart, nil
+	}
+
+	version, err := newVersion(chart, t.versionSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("could not change the version of chart %s: %w", chartName, err)
+	}
+	if edited["Chart.yaml"], err = setChartVersion(rawFile(chart.C, "Chart.yaml"), version); err != nil {
+		return nil, fmt.Errorf("could not change the version of chart %s: %w", chartName, err)
+	}
+
+	c, err := rebuild(chart.C, func(name string, data []byte) ([]byte, error) {
+		if e, ok := edited[name]; ok {
+			return e, nil
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not re-load stripped chart %s: %w", chartName, err)
+	}
+
+	for _, name := range stripped {
+		utils.AddRecord(config.Report, chartName, "strip", name)
+	}
+	recordVersion(config.Report, chart, version)
+
+	return &api.Chart{C: c, Origin: chart.Origin, Source: chart.Source, UpstreamVersion: upstreamVersion(chart)}, nil
+}
+
+// stripFile strips the test templates, or the test documents of the templates, of the chart or any of its subcharts,
+// or the ones in the archive of a subchart, which is re-packed then, and returns what's stripped.
+// The file is dropped if nil is returned with what's stripped.
+func stripFile(name string, data []byte) ([]byte, []string, error) {
+	switch {
+	case isSubchartArchive(name):
+		kept, stripped, err := repackArchive(data, stripFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, s := range stripped {
+			stripped[i] = fmt.Sprintf("%s in %s", s, name)
+		}
+		return kept, stripped, nil
+	case !isTemplate(name):
+		return data, nil, nil
+	case isTestsDir(name):
+		return nil, []string{name}, nil
+	}
+
+	kept, tests, total := stripTestDocuments(data)
+	switch {
+	case tests == 0:
+		return data, nil, nil
+	case tests == total:
+		return nil, []string{name}, nil
+	default:
+		return kept, []string{fmt.Sprintf("%s (%d of %d documents)", name, tests, total)}, nil
+	}
+}
+
+func isTemplate(name string) bool {
+	return strings.HasPrefix(name, "templates/") || strings.Contains(name, "/templates/")
+}
+
+func isTestsDir(name string) bool {
+	return strings.HasPrefix(name, "templates/tests/") || strings.Contains(name, "/templates/tests/")
+}
+
+// stripTestDocuments drops the documents of a template annotated as test hooks,
+// i.e. with test or test-success among the events of helm.sh/hook,
+// and returns the template left with the number of the documents dropped and of all documents
+func stripTestDocuments(data []byte) ([]byte, int, int) {
+	bounds := documentSeparator.FindAllIndex(data, -1)
+	starts := []int{0}
+	for _, b := range bounds {
+		starts = append(starts, b[0])
+	}
+
+	kept := []byte{}
+	tests, total := 0, 0
+	for i, start := range starts {
+		end := len(data)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		doc := data[start:end]
+		if len(strings.TrimSpace(documentSeparator.ReplaceAllString(string(doc), ""))) == 0 {
+			kept = append(kept, doc...)
+			continue
+		}
+		total++
+		if isTestHook(doc) {
+			tests++
+			continue
+		}
+		kept = append(kept, doc...)
+	}
+	return kept, tests, total
+}
+
+func isTestHook(doc []byte) bool {
+	for _, m := range hookAnnotation.FindAllSubmatch(doc, -1) {
+		for _, event := range strings.Split(string(m[1]), ",") {
+			if e := strings.TrimSpace(event); e == "test" || e == "test-success" {
+				return true
+			}
+		}
+	}
+	return false
+}
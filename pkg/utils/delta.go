@@ -0,0 +1,77 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// FindChart finds the chart of the same version in the bundle of the manifest, or nil if there isn't.
+// The digests aren't compared, as the chart archives are timestamped when they're saved,
+// while the chart versions are immutable.
+func FindChart(m *api.Manifest, chartName, chartVersion string) *api.ManifestChart {
+	if m == nil {
+		return nil
+	}
+	for _, c := range m.Charts {
+		if c.Name == chartName && c.Version == chartVersion {
+			return c
+		}
+	}
+	return nil
+}
+
+// HasImage tells whether the image of the digest is in the bundle of the manifest.
+// The blobs omitted by a delta bundle count too, as they're in the bundles it's relative to.
+func HasImage(m *api.Manifest, digest string) bool {
+	if m == nil {
+		return false
+	}
+	for _, c := range m.Charts {
+		for _, image := range c.Images {
+			if image.Digest != "" && image.Digest == digest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Blobs returns the digests of the image blobs in the bundle of the manifest, including the ones omitted,
+// mapped to the images they're from
+func Blobs(m *api.Manifest) map[string]string {
+	blobs := map[string]string{}
+	if m == nil {
+		return blobs
+	}
+	for _, c := range m.Charts {
+		for _, image := range c.Images {
+			for _, blob := range image.Blobs {
+				if _, ok := blobs[blob]; !ok {
+					blobs[blob] = image.Ref
+				}
+			}
+		}
+	}
+	// the blobs omitted are from the images in the previous bundles
+	for blob, origin := range m.Origins {
+		blobs[blob] = origin
+	}
+	return blobs
+}
+
+// AddManifestOrigin adds the image in the previous bundles, which the blob left out of the delta bundle is from
+func AddManifestOrigin(m *api.Manifest, blob, imgref string) {
+	if m == nil {
+		return
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	if m.Origins == nil {
+		m.Origins = map[string]string{}
+	}
+	m.Origins[blob] = imgref
+}
@@ -0,0 +1,107 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// AllPlatforms is the platform which tells to keep all the platforms of the images
+const AllPlatforms = "all"
+
+// IsMultiPlatform tells whether the images are kept with multiple platforms,
+// in which case they're saved as OCI image layouts instead of tarballs
+func IsMultiPlatform(platforms []string) bool {
+	return len(platforms) > 1 || (len(platforms) == 1 && platforms[0] == AllPlatforms)
+}
+
+// IsOCILayout tells whether the images are saved as OCI image layouts instead of tarballs,
+// which is when they're kept with multiple platforms, or in a delta bundle, which leaves some blobs out,
+// or with their artifacts, which are for the image manifests, or the image indexes, as they're pulled,
+// or pinned to their digests, which must be kept as they're pulled
+func IsOCILayout(config api.Config) bool {
+	return IsMultiPlatform(config.Platforms) || config.Since != nil || config.CopyArtifacts || config.PinDigests
+}
+
+// ImageFileName returns the file name of the saved image, e.g. apache-2.4.58-debian-11-r1.tar,
+// or apache-2.4.58-debian-11-r1.oci, which is an OCI image layout directory
+func ImageFileName(imgref string, ociLayout bool) string {
+	nametag := strings.Split(filepath.Base(imgref), ":")
+	if len(nametag) == 1 {
+		nametag = append(nametag, "latest")
+	}
+
+	ext := "tar"
+	if ociLayout {
+		ext = "oci"
+	}
+
+	return fmt.Sprintf("%s-%s.%s", nametag[0], nametag[1], ext)
+}
+
+// ArtifactsFileName returns the file name of the artifacts of the image, e.g. apache-2.4.58-debian-11-r1.artifacts.oci,
+// which is an OCI image layout directory next to the image
+func ArtifactsFileName(imgref string) string {
+	return strings.TrimSuffix(ImageFileName(imgref, true), ".oci") + ".artifacts.oci"
+}
+
+// dockerHubAliases are the aliases of Docker Hub registry, which are normalized as docker.io
+var dockerHubAliases = []string{name.DefaultRegistry, "registry-1.docker.io", "docker.io"}
+
+// NormalizeRegistry returns the normalized registry name, e.g. index.docker.io becomes docker.io
+func NormalizeRegistry(registry string) string {
+	for _, alias := range dockerHubAliases {
+		if registry == alias {
+			return "docker.io"
+		}
+	}
+	return registry
+}
+
+// MapRegistry maps the registry to the target by the registry mapping.
+// The keys of the mapping are the source registries, or "*" for any registry,
+// and the values are the target registries with optional namespace, e.g. my.registry.com/mirror
+func MapRegistry(registry string, mapping map[string]string) (string, bool) {
+	registry = NormalizeRegistry(registry)
+	for source, target := range mapping {
+		if NormalizeRegistry(source) == registry {
+			return strings.TrimSuffix(target, "/"), true
+		}
+	}
+	if target, ok := mapping["*"]; ok {
+		return strings.TrimSuffix(target, "/"), true
+	}
+	return "", false
+}
+
+// MapImageRef maps the image reference to the target registry by the registry mapping,
+// while keeping its repository path and tag or digest as is, e.g.
+// docker.io/bitnami/apache:2.4.58 becomes my.registry.com/mirror/bitnami/apache:2.4.58
+// with the mapping docker.io=my.registry.com/mirror
+func MapImageRef(imgref string, mapping map[string]string) (string, bool) {
+	ref, err := name.ParseReference(imgref)
+	if err != nil {
+		return imgref, false
+	}
+
+	target, ok := MapRegistry(ref.Context().RegistryStr(), mapping)
+	if !ok {
+		return imgref, false
+	}
+
+	// keep the tag and/or digest as they are in the original reference
+	suffix := ""
+	lastSegment := imgref[strings.LastIndex(imgref, "/")+1:]
+	if i := strings.IndexAny(lastSegment, ":@"); i >= 0 {
+		suffix = lastSegment[i:]
+	}
+
+	return fmt.Sprintf("%s/%s%s", target, ref.Context().RepositoryStr(), suffix), true
+}
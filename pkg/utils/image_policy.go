@@ -0,0 +1,47 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// LoadImagePolicy loads and validates the image policy file, see api.ImagePolicy for an example
+func LoadImagePolicy(path string) (*api.ImagePolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image policy %s: %w", path, err)
+	}
+	defer f.Close()
+
+	policy := &api.ImagePolicy{}
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(policy); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse image policy %s: %w", path, err)
+	}
+
+	if err := ValidateImagePolicy(policy); err != nil {
+		return nil, fmt.Errorf("invalid image policy %s: %w", path, err)
+	}
+
+	return policy, nil
+}
+
+// ValidateImagePolicy validates the action of the image policy
+func ValidateImagePolicy(policy *api.ImagePolicy) error {
+	switch policy.Action {
+	case "", api.PolicyFail, api.PolicyWarn:
+		return nil
+	default:
+		return fmt.Errorf("unsupported action %q, expected %s or %s", policy.Action, api.PolicyFail, api.PolicyWarn)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// LoadImagesFile loads the images file, for example:
+//
+//	include:
+//	  - docker.io/bitnami/*
+//	exclude:
+//	  - regex:.*-debug:.*
+//	charts:
+//	  nginx:
+//	    exclude:
+//	      - docker.io/bitnami/nginx-exporter:*
+func LoadImagesFile(path string) (*api.ImagesFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open images file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	imagesFile := &api.ImagesFile{}
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(imagesFile); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse images file %s: %w", path, err)
+	}
+
+	return imagesFile, nil
+}
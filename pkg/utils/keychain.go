@@ -0,0 +1,34 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"github.com/google/go-containerregistry/pkg/authn"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+type registryKeychain struct {
+	auth map[string]api.RegistryAuth
+}
+
+// Keychain returns the keychain of the image registries, which resolves the credentials of the registries in auth first,
+// and the ones of docker login otherwise
+func Keychain(auth map[string]api.RegistryAuth) authn.Keychain {
+	if len(auth) == 0 {
+		return authn.DefaultKeychain
+	}
+	return authn.NewMultiKeychain(&registryKeychain{auth: auth}, authn.DefaultKeychain)
+}
+
+// Resolve resolves the credentials of the registry, where the aliases of Docker Hub are regarded as the same
+func (k *registryKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	registry := NormalizeRegistry(target.RegistryStr())
+	for host, auth := range k.auth {
+		if NormalizeRegistry(host) == registry {
+			return &authn.Basic{Username: auth.Username, Password: auth.Password}, nil
+		}
+	}
+	return authn.Anonymous, nil
+}
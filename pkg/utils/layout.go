@@ -0,0 +1,32 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// ChartDir returns the directory of the chart's version following the layout, i.e. ${toDir}/${chartName}/${chartVersion},
+// so that multiple versions of a chart don't overwrite each other, or ${toDir}/${chartName} for the flat layout
+func ChartDir(toDir string, layout api.Layout, chartName, chartVersion string) string {
+	if layout == api.LayoutFlat {
+		return filepath.Join(toDir, chartName)
+	}
+	return filepath.Join(toDir, chartName, chartVersion)
+}
+
+// ParseLayout parses the layout, where empty means the default one, api.LayoutVersioned
+func ParseLayout(layout string) (api.Layout, error) {
+	switch api.Layout(layout) {
+	case "":
+		return api.LayoutVersioned, nil
+	case api.LayoutVersioned, api.LayoutFlat:
+		return api.Layout(layout), nil
+	default:
+		return "", fmt.Errorf("unsupported layout %q, expected %q or %q", layout, api.LayoutVersioned, api.LayoutFlat)
+	}
+}
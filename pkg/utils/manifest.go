@@ -0,0 +1,259 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart/loader"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+const (
+	// ManifestFileName is the file name of the bundle manifest in the bundle directory
+	ManifestFileName = "bundle-manifest.yaml"
+	// ManifestKind is the kind of the bundle manifest
+	ManifestKind = "BundleManifest"
+
+	manifestAPIVersion = "helm-packager/v1alpha1"
+)
+
+// manifestMu guards the manifest, which is added to by multiple writers
+var manifestMu sync.Mutex
+
+func NewManifest(layout api.Layout) *api.Manifest {
+	return &api.Manifest{
+		APIVersion: manifestAPIVersion,
+		Kind:       ManifestKind,
+		Layout:     layout,
+		Charts:     []*api.ManifestChart{},
+	}
+}
+
+// AddManifestChart adds the chart file, relative to the bundle directory, into the manifest if there is
+func AddManifestChart(m *api.Manifest, chartName, chartVersion, path, digest string) {
+	if m == nil {
+		return
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	c := manifestChart(m, chartName, chartVersion)
+	c.Path = filepath.ToSlash(path)
+	c.Digest = digest
+}
+
+// AddManifestProv adds the .prov file of the chart, relative to the bundle directory, into the manifest if there is
+func AddManifestProv(m *api.Manifest, chartName, chartVersion, prov string) {
+	if m == nil {
+		return
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	c := manifestChart(m, chartName, chartVersion)
+	c.Prov = filepath.ToSlash(prov)
+}
+
+// AddManifestSource adds where the chart is loaded from into the manifest if there is, and if it's known
+func AddManifestSource(m *api.Manifest, chartName, chartVersion, source string) {
+	if m == nil || source == "" {
+		return
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	c := manifestChart(m, chartName, chartVersion)
+	c.Source = source
+}
+
+// AddManifestUpstreamVersion adds the version of the chart as it's loaded into the manifest, if it's been changed
+func AddManifestUpstreamVersion(m *api.Manifest, chartName, chartVersion, upstreamVersion string) {
+	if m == nil || upstreamVersion == "" {
+		return
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	c := manifestChart(m, chartName, chartVersion)
+	c.UpstreamVersion = upstreamVersion
+}
+
+// AddManifestImage adds the image, with its file relative to the bundle directory, into the manifest
+// if there is, where the source is empty for the images extracted from the chart
+func AddManifestImage(m *api.Manifest, chartName, chartVersion string, image *api.ManifestImage) {
+	if m == nil {
+		return
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	c := manifestChart(m, chartName, chartVersion)
+	for _, existing := range c.Images {
+		if existing.Ref == image.Ref {
+			return
+		}
+	}
+	image.Path = filepath.ToSlash(image.Path)
+	c.Images = append(c.Images, image)
+}
+
+// manifestChart finds or adds the chart in the manifest
+func manifestChart(m *api.Manifest, chartName, chartVersion string) *api.ManifestChart {
+	for _, c := range m.Charts {
+		if c.Name == chartName && c.Version == chartVersion {
+			return c
+		}
+	}
+	c := &api.ManifestChart{Name: chartName, Version: chartVersion}
+	m.Charts = append(m.Charts, c)
+	return c
+}
+
+// WriteManifest writes the manifest into the bundle directory, with the charts sorted by name and version
+func WriteManifest(m *api.Manifest, dir string) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	sort.SliceStable(m.Charts, func(i, j int) bool {
+		if m.Charts[i].Name != m.Charts[j].Name {
+			return m.Charts[i].Name < m.Charts[j].Name
+		}
+		return m.Charts[i].Version < m.Charts[j].Version
+	})
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(m); err != nil {
+		return fmt.Errorf("could not encode bundle manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to mkdir %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, ManifestFileName)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("could not write bundle manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadManifest loads the bundle manifest
+func LoadManifest(path string) (*api.Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bundle manifest %s: %w", path, err)
+	}
+
+	m := &api.Manifest{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("could not parse bundle manifest %s: %w", path, err)
+	}
+	if m.Kind != ManifestKind {
+		return nil, fmt.Errorf("invalid bundle manifest %s: unexpected kind %q", path, m.Kind)
+	}
+	if _, err := ParseLayout(string(m.Layout)); err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// ReadBundle reads the manifest of the bundle directory.
+// For the legacy bundles without manifest, which are laid out flat in ${chartName}/,
+// the manifest is made up from the chart and image files found, where the directory may be named otherwise than the chart.
+func ReadBundle(dir string) (*api.Manifest, error) {
+	path := filepath.Join(dir, ManifestFileName)
+	if _, err := os.Stat(path); err == nil {
+		return LoadManifest(path)
+	}
+
+	m := NewManifest(api.LayoutFlat)
+	chartFiles, err := filepath.Glob(filepath.Join(dir, "*", "*.tgz"))
+	if err != nil {
+		return nil, err
+	}
+	for _, chartFile := range chartFiles {
+		c, err := loader.Load(chartFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load chart %s: %w", chartFile, err)
+		}
+		chartDir := filepath.Base(filepath.Dir(chartFile))
+
+		mc := &api.ManifestChart{
+			Name:    c.Metadata.Name,
+			Version: c.Metadata.Version,
+			Path:    filepath.ToSlash(filepath.Join(chartDir, filepath.Base(chartFile))),
+		}
+		images, _ := os.ReadDir(filepath.Join(dir, chartDir, "images"))
+		for _, image := range images {
+			if !strings.HasSuffix(image.Name(), ".tar") && !strings.HasSuffix(image.Name(), ".oci") {
+				continue
+			}
+			imgPath := filepath.Join(chartDir, "images", image.Name())
+			imgref, err := imageRef(filepath.Join(dir, imgPath))
+			if err != nil {
+				return nil, fmt.Errorf("could not read image %s: %w", imgPath, err)
+			}
+			if imgref == "" {
+				return nil, fmt.Errorf("could not tell the reference of image %s, which has none saved", imgPath)
+			}
+			mc.Images = append(mc.Images, &api.ManifestImage{Ref: imgref, Path: filepath.ToSlash(imgPath)})
+		}
+		m.Charts = append(m.Charts, mc)
+	}
+
+	if len(m.Charts) == 0 {
+		return nil, fmt.Errorf("no bundle manifest %s, nor any chart in %s", ManifestFileName, dir)
+	}
+	return m, nil
+}
+
+// imageRef reads the image reference saved in the image tarball, or in the annotations of the OCI image layout,
+// which is empty if there is none
+func imageRef(path string) (string, error) {
+	if strings.HasSuffix(path, ".oci") {
+		idx, err := layout.ImageIndexFromPath(path)
+		if err != nil {
+			return "", err
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return "", err
+		}
+		for _, desc := range im.Manifests {
+			if ref, ok := desc.Annotations[specsv1.AnnotationRefName]; ok {
+				return ref, nil
+			}
+		}
+		return "", nil
+	}
+
+	m, err := tarball.LoadManifest(func() (io.ReadCloser, error) { return os.Open(path) })
+	if err != nil {
+		return "", err
+	}
+	// the images pulled by digest have no tag saved
+	if len(m) == 0 || len(m[0].RepoTags) == 0 {
+		return "", nil
+	}
+	return m[0].RepoTags[0], nil
+}
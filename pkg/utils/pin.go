@@ -0,0 +1,49 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ResolveDigest resolves the image reference to the digest it points to, e.g. of the image index of a multi-platform image,
+// which is cached in pins by the image reference, if it's not nil, so that every image is resolved only once.
+// The image reference pinned by digest already resolves to its digest as it is.
+func ResolveDigest(imgref string, pins map[string]string, options []remote.Option) (string, error) {
+	if _, digest, ok := strings.Cut(imgref, "@"); ok {
+		return digest, nil
+	}
+	if digest, ok := pins[imgref]; ok {
+		return digest, nil
+	}
+
+	ref, err := name.ParseReference(imgref)
+	if err != nil {
+		return "", err
+	}
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		// some registries don't support HEAD of the manifests, as crane digest falls back
+		d, gerr := remote.Get(ref, options...)
+		if gerr != nil {
+			return "", gerr
+		}
+		desc = &d.Descriptor
+	}
+
+	if pins != nil {
+		pins[imgref] = desc.Digest.String()
+	}
+	return desc.Digest.String(), nil
+}
+
+// PinnedRef returns the image reference pinned to the digest, with the tag kept for readability,
+// e.g. docker.io/bitnami/nginx:1.25.3@sha256:...
+func PinnedRef(imgref, digest string) string {
+	ref, _, _ := strings.Cut(imgref, "@")
+	return ref + "@" + digest
+}
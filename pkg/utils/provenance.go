@@ -0,0 +1,109 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"helm.sh/helm/v3/pkg/provenance"
+)
+
+// Verification represents the provenance of a chart archive verified
+type Verification struct {
+	// SignedBy are the identities of the key which signs the chart archive
+	SignedBy []string
+	// Fingerprint is the fingerprint of the key, in hex
+	Fingerprint string
+	// FileHash is the hash of the chart archive, e.g. sha256:...
+	FileHash string
+}
+
+// DefaultKeyring returns the keyring Helm verifies the charts with by default, $GNUPGHOME/pubring.gpg, or ~/.gnupg/pubring.gpg
+func DefaultKeyring() string {
+	if v, ok := os.LookupEnv("GNUPGHOME"); ok {
+		return filepath.Join(v, "pubring.gpg")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gnupg", "pubring.gpg")
+}
+
+// VerifyChart verifies the chart archive against its .prov file, by the public keys in the keyring
+func VerifyChart(chartFile, provFile, keyring string) (*Verification, error) {
+	sig, err := provenance.NewFromKeyring(keyring, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not load keyring %s: %w", keyring, err)
+	}
+	v, err := sig.Verify(chartFile, provFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify %s: %w", filepath.Base(chartFile), err)
+	}
+
+	return FromProvenance(v), nil
+}
+
+// FromProvenance converts the verification of Helm
+func FromProvenance(v *provenance.Verification) *Verification {
+	names := []string{}
+	for name := range v.SignedBy.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &Verification{
+		SignedBy:    names,
+		Fingerprint: fmt.Sprintf("%X", v.SignedBy.PrimaryKey.Fingerprint),
+		FileHash:    v.FileHash,
+	}
+}
+
+// NewSigner creates the signer of the charts, by the private key of the name in the keyring,
+// the same way as `helm package --sign` does, where the passphrase is needed only if the key is encrypted
+func NewSigner(keyring, key string, passphrase []byte) (*provenance.Signatory, error) {
+	signer, err := provenance.NewFromKeyring(keyring, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not load keyring %s: %w", keyring, err)
+	}
+	err = signer.DecryptKey(func(name string) ([]byte, error) {
+		if passphrase == nil {
+			return nil, fmt.Errorf("key %s is encrypted, but no passphrase is given", name)
+		}
+		return passphrase, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not use key %q of keyring %s: %w", key, keyring, err)
+	}
+	return signer, nil
+}
+
+// SignChart signs the chart archive, with the .prov file written next to it, and returns the .prov file
+func SignChart(signer *provenance.Signatory, chartFile string) (string, error) {
+	if signer == nil || signer.Entity == nil {
+		return "", errors.New("no key to sign with")
+	}
+	sig, err := signer.ClearSign(chartFile)
+	if err != nil {
+		return "", fmt.Errorf("could not sign %s: %w", filepath.Base(chartFile), err)
+	}
+	if err := os.WriteFile(chartFile+".prov", []byte(sig), 0644); err != nil {
+		return "", err
+	}
+	return chartFile + ".prov", nil
+}
+
+// Signers returns the identities of the key of the signer, sorted
+func Signers(signer *provenance.Signatory) []string {
+	names := []string{}
+	for name := range signer.Entity.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
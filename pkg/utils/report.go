@@ -0,0 +1,90 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// reportMu guards the report, which may be added to concurrently, e.g. by the retries of image layers
+var reportMu sync.Mutex
+
+func NewReport() *api.Report {
+	return &api.Report{}
+}
+
+// AddRecord adds a record of what has been done to the chart into the report,
+// unless there is an identical one, e.g. added by another writer already
+func AddRecord(r *api.Report, chartName, kind, message string) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+
+	for _, record := range r.Records {
+		if record.Chart == chartName && record.Kind == kind && record.Message == message {
+			return
+		}
+	}
+
+	r.Records = append(r.Records, &api.Record{
+		Chart:   chartName,
+		Kind:    kind,
+		Message: message,
+	})
+}
+
+// RecordFailures records every single failure into the report, where the joined errors are flattened,
+// and the failures of the charts and images are recorded against the charts
+func RecordFailures(r *api.Report, failures []error) {
+	for _, err := range FlattenErrors(failures) {
+		var ce *api.ChartError
+		var ie *api.ImageError
+		switch {
+		case errors.As(err, &ie):
+			AddRecord(r, ie.Chart, "error", fmt.Sprintf("%s %s: %v", ie.Stage, ie.Image, ie.Err))
+		case errors.As(err, &ce):
+			AddRecord(r, ce.Chart, "error", fmt.Sprintf("%s: %v", ce.Stage, ce.Err))
+		default:
+			AddRecord(r, "", "error", err.Error())
+		}
+	}
+}
+
+// FlattenErrors flattens the joined errors into single ones
+func FlattenErrors(errs []error) []error {
+	flattened := []error{}
+	for _, err := range errs {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			flattened = append(flattened, FlattenErrors(joined.Unwrap())...)
+			continue
+		}
+		flattened = append(flattened, err)
+	}
+	return flattened
+}
+
+// PrintReport prints the report as a table, if there is any record
+func PrintReport(r *api.Report) {
+	FprintReport(os.Stdout, r)
+}
+
+// FprintReport prints the report as a table into the writer, e.g. os.Stderr when the output is something else
+func FprintReport(out io.Writer, r *api.Report) {
+	if len(r.Records) == 0 {
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHART\tKIND\tMESSAGE")
+	for _, record := range r.Records {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", record.Chart, record.Kind, record.Message)
+	}
+	w.Flush()
+}
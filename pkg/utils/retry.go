@@ -0,0 +1,178 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// DefaultRetryPolicy returns the default retry policy, which retries 3 times at most
+func DefaultRetryPolicy() api.RetryPolicy {
+	return api.RetryPolicy{
+		Attempts:        3,
+		Backoff:         time.Second,
+		MaxBackoff:      30 * time.Second,
+		Jitter:          0.2,
+		HonorRetryAfter: true,
+	}
+}
+
+// IsTransient tells whether the error is worth retrying, which is a network timeout, a connection reset,
+// or a registry error of a transient HTTP status, but never a cancellation
+func IsTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return IsTransientStatus(transportErr.StatusCode)
+	}
+	return false
+}
+
+// IsTransientStatus tells whether the HTTP status is worth retrying, e.g. 429 Too Many Requests
+func IsTransientStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryDelay returns how long to wait before the next attempt, which is what the Retry-After header
+// of the response tells if it's honored, or the exponential backoff with jitter otherwise,
+// where neither is longer than the max backoff if it's set
+func RetryDelay(policy api.RetryPolicy, attempt int, resp *http.Response) time.Duration {
+	if policy.HonorRetryAfter && resp != nil {
+		if delay, ok := retryAfter(resp); ok {
+			if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+				delay = policy.MaxBackoff
+			}
+			return max(delay, 0)
+		}
+	}
+
+	delay := policy.Backoff
+	for i := 1; i < attempt && (policy.MaxBackoff <= 0 || delay < policy.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	if policy.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * policy.Jitter * float64(delay))
+	}
+
+	return delay
+}
+
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type retryTransport struct {
+	inner     http.RoundTripper
+	policy    api.RetryPolicy
+	report    *api.Report
+	chartName string
+}
+
+// NewRetryTransport wraps the transport so that every request is retried on network errors
+// and transient HTTP statuses, like 429 and 503, by the retry policy
+func NewRetryTransport(inner http.RoundTripper, policy api.RetryPolicy, report *api.Report, chartName string) http.RoundTripper {
+	return &retryTransport{
+		inner:     inner,
+		policy:    policy,
+		report:    report,
+		chartName: chartName,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.inner.RoundTrip(req)
+
+		retryable := false
+		reason := ""
+		switch {
+		case err != nil:
+			retryable, reason = IsTransient(err), err.Error()
+		case IsTransientStatus(resp.StatusCode):
+			retryable, reason = true, resp.Status
+		}
+		// the request can't be retried if its body can't be rewound
+		if !retryable || attempt >= t.policy.Attempts || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+
+		delay := RetryDelay(t.policy, attempt, resp)
+		AddRecord(t.report, t.chartName, "retry", fmt.Sprintf("%s %s: attempt %d failed, retrying in %s: %s", req.Method, req.URL.Redacted(), attempt, delay, reason))
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+type timeoutError struct{ timeout bool }
+
+func (e timeoutError) Error() string   { return "i/o timeout" }
+func (e timeoutError) Timeout() bool   { return e.timeout }
+func (e timeoutError) Temporary() bool { return e.timeout }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"canceled", fmt.Errorf("pull: %w", context.Canceled), false},
+		{"deadline exceeded", &url.Error{Op: "Get", URL: "https://r", Err: context.DeadlineExceeded}, false},
+		{"unexpected EOF", &url.Error{Op: "Get", URL: "https://r", Err: io.ErrUnexpectedEOF}, true},
+		{"connection reset", &url.Error{Op: "Get", URL: "https://r", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, true},
+		{"timeout", &url.Error{Op: "Get", URL: "https://r", Err: timeoutError{timeout: true}}, true},
+		{"no timeout", &url.Error{Op: "Get", URL: "https://r", Err: timeoutError{timeout: false}}, false},
+		{"dns not found", &url.Error{Op: "Get", URL: "https://r", Err: &net.DNSError{Err: "no such host", Name: "r", IsNotFound: true}}, false},
+		{"tls verification", &url.Error{Op: "Get", URL: "https://r", Err: errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority")}, false},
+		{"registry 503", &transport.Error{StatusCode: http.StatusServiceUnavailable}, true},
+		{"registry 429", fmt.Errorf("pull: %w", &transport.Error{StatusCode: http.StatusTooManyRequests}), true},
+		{"registry 401", &transport.Error{StatusCode: http.StatusUnauthorized}, false},
+		{"registry 404", &transport.Error{StatusCode: http.StatusNotFound}, false},
+		{"status-like version", errors.New("chart nginx 15.0.429 not found"), false},
+		{"status in message", errors.New("failed to fetch https://r/index.yaml : 503 Service Unavailable"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := api.RetryPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: 5 * time.Second, HonorRetryAfter: true}
+	retryAfter := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+	retryAfterDay := &http.Response{Header: http.Header{"Retry-After": []string{"86400"}}}
+	retryAfterDate := &http.Response{Header: http.Header{"Retry-After": []string{time.Now().Add(48 * time.Hour).UTC().Format(http.TimeFormat)}}}
+	retryAfterPast := &http.Response{Header: http.Header{"Retry-After": []string{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}}}
+
+	tests := []struct {
+		name    string
+		policy  api.RetryPolicy
+		attempt int
+		resp    *http.Response
+		want    time.Duration
+	}{
+		{"first", policy, 1, nil, time.Second},
+		{"doubled", policy, 3, nil, 4 * time.Second},
+		{"capped", policy, 5, nil, 5 * time.Second},
+		{"retry-after honored", policy, 1, retryAfter, 3 * time.Second},
+		{"retry-after capped", policy, 1, retryAfterDay, 5 * time.Second},
+		{"retry-after date capped", policy, 1, retryAfterDate, 5 * time.Second},
+		{"retry-after date past", policy, 1, retryAfterPast, 0},
+		{"retry-after uncapped", api.RetryPolicy{Attempts: 5, Backoff: time.Second, HonorRetryAfter: true}, 1, retryAfterDay, 24 * time.Hour},
+		{"retry-after ignored", api.RetryPolicy{Attempts: 5, Backoff: time.Second}, 1, retryAfter, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RetryDelay(tt.policy, tt.attempt, tt.resp); got != tt.want {
+				t.Errorf("RetryDelay() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// faultyRegistry stands in for a registry, which fails the first requests of the repository faulty by the fault,
+// once armed, and serves the rest by an in-process registry
+type faultyRegistry struct {
+	registry http.Handler
+	armed    atomic.Bool
+	failures int32
+	fault    func(w http.ResponseWriter)
+	requests atomic.Int32
+}
+
+func (r *faultyRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.armed.Load() && strings.HasPrefix(req.URL.Path, "/v2/faulty/") && r.requests.Add(1) <= r.failures {
+		r.fault(w)
+		return
+	}
+	r.registry.ServeHTTP(w, req)
+}
+
+func TestRetryTransport(t *testing.T) {
+	status := func(code int, header ...string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			for i := 0; i+1 < len(header); i += 2 {
+				w.Header().Set(header[i], header[i+1])
+			}
+			w.WriteHeader(code)
+		}
+	}
+	reset := func(w http.ResponseWriter) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		}
+	}
+
+	tests := []struct {
+		name       string
+		failures   int32
+		fault      func(w http.ResponseWriter)
+		policy     api.RetryPolicy
+		wantStatus int
+		wantErr    bool
+		retries    int
+	}{
+		{"503 retried", 2, status(http.StatusServiceUnavailable), api.RetryPolicy{Attempts: 3}, http.StatusOK, false, 2},
+		{"429 retried after", 1, status(http.StatusTooManyRequests, "Retry-After", "1"), api.RetryPolicy{Attempts: 3, HonorRetryAfter: true}, http.StatusOK, false, 1},
+		{"connection reset retried", 1, reset, api.RetryPolicy{Attempts: 3}, http.StatusOK, false, 1},
+		{"connection reset out of attempts", 2, reset, api.RetryPolicy{Attempts: 2}, 0, true, 1},
+		{"502 out of attempts", 3, status(http.StatusBadGateway), api.RetryPolicy{Attempts: 3}, http.StatusBadGateway, false, 2},
+		{"401 not retried", 1, status(http.StatusUnauthorized), api.RetryPolicy{Attempts: 3}, http.StatusUnauthorized, false, 0},
+		{"404 not retried", 1, status(http.StatusNotFound), api.RetryPolicy{Attempts: 3}, http.StatusNotFound, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			faulty := &faultyRegistry{
+				registry: registry.New(registry.Logger(log.New(io.Discard, "", 0))),
+				failures: tt.failures,
+				fault:    tt.fault,
+			}
+			server := httptest.NewServer(faulty)
+			defer server.Close()
+
+			img, err := random.Image(64, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://")+"/faulty/image:1.0", name.Insecure)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := remote.Write(ref, img); err != nil {
+				t.Fatal(err)
+			}
+			faulty.armed.Store(true)
+
+			// the connections are not kept alive, so that the resets are not retried by net/http itself
+			report := &api.Report{}
+			client := &http.Client{Transport: NewRetryTransport(&http.Transport{DisableKeepAlives: true}, tt.policy, report, "chart")}
+			start := time.Now()
+			resp, err := client.Get(server.URL + "/v2/faulty/image/manifests/1.0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if resp != nil {
+				resp.Body.Close()
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+				}
+			}
+			if len(report.Records) != tt.retries {
+				t.Errorf("got %d retries recorded, want %d", len(report.Records), tt.retries)
+			}
+			if tt.policy.HonorRetryAfter && time.Since(start) < time.Second {
+				t.Errorf("retried in %s, before Retry-After", time.Since(start))
+			}
+		})
+	}
+}
@@ -5,8 +5,6 @@ package utils
 
 import (
 	"fmt"
-	"path/filepath"
-	"strings"
 
 	"github.com/brightzheng100/helm-packager/pkg/api"
 	"github.com/xlab/treeprint"
@@ -29,24 +27,66 @@ func NewTree(t *api.Tree) *api.Tree {
 	return &api.Tree{T: tree}
 }
 
-func AddChart(t *api.Tree, chartName, fileName string) {
-	t.T.AddBranch(chartName).AddNode(fileName)
+// AddChart adds the chart file into the chart's branch, which follows the layout.
+// The branches and nodes are added only once, even if there are multiple writers.
+func AddChart(t *api.Tree, layout api.Layout, chartName, chartVersion, fileName string) {
+	addNodeOnce(chartBranch(t, layout, chartName, chartVersion), fileName)
 }
 
-func AddChartFiles(t *api.Tree, chartName string, files []*chart.File) {
-	chartBranch := t.T.AddBranch("chart")
+// AddFile adds the file into the root, e.g. the ones laid out flat
+func AddFile(t *api.Tree, fileName string) {
+	addNodeOnce(t.T, fileName)
+}
+
+func AddChartFiles(t *api.Tree, layout api.Layout, chartName, chartVersion string, files []*chart.File) {
+	filesBranch := findOrAddBranch(chartBranch(t, layout, chartName, chartVersion), "chart")
 	for _, f := range files {
-		chartBranch.AddNode(f.Name)
+		addNodeOnce(filesBranch, f.Name)
 	}
 }
 
-func AddChartImages(t *api.Tree, chartName string, images []string) {
-	chartBranch := t.T.FindByValue(chartName)
-	imageBranch := chartBranch.AddBranch("images")
+func AddChartImages(t *api.Tree, layout api.Layout, chartName, chartVersion string, images []string, ociLayout bool) {
+	imageBranch := findOrAddBranch(chartBranch(t, layout, chartName, chartVersion), "images")
 
 	for _, imgref := range images {
-		nametag := strings.Split(filepath.Base(imgref), ":")
-		imageBranch.AddNode(fmt.Sprintf("%s-%s.tar (%s)", nametag[0], nametag[1], imgref))
+		addNodeOnce(imageBranch, fmt.Sprintf("%s (%s)", ImageFileName(imgref, ociLayout), imgref))
+	}
+}
+
+// AddChartImagesFrom adds the images, which are not extracted from the chart, tagged with where they're from
+func AddChartImagesFrom(t *api.Tree, layout api.Layout, chartName, chartVersion string, images []string, ociLayout bool, source string) {
+	imageBranch := findOrAddBranch(chartBranch(t, layout, chartName, chartVersion), "images")
+
+	for _, imgref := range images {
+		addNodeOnce(imageBranch, fmt.Sprintf("%s (%s) [%s]", ImageFileName(imgref, ociLayout), imgref, source))
+	}
+}
+
+// AddChartImageArtifacts adds the artifacts of the image, which are saved next to it
+func AddChartImageArtifacts(t *api.Tree, layout api.Layout, chartName, chartVersion, imgref string) {
+	imageBranch := findOrAddBranch(chartBranch(t, layout, chartName, chartVersion), "images")
+	addNodeOnce(imageBranch, ArtifactsFileName(imgref))
+}
+
+// chartBranch finds or adds the branch of the chart, i.e. ${chartName}/${chartVersion}, or ${chartName} for the flat layout
+func chartBranch(t *api.Tree, layout api.Layout, chartName, chartVersion string) treeprint.Tree {
+	branch := findOrAddBranch(t.T, chartName)
+	if layout == api.LayoutFlat {
+		return branch
+	}
+	return findOrAddBranch(branch, chartVersion)
+}
+
+func findOrAddBranch(t treeprint.Tree, value string) treeprint.Tree {
+	if branch := t.FindByValue(value); branch != nil {
+		return branch
+	}
+	return t.AddBranch(value)
+}
+
+func addNodeOnce(t treeprint.Tree, value string) {
+	if t.FindByValue(value) == nil {
+		t.AddNode(value)
 	}
 }
 
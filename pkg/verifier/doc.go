@@ -0,0 +1,6 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package verifier verifies the bundle offline, e.g. the cosign signatures of the images, copied with their artifacts,
+// by the public keys only, without any access to Rekor or Fulcio
+package verifier
@@ -0,0 +1,221 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+const (
+	// simpleSigningMediaType is the media type of the layers of the cosign signatures, i.e. the payloads signed
+	simpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// signatureAnnotation is the annotation of the layer with the base64 encoded signature of the payload
+	signatureAnnotation = "dev.cosignproject.cosign/signature"
+	// signatureType is the type of the payloads of the cosign signatures of the images
+	signatureType = "cosign container image signature"
+)
+
+// ErrUnsigned tells the image has no signature in the bundle, e.g. it's pulled without --copy-artifacts
+var ErrUnsigned = errors.New("unsigned")
+
+// payload is the simple signing payload of a cosign signature, which tells the digest of the image signed
+type payload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// signature is a signature of a cosign signature artifact, with the payload signed
+type signature struct {
+	payload []byte
+	sig     []byte
+}
+
+// VerifyImage verifies the cosign signatures of the image, copied into the bundle directory with its artifacts,
+// against the digest of the image in the bundle, by any of the public keys, and returns the name of the key.
+// The image index is verified by its own signatures, or by the signatures of every platform image in it if it has none.
+func VerifyImage(fromDir string, image *api.ManifestImage, keys []*PublicKey) (string, error) {
+	digest, platforms, err := imageDigests(fromDir, image)
+	if err != nil {
+		return "", err
+	}
+	if image.ArtifactsPath == "" {
+		return "", fmt.Errorf("%w: no signature of %s", ErrUnsigned, digest)
+	}
+
+	signatures, err := loadSignatures(filepath.Join(fromDir, filepath.FromSlash(image.ArtifactsPath)), image.Artifacts)
+	if err != nil {
+		return "", fmt.Errorf("could not read signatures: %w", err)
+	}
+
+	// the platform images are verified only if the image index itself is unsigned, e.g. re-made for the platforms pulled
+	signer, err := verifyDigest(digest, signatures[digest], keys)
+	if err == nil || len(platforms) == 0 || !errors.Is(err, ErrUnsigned) {
+		return signer, err
+	}
+	for _, platform := range platforms {
+		var perr error
+		if signer, perr = verifyDigest(platform, signatures[platform], keys); perr != nil {
+			if errors.Is(perr, ErrUnsigned) {
+				return "", err
+			}
+			return "", perr
+		}
+	}
+	return signer, nil
+}
+
+// imageDigests returns the digest of the image in the bundle, and the digests of its platform images if it's an image index,
+// where the digest is computed from the manifest in the OCI image layout, instead of trusting the bundle manifest
+func imageDigests(fromDir string, image *api.ManifestImage) (string, []string, error) {
+	// the image left out of the delta bundle is verified by the digest it's pulled with
+	if image.Path == "" || strings.HasSuffix(image.Path, ".tar") {
+		if image.Digest == "" {
+			return "", nil, fmt.Errorf("%w: no digest of %s in the bundle", ErrUnsigned, image.Ref)
+		}
+		return image.Digest, nil, nil
+	}
+
+	lp, err := layout.FromPath(filepath.Join(fromDir, filepath.FromSlash(image.Path)))
+	if err != nil {
+		return "", nil, err
+	}
+	index, err := lp.ImageIndex()
+	if err != nil {
+		return "", nil, err
+	}
+	im, err := index.IndexManifest()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(im.Manifests) != 1 {
+		return "", nil, fmt.Errorf("expected 1 image in %s, but found %d", image.Path, len(im.Manifests))
+	}
+
+	desc := im.Manifests[0]
+	raw, err := lp.Bytes(desc.Digest)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(raw)
+	if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != desc.Digest.String() {
+		return "", nil, fmt.Errorf("digest mismatch of %s, expected %s, got %s", image.Path, desc.Digest, actual)
+	}
+	if !desc.MediaType.IsIndex() {
+		return desc.Digest.String(), nil, nil
+	}
+
+	ii, err := v1.ParseIndexManifest(bytes.NewReader(raw))
+	if err != nil {
+		return "", nil, err
+	}
+	platforms := []string{}
+	for _, m := range ii.Manifests {
+		platforms = append(platforms, m.Digest.String())
+	}
+	return desc.Digest.String(), platforms, nil
+}
+
+// loadSignatures loads the signatures of the cosign signature artifacts in the OCI image layout, by the digests they're for
+func loadSignatures(artifactsPath string, artifacts []*api.ManifestArtifact) (map[string][]*signature, error) {
+	lp, err := layout.FromPath(artifactsPath)
+	if err != nil {
+		return nil, err
+	}
+	index, err := lp.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	signatures := map[string][]*signature{}
+	for _, artifact := range artifacts {
+		if artifact.Kind != api.ArtifactSignature {
+			continue
+		}
+		hash, err := v1.NewHash(artifact.Digest)
+		if err != nil {
+			return nil, err
+		}
+		img, err := index.Image(hash)
+		if err != nil {
+			return nil, err
+		}
+		m, err := img.Manifest()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, desc := range m.Layers {
+			if desc.MediaType != simpleSigningMediaType {
+				continue
+			}
+			sig, err := base64.StdEncoding.DecodeString(desc.Annotations[signatureAnnotation])
+			if err != nil {
+				return nil, fmt.Errorf("invalid signature in %s: %w", artifact.Tag, err)
+			}
+			layer, err := img.LayerByDigest(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			rc, err := layer.Compressed()
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			signatures[artifact.Subject] = append(signatures[artifact.Subject], &signature{payload: data, sig: sig})
+		}
+	}
+	return signatures, nil
+}
+
+// verifyDigest verifies the signatures of the digest, where any signature by any of the keys, of the payload for the digest, is enough
+func verifyDigest(digest string, signatures []*signature, keys []*PublicKey) (string, error) {
+	if len(signatures) == 0 {
+		return "", fmt.Errorf("%w: no signature of %s", ErrUnsigned, digest)
+	}
+
+	failures := []error{}
+	for _, s := range signatures {
+		for _, key := range keys {
+			if err := verifySignature(key.Key, s.payload, s.sig); err != nil {
+				failures = append(failures, fmt.Errorf("%s: %w", key.Name, err))
+				continue
+			}
+
+			// the payload signed must be for the digest, so that a signature can't be reused for another image
+			var p payload
+			if err := json.Unmarshal(s.payload, &p); err != nil {
+				failures = append(failures, fmt.Errorf("%s: invalid payload: %w", key.Name, err))
+				continue
+			}
+			if p.Critical.Type != signatureType || p.Critical.Image.DockerManifestDigest != digest {
+				failures = append(failures, fmt.Errorf("%s: payload for %s, instead of %s", key.Name, p.Critical.Image.DockerManifestDigest, digest))
+				continue
+			}
+			return key.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no valid signature of %s: %w", digest, errors.Join(failures...))
+}
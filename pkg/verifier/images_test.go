@@ -0,0 +1,189 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+)
+
+// testKey generates an ECDSA key, as `cosign generate-key-pair` does, and loads its public key from cosign.pub
+func testKey(t *testing.T) (*ecdsa.PrivateKey, []*PublicKey) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadPublicKeys([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, keys
+}
+
+// signatureImage makes the cosign signature artifact, which signs the payload for the digest
+func signatureImage(t *testing.T, priv *ecdsa.PrivateKey, digest string) v1.Image {
+	t.Helper()
+
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"docker.io/bitnami/nginx"},`+
+		`"image":{"docker-manifest-digest":%q},"type":%q},"optional":null}`, digest, signatureType))
+	sum := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, simpleSigningMediaType),
+		Annotations: map[string]string{signatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestVerifyImage(t *testing.T) {
+	priv, keys := testKey(t)
+
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDigest, err := other.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := v1.ImageIndex(empty.Index)
+	platforms := []string{}
+	for _, arch := range []string{"amd64", "arm64"} {
+		p, err := random.Image(256, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := p.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		platforms = append(platforms, digest.String())
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        p,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+
+	tests := []struct {
+		name    string
+		image   v1.Image
+		index   v1.ImageIndex
+		signed  []string
+		wantErr bool
+		// unsigned tells the error is ErrUnsigned, rather than a signature which is invalid
+		unsigned bool
+	}{
+		{"signed", img, nil, []string{imgDigest.String()}, false, false},
+		{"payload for another digest", img, nil, []string{otherDigest.String()}, true, false},
+		{"unsigned", img, nil, nil, true, true},
+		{"index unsigned, platforms signed", nil, index, platforms, false, false},
+		{"index unsigned, a platform unsigned", nil, index, platforms[:1], true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			lp, err := layout.Write(filepath.Join(dir, "images", "nginx"), empty.Index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.index != nil {
+				err = lp.AppendIndex(tt.index)
+			} else {
+				err = lp.AppendImage(tt.image)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			image := &api.ManifestImage{Ref: "docker.io/bitnami/nginx:1.25", Path: "images/nginx"}
+
+			if len(tt.signed) > 0 {
+				ap, err := layout.Write(filepath.Join(dir, "artifacts", "nginx"), empty.Index)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, subject := range tt.signed {
+					sig := signatureImage(t, priv, subject)
+					if err := ap.AppendImage(sig); err != nil {
+						t.Fatal(err)
+					}
+					digest, err := sig.Digest()
+					if err != nil {
+						t.Fatal(err)
+					}
+					// the signature of another digest is left as the signature of the image, as if it's reused
+					if tt.image != nil {
+						subject = imgDigest.String()
+					}
+					image.Artifacts = append(image.Artifacts, &api.ManifestArtifact{
+						Kind:    api.ArtifactSignature,
+						Tag:     strings.Replace(subject, ":", "-", 1) + ".sig",
+						Digest:  digest.String(),
+						Subject: subject,
+					})
+				}
+				image.ArtifactsPath = "artifacts/nginx"
+			}
+
+			signer, err := VerifyImage(dir, image, keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if unsigned := errors.Is(err, ErrUnsigned); unsigned != tt.unsigned {
+					t.Errorf("VerifyImage() error = %v, unsigned %v, want %v", err, unsigned, tt.unsigned)
+				}
+				return
+			}
+			if signer != "cosign.pub" {
+				t.Errorf("signer = %s, want cosign.pub", signer)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PublicKey is the public key to verify the signatures by, named after its file, e.g. cosign.pub
+type PublicKey struct {
+	Name string
+	Key  crypto.PublicKey
+}
+
+// LoadPublicKeys loads the PEM encoded public keys, e.g. cosign.pub of `cosign generate-key-pair`,
+// which are ECDSA, RSA or Ed25519 keys
+func LoadPublicKeys(paths []string) ([]*PublicKey, error) {
+	keys := []*PublicKey{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read public key %s: %w", path, err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("invalid public key %s: no PEM encoded PUBLIC KEY", path)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported public key %s: %T", path, key)
+		}
+		keys = append(keys, &PublicKey{Name: filepath.Base(path), Key: key})
+	}
+	return keys, nil
+}
+
+// verifySignature verifies the signature of the payload, which is signed over its SHA-256 digest as cosign does
+func verifySignature(key crypto.PublicKey, payload, sig []byte) error {
+	digest := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key %T", key)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright © 2023 Bright Zheng <bright.zheng@outlook.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/brightzheng100/helm-packager/pkg/api"
+	"github.com/brightzheng100/helm-packager/pkg/utils"
+)
+
+type Verifier struct {
+	fromDir string
+
+	imageSignatureKeys []*PublicKey
+
+	report *api.Report
+}
+
+// NewVerifier creates a verifier which verifies the bundle directory offline
+func NewVerifier(fromDir string) *Verifier {
+	return &Verifier{
+		fromDir: fromDir,
+		report:  utils.NewReport(),
+	}
+}
+
+// WithImageSignatureKeys verifies the cosign signatures of the images by the public keys,
+// where every image must be signed by any of them
+func (v *Verifier) WithImageSignatureKeys(keys []*PublicKey) *Verifier {
+	v.imageSignatureKeys = keys
+	return v
+}
+
+// WithReport records into the report, e.g. of the push verifying the images before they're pushed, instead of its own
+func (v *Verifier) WithReport(report *api.Report) *Verifier {
+	v.report = report
+	return v
+}
+
+// Verify verifies the bundle, and fails with every image unsigned or mis-signed, which are listed in the report
+func (v *Verifier) Verify() error {
+	if len(v.imageSignatureKeys) == 0 {
+		return errors.New("nothing to verify, with no public key of the image signatures")
+	}
+
+	manifest, err := utils.ReadBundle(v.fromDir)
+	if err != nil {
+		return fmt.Errorf("could not read bundle %s: %w", v.fromDir, err)
+	}
+
+	failures := v.VerifyImages(manifest.Charts)
+	utils.RecordFailures(v.report, failures)
+	utils.PrintReport(v.report)
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d image(s) unsigned or mis-signed: %w", len(failures), errors.Join(failures...))
+	}
+	return nil
+}
+
+// VerifyImages verifies the signatures of the images of the charts, with the signers recorded in the report,
+// and returns the failures of the images unsigned or mis-signed
+func (v *Verifier) VerifyImages(charts []*api.ManifestChart) []error {
+	failures := []error{}
+	for _, chart := range charts {
+		for _, image := range chart.Images {
+			signer, err := VerifyImage(v.fromDir, image, v.imageSignatureKeys)
+			if err != nil {
+				failures = append(failures, &api.ImageError{Chart: chart.Name, Image: image.Ref, Stage: api.StageVerify, Err: err})
+				continue
+			}
+			utils.AddRecord(v.report, chart.Name, "signature", fmt.Sprintf("%s: signed by %s", image.Ref, signer))
+		}
+	}
+	return failures
+}